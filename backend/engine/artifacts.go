@@ -0,0 +1,156 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+// ErrArtifactNotFound is returned when a build has no artifact of the requested name.
+var ErrArtifactNotFound = errors.New("artifact not found")
+
+// ArtifactEntry is an artifact as listed by project.listArtifacts.
+type ArtifactEntry struct {
+	BuildID   string     `json:"build_id"`
+	Release   bool       `json:"release"`
+	CreatedAt *time.Time `json:"created_at,omitempty"` // when the build finished
+	plugin.Artifact
+}
+
+// listArtifacts returns the artifacts of a project's builds, newest first,
+// and their total size.
+func (e *Engine) listArtifacts(projectID string) ([]ArtifactEntry, int64) {
+	entries := []ArtifactEntry{}
+	var total int64
+	for _, rec := range e.builds.list(projectID) {
+		for _, a := range rec.Artifacts {
+			entries = append(entries, ArtifactEntry{BuildID: rec.BuildID, Release: rec.Release, CreatedAt: rec.FinishedAt, Artifact: a})
+			total += a.Size
+		}
+	}
+	return entries, total
+}
+
+// deleteArtifacts deletes the artifact called name of a build together with
+// its checksums and signatures, or all of its artifacts if name is empty,
+// and drops them from the build record.
+func (e *Engine) deleteArtifacts(rec BuildRecord, name string) (Reclaimed, error) {
+	e.artifactMu.Lock()
+	defer e.artifactMu.Unlock()
+	return e.deleteArtifactsLocked(rec.BuildID, name)
+}
+
+// deleteArtifactsLocked is deleteArtifacts for callers holding e.artifactMu.
+// Files that are already gone count as deleted.
+func (e *Engine) deleteArtifactsLocked(buildID, name string) (Reclaimed, error) {
+	rec, found := e.builds.get(buildID)
+	if !found {
+		return Reclaimed{}, fmt.Errorf("build %s not found", buildID)
+	}
+	reclaimed := Reclaimed{What: "artifacts", ProjectID: rec.ProjectID, DistroID: rec.DistroID, BuildID: rec.BuildID}
+
+	var keep []plugin.Artifact
+	var deleteErr error
+	matched := false
+	for _, a := range rec.Artifacts {
+		if name != "" && a.Name != name && !strings.HasPrefix(a.Name, name+".") {
+			keep = append(keep, a)
+			continue
+		}
+		matched = true
+		if err := os.Remove(a.Path); err != nil && !os.IsNotExist(err) {
+			keep = append(keep, a)
+			deleteErr = fmt.Errorf("failed to delete %s: %w", a.Name, err)
+			continue
+		}
+		reclaimed.Bytes += a.Size
+		// Plugins keep the artifacts of a build in a directory of its own.
+		if dir := filepath.Dir(a.Path); filepath.Base(dir) == rec.BuildID {
+			os.Remove(dir) // fails harmlessly while other artifacts are left
+		}
+	}
+	if !matched {
+		return reclaimed, ErrArtifactNotFound
+	}
+	if _, err := e.builds.update(buildID, func(r *BuildRecord) { r.Artifacts = keep }); err != nil {
+		return reclaimed, err
+	}
+	return reclaimed, deleteErr
+}
+
+// applyRetention deletes the artifacts that the retention rules don't keep:
+// of every project the artifacts of the e.keepBuilds newest builds that
+// aren't releases are kept, and the oldest artifacts are deleted while all of
+// them together take more than e.maxArtifactBytes. Builds marked as releases
+// and the newest build of every project are always kept; releases don't
+// count towards e.keepBuilds.
+func (e *Engine) applyRetention() []Reclaimed {
+	if e.keepBuilds <= 0 && e.maxArtifactBytes <= 0 {
+		return nil
+	}
+	e.artifactMu.Lock()
+	defer e.artifactMu.Unlock()
+
+	var expired, left []BuildRecord // newest first
+	var total int64
+	builds := make(map[string]int)  // builds with artifacts seen per project
+	counted := make(map[string]int) // of them, builds that aren't releases
+	for _, rec := range e.builds.withArtifacts() {
+		builds[rec.ProjectID]++
+		for _, a := range rec.Artifacts {
+			total += a.Size
+		}
+		if rec.Release {
+			continue
+		}
+		counted[rec.ProjectID]++
+		switch {
+		case e.keepBuilds > 0 && counted[rec.ProjectID] > e.keepBuilds:
+			expired = append(expired, rec)
+		case builds[rec.ProjectID] > 1:
+			left = append(left, rec)
+		}
+	}
+
+	var deleted []Reclaimed
+	remove := func(rec BuildRecord) {
+		reclaimed, err := e.deleteArtifactsLocked(rec.BuildID, "")
+		if err != nil {
+			log.Printf("Failed to delete artifacts of build %s: %v", rec.BuildID, err)
+			reclaimed.Error = err.Error()
+		} else {
+			log.Printf("Deleted artifacts of build %s, %s freed", rec.BuildID, plugin.FormatBytes(uint64(reclaimed.Bytes)))
+		}
+		total -= reclaimed.Bytes
+		deleted = append(deleted, reclaimed)
+	}
+
+	for _, rec := range expired {
+		remove(rec)
+	}
+	for i := len(left) - 1; i >= 0 && e.maxArtifactBytes > 0 && total > e.maxArtifactBytes; i-- {
+		remove(left[i])
+	}
+	return deleted
+}
+
+// withArtifacts returns the builds of all projects that still have
+// artifacts, newest first.
+func (s *buildStore) withArtifacts() []BuildRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var list []BuildRecord
+	for _, rec := range s.records {
+		if len(rec.Artifacts) > 0 {
+			list = append(list, rec)
+		}
+	}
+	sortNewestFirst(list)
+	return list
+}
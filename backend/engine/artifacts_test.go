@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"example.com/jsonrpcengine/plugin"
+	"example.com/jsonrpcengine/plugin/plugintest"
+)
+
+func TestArtifactRetention(t *testing.T) {
+	e := newTestEngine(t)
+	e.keepBuilds = 2
+	e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "engine.createProject", Params: []byte(`{"distro_id":"fake"}`), ID: 1})
+	e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "engine.createProject", Params: []byte(`{"distro_id":"fake"}`), ID: 2})
+	build := func(projectID string) string {
+		t.Helper()
+		resp := e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "project.buildIso", Params: []byte(`{"project_id":"` + projectID + `","skip_preflight":true}`), ID: 3})
+		if resp.Error != nil {
+			t.Fatalf("buildIso failed: %+v", resp.Error)
+		}
+		e.queue.Wait()
+		return resp.Result.(plugin.BuildResponse).BuildID
+	}
+	withArtifacts := func(projectID string) []string {
+		t.Helper()
+		var ids []string
+		artifacts, _ := e.listArtifacts(projectID)
+		for _, a := range artifacts {
+			if a.Kind == "iso" {
+				ids = append(ids, a.BuildID)
+			}
+		}
+		return ids
+	}
+
+	release := build("project-1")
+	if resp := e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "project.markRelease", Params: []byte(`{"project_id":"project-1","build_id":"` + release + `"}`), ID: 4}); resp.Error != nil {
+		t.Fatalf("markRelease failed: %+v", resp.Error)
+	}
+	other := build("project-2")
+	second, third, fourth := build("project-1"), build("project-1"), build("project-1")
+
+	if got, want := withArtifacts("project-1"), []string{fourth, third, release}; !reflect.DeepEqual(got, want) {
+		t.Errorf("project-1 keeps the artifacts of %v, want %v", got, want)
+	}
+	if got, want := withArtifacts("project-2"), []string{other}; !reflect.DeepEqual(got, want) {
+		t.Errorf("project-2 keeps the artifacts of %v, want %v", got, want)
+	}
+	if rec, _ := e.builds.get(second); rec.statusResponse().DownloadURL != "" {
+		t.Errorf("build %s still has a download URL after its artifacts were deleted", second)
+	}
+
+	// A newest build that is a release doesn't take the place of another.
+	e.keepBuilds = 0
+	fifth := build("project-1")
+	if resp := e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "project.markRelease", Params: []byte(`{"project_id":"project-1","build_id":"` + fifth + `"}`), ID: 5}); resp.Error != nil {
+		t.Fatalf("markRelease failed: %+v", resp.Error)
+	}
+	e.keepBuilds = 2
+	e.gc()
+	if got, want := withArtifacts("project-1"), []string{fifth, fourth, third, release}; !reflect.DeepEqual(got, want) {
+		t.Errorf("project-1 keeps the artifacts of %v with a newest release, want %v", got, want)
+	}
+
+	// With a size cap, only releases and every project's newest build are safe.
+	e.maxArtifactBytes = 2 * plugintest.FakeISOSize
+	report := e.gc()
+	var deleted []string
+	for _, item := range report.Items {
+		if item.What == "artifacts" {
+			deleted = append(deleted, item.BuildID)
+		}
+	}
+	if want := []string{third, fourth}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("gc deleted the artifacts of %v, want %v", deleted, want)
+	}
+	if got, want := withArtifacts("project-1"), []string{fifth, release}; !reflect.DeepEqual(got, want) {
+		t.Errorf("project-1 keeps the artifacts of %v after gc, want %v", got, want)
+	}
+}
@@ -0,0 +1,304 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+// Build statuses as reported by project.getBuildStatus and the build history.
+const (
+	StatusQueued    = "queued"
+	StatusBuilding  = "building"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// BuildRecord is the persisted history entry of a single build.
+type BuildRecord struct {
+	BuildID      string            `json:"build_id"`
+	ProjectID    string            `json:"project_id"`
+	DistroID     string            `json:"distro_id"`
+	Status       string            `json:"status"`
+	Stage        string            `json:"stage,omitempty"`
+	Progress     int               `json:"progress,omitempty"`
+	ErrorMessage string            `json:"error_message,omitempty"`
+	QueuedAt     time.Time         `json:"queued_at"`
+	StartedAt    *time.Time        `json:"started_at,omitempty"`
+	FinishedAt   *time.Time        `json:"finished_at,omitempty"`
+	ExitCode     *int              `json:"exit_code,omitempty"`
+	ToolVersions map[string]string `json:"tool_versions,omitempty"`
+	ConfigHash   string            `json:"config_hash,omitempty"`
+	Artifacts    []plugin.Artifact `json:"artifacts,omitempty"`
+	// Release builds keep their artifacts regardless of the retention rules.
+	Release     bool     `json:"release,omitempty"`
+	LogPath     string   `json:"log_path"`
+	CommandLine []string `json:"command_line,omitempty"`
+	Environment []string `json:"environment,omitempty"`
+	// Reproducible is set for builds made in reproducible mode, which keep
+	// a copy of their configuration and the hashes of their images.
+	Reproducible *plugin.Reproducible `json:"reproducible,omitempty"`
+	OutputHashes map[string]string    `json:"output_hashes,omitempty"`
+	// RebuildOf names the build a project.rebuild repeated, and
+	// Verification how the outcome compares to it.
+	RebuildOf    string        `json:"rebuild_of,omitempty"`
+	Verification *Verification `json:"verification,omitempty"`
+}
+
+// finished reports whether the build has reached a final status.
+func (r BuildRecord) finished() bool {
+	return r.Status == StatusCompleted || r.Status == StatusFailed || r.Status == StatusCancelled
+}
+
+// statusResponse converts the record to the project.getBuildStatus result.
+func (r BuildRecord) statusResponse() plugin.BuildStatusResponse {
+	status := plugin.BuildStatusResponse{
+		BuildID:      r.BuildID,
+		Status:       r.Status,
+		Progress:     r.Progress,
+		Stage:        r.Stage,
+		ErrorMessage: r.ErrorMessage,
+		CommandLine:  r.CommandLine,
+		Environment:  r.Environment,
+	}
+	for _, a := range r.Artifacts {
+		if a.DownloadURL != "" {
+			status.DownloadURL = a.DownloadURL
+			break
+		}
+	}
+	return status
+}
+
+// buildStore keeps the build history on disk, one directory per build:
+//
+//	<dir>/<build_id>/build.json   the BuildRecord
+//	<dir>/<build_id>/output.log   everything the build tools printed
+//	<dir>/<build_id>/manifest.json the packages in the image, if the plugin reported them
+//	<dir>/<build_id>/inputs.json   the configuration the build used, likewise
+//	<dir>/<build_id>/config/       a copy of that configuration, for reproducible builds
+//
+// All records are loaded into memory when the store is opened.
+type buildStore struct {
+	dir string
+
+	mu      sync.Mutex
+	records map[string]BuildRecord // keyed by build ID
+}
+
+// openBuildStore loads the history kept in dir. Builds that were still queued
+// or running when the previous engine stopped are marked as failed.
+func openBuildStore(dir string, now time.Time) (*buildStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create build history directory %s: %w", dir, err)
+	}
+	s := &buildStore{dir: dir, records: make(map[string]BuildRecord)}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read build history: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name(), "build.json"))
+		if err != nil {
+			log.Printf("Skipping build %s: %v", entry.Name(), err)
+			continue
+		}
+		var rec BuildRecord
+		if err := json.Unmarshal(data, &rec); err != nil || rec.BuildID != entry.Name() {
+			log.Printf("Skipping build %s: invalid build record", entry.Name())
+			continue
+		}
+		if !rec.finished() {
+			rec.Status = StatusFailed
+			rec.ErrorMessage = "The engine stopped before the build finished"
+			rec.FinishedAt = &now
+			if err := s.saveLocked(rec); err != nil {
+				log.Printf("Failed to update build %s: %v", rec.BuildID, err)
+			}
+		}
+		s.records[rec.BuildID] = rec
+	}
+	return s, nil
+}
+
+// create adds a queued build for a project. Build IDs are made of the project
+// ID and the queue time, with a counter appended if that isn't unique yet.
+func (s *buildStore) create(projectID, distroID string, now time.Time, setup func(*BuildRecord)) (BuildRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	base := fmt.Sprintf("%s-%s", projectID, now.UTC().Format("20060102-150405"))
+	buildID := base
+	for n := 2; ; n++ {
+		if _, taken := s.records[buildID]; !taken {
+			break
+		}
+		buildID = fmt.Sprintf("%s-%d", base, n)
+	}
+
+	if err := os.MkdirAll(filepath.Join(s.dir, buildID), 0755); err != nil {
+		return BuildRecord{}, fmt.Errorf("failed to create build directory: %w", err)
+	}
+	rec := BuildRecord{
+		BuildID:   buildID,
+		ProjectID: projectID,
+		DistroID:  distroID,
+		Status:    StatusQueued,
+		QueuedAt:  now,
+		LogPath:   filepath.Join(s.dir, buildID, "output.log"),
+	}
+	if setup != nil {
+		setup(&rec)
+	}
+	if err := s.saveLocked(rec); err != nil {
+		return BuildRecord{}, err
+	}
+	s.records[buildID] = rec
+	return rec, nil
+}
+
+// remove deletes a build and everything stored for it.
+func (s *buildStore) remove(buildID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, buildID)
+	return os.RemoveAll(filepath.Join(s.dir, buildID))
+}
+
+// get returns the record of a build.
+func (s *buildStore) get(buildID string) (BuildRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, found := s.records[buildID]
+	return rec, found
+}
+
+// update applies fn to a build's record and persists the result.
+func (s *buildStore) update(buildID string, fn func(*BuildRecord)) (BuildRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, found := s.records[buildID]
+	if !found {
+		return BuildRecord{}, fmt.Errorf("build %s not found", buildID)
+	}
+	fn(&rec)
+	s.records[buildID] = rec
+	return rec, s.saveLocked(rec)
+}
+
+// list returns the builds of a project, newest first.
+func (s *buildStore) list(projectID string) []BuildRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []BuildRecord{}
+	for _, rec := range s.records {
+		if rec.ProjectID == projectID {
+			list = append(list, rec)
+		}
+	}
+	sortNewestFirst(list)
+	return list
+}
+
+// sortNewestFirst sorts builds by queue time, most recent first.
+func sortNewestFirst(list []BuildRecord) {
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].QueuedAt.Equal(list[j].QueuedAt) {
+			return list[i].QueuedAt.After(list[j].QueuedAt)
+		}
+		return list[i].BuildID > list[j].BuildID
+	})
+}
+
+// latest returns the most recently queued build of a project.
+func (s *buildStore) latest(projectID string) (BuildRecord, bool) {
+	list := s.list(projectID)
+	if len(list) == 0 {
+		return BuildRecord{}, false
+	}
+	return list[0], true
+}
+
+// saveManifest stores the package manifest of a build.
+func (s *buildStore) saveManifest(buildID string, m plugin.Manifest) error {
+	return s.saveExtra(buildID, "manifest", m)
+}
+
+// manifest returns the package manifest of a build, or false if there is none.
+func (s *buildStore) manifest(buildID string) (plugin.Manifest, bool, error) {
+	var m plugin.Manifest
+	found, err := s.loadExtra(buildID, "manifest", &m)
+	return m, found, err
+}
+
+// saveInputs stores the description of the configuration a build used.
+func (s *buildStore) saveInputs(buildID string, in plugin.Inputs) error {
+	return s.saveExtra(buildID, "inputs", in)
+}
+
+// inputs returns the configuration a build used, or false if the plugin
+// didn't describe it.
+func (s *buildStore) inputs(buildID string) (plugin.Inputs, bool, error) {
+	var in plugin.Inputs
+	found, err := s.loadExtra(buildID, "inputs", &in)
+	return in, found, err
+}
+
+// saveExtra stores what the plugin reported about a build, besides the
+// record itself, in <build_id>/<what>.json.
+func (s *buildStore) saveExtra(buildID, what string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, buildID, what+".json"), data, 0644); err != nil {
+		return fmt.Errorf("failed to save %s of build %s: %w", what, buildID, err)
+	}
+	return nil
+}
+
+// loadExtra reads what saveExtra stored into v, or returns false if there
+// is nothing.
+func (s *buildStore) loadExtra(buildID, what string, v interface{}) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, buildID, what+".json"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s of build %s: %w", what, buildID, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("invalid %s of build %s: %w", what, buildID, err)
+	}
+	return true, nil
+}
+
+// saveLocked writes a record to disk, replacing the previous version
+// atomically. Callers must hold s.mu.
+func (s *buildStore) saveLocked(rec BuildRecord) error {
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, rec.BuildID, "build.json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to save build %s: %w", rec.BuildID, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to save build %s: %w", rec.BuildID, err)
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+// BuildDiff is what changed from build A to build B, as reported by
+// project.diffBuilds.
+type BuildDiff struct {
+	BuildA   string          `json:"build_a"`
+	BuildB   string          `json:"build_b"`
+	Packages PackageDiff     `json:"packages"`
+	Files    FileDiff        `json:"files"`
+	Settings []SettingChange `json:"settings"`
+	// Incomplete explains what couldn't be compared, e.g. because a build
+	// failed before the plugin could list its packages.
+	Incomplete []string `json:"incomplete,omitempty"`
+}
+
+// PackageDiff compares the packages installed into two images.
+type PackageDiff struct {
+	Added      []PackageChange `json:"added"`
+	Removed    []PackageChange `json:"removed"`
+	Upgraded   []PackageChange `json:"upgraded"`
+	Downgraded []PackageChange `json:"downgraded"`
+}
+
+// PackageChange is a package whose version changed from From to To. Added
+// packages have no From, removed packages no To.
+type PackageChange struct {
+	Name string `json:"name"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// FileDiff lists the paths of the files that differ between two builds.
+type FileDiff struct {
+	Added    []string `json:"added"`
+	Removed  []string `json:"removed"`
+	Modified []string `json:"modified"`
+}
+
+// SettingChange is a setting whose value changed from From to To. Settings
+// only one of the builds had have no From or To.
+type SettingChange struct {
+	Name string `json:"name"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// diffBuilds compares two builds by the manifests and inputs the plugin
+// reported for them.
+func (e *Engine) diffBuilds(a, b BuildRecord) (BuildDiff, error) {
+	diff := BuildDiff{
+		BuildA:   a.BuildID,
+		BuildB:   b.BuildID,
+		Packages: PackageDiff{Added: []PackageChange{}, Removed: []PackageChange{}, Upgraded: []PackageChange{}, Downgraded: []PackageChange{}},
+		Files:    FileDiff{Added: []string{}, Removed: []string{}, Modified: []string{}},
+		Settings: []SettingChange{},
+	}
+
+	var manifests [2]plugin.Manifest
+	var inputs [2]plugin.Inputs
+	manifestsFound, inputsFound := true, true
+	for i, rec := range []BuildRecord{a, b} {
+		var found bool
+		var err error
+		manifests[i], found, err = e.builds.manifest(rec.BuildID)
+		if err != nil {
+			return diff, err
+		}
+		if !found {
+			diff.Incomplete = append(diff.Incomplete, fmt.Sprintf("Build '%s' has no package manifest", rec.BuildID))
+			manifestsFound = false
+		}
+		inputs[i], found, err = e.builds.inputs(rec.BuildID)
+		if err != nil {
+			return diff, err
+		}
+		if !found {
+			diff.Incomplete = append(diff.Incomplete, fmt.Sprintf("Build '%s' has no record of its settings and files", rec.BuildID))
+			inputsFound = false
+		}
+	}
+
+	if manifestsFound {
+		diff.Packages = diffPackages(manifests[0].Packages, manifests[1].Packages)
+	}
+	if inputsFound {
+		diff.Files = diffFiles(inputs[0].Files, inputs[1].Files)
+		for _, name := range changedKeys(inputs[0].Settings, inputs[1].Settings) {
+			diff.Settings = append(diff.Settings, SettingChange{Name: name, From: inputs[0].Settings[name], To: inputs[1].Settings[name]})
+		}
+	}
+	return diff, nil
+}
+
+// diffPackages compares two package lists.
+func diffPackages(a, b []plugin.ManifestPackage) PackageDiff {
+	versionsA, versionsB := packageVersions(a), packageVersions(b)
+
+	diff := PackageDiff{Added: []PackageChange{}, Removed: []PackageChange{}, Upgraded: []PackageChange{}, Downgraded: []PackageChange{}}
+	for _, name := range changedKeys(versionsA, versionsB) {
+		change := PackageChange{Name: name, From: versionsA[name], To: versionsB[name]}
+		_, inA := versionsA[name]
+		_, inB := versionsB[name]
+		switch {
+		case !inA:
+			diff.Added = append(diff.Added, change)
+		case !inB:
+			diff.Removed = append(diff.Removed, change)
+		case compareVersions(change.From, change.To) < 0:
+			diff.Upgraded = append(diff.Upgraded, change)
+		case compareVersions(change.From, change.To) > 0:
+			diff.Downgraded = append(diff.Downgraded, change)
+		}
+		// Versions that only differ in spelling, e.g. "1.01" and "1.1",
+		// are the same version.
+	}
+	return diff
+}
+
+// packageVersions maps package names to versions.
+func packageVersions(packages []plugin.ManifestPackage) map[string]string {
+	versions := make(map[string]string, len(packages))
+	for _, pkg := range packages {
+		versions[pkg.Name] = pkg.Version
+	}
+	return versions
+}
+
+// diffFiles compares two sets of files described by plugin.HashFiles.
+func diffFiles(a, b map[string]string) FileDiff {
+	diff := FileDiff{Added: []string{}, Removed: []string{}, Modified: []string{}}
+	for _, path := range changedKeys(a, b) {
+		_, inA := a[path]
+		_, inB := b[path]
+		switch {
+		case !inA:
+			diff.Added = append(diff.Added, path)
+		case !inB:
+			diff.Removed = append(diff.Removed, path)
+		default:
+			diff.Modified = append(diff.Modified, path)
+		}
+	}
+	return diff
+}
+
+// changedKeys returns the sorted keys that are in only one of a and b or
+// have different values.
+func changedKeys(a, b map[string]string) []string {
+	var keys []string
+	for k, v := range a {
+		if w, found := b[k]; !found || v != w {
+			keys = append(keys, k)
+		}
+	}
+	for k := range b {
+		if _, found := a[k]; !found {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// compareVersions orders package versions like pacman's vercmp: it returns
+// a negative number if a is older than b, a positive number if it is newer
+// and 0 if they are the same version. Versions have the form
+// [epoch:]version[-release]; the release is only compared if both have one.
+func compareVersions(a, b string) int {
+	if a == b {
+		return 0
+	}
+	epochA, versionA, releaseA := splitVersion(a)
+	epochB, versionB, releaseB := splitVersion(b)
+	if c := compareSegments(epochA, epochB); c != 0 {
+		return c
+	}
+	if c := compareSegments(versionA, versionB); c != 0 {
+		return c
+	}
+	if releaseA != "" && releaseB != "" {
+		return compareSegments(releaseA, releaseB)
+	}
+	return 0
+}
+
+// splitVersion splits [epoch:]version[-release] into its parts. The epoch
+// defaults to "0".
+func splitVersion(v string) (epoch, version, release string) {
+	epoch = "0"
+	digits := strings.IndexFunc(v, func(r rune) bool { return !isDigit(byte(r)) })
+	if digits >= 0 && v[digits] == ':' {
+		epoch, v = v[:digits], v[digits+1:]
+		if epoch == "" {
+			epoch = "0"
+		}
+	}
+	if i := strings.LastIndexByte(v, '-'); i >= 0 {
+		return epoch, v[:i], v[i+1:]
+	}
+	return epoch, v, ""
+}
+
+// compareSegments is rpmvercmp: versions are compared segment by segment,
+// where a segment is a run of digits, compared numerically, or of letters,
+// compared alphabetically. Numeric segments are newer than alphabetic ones,
+// so "1.a" < "1.1", and an alphabetic suffix makes a version older, so
+// "1.0rc1" < "1.0".
+func compareSegments(a, b string) int {
+	if a == b {
+		return 0
+	}
+	i, j := 0, 0
+	for {
+		// Separators only matter by their length.
+		startA, startB := i, j
+		for i < len(a) && !isAlnum(a[i]) {
+			i++
+		}
+		for j < len(b) && !isAlnum(b[j]) {
+			j++
+		}
+		if i == len(a) || j == len(b) {
+			break
+		}
+		if i-startA != j-startB {
+			if i-startA < j-startB {
+				return -1
+			}
+			return 1
+		}
+
+		startA, startB = i, j
+		numeric := isDigit(a[i])
+		same := isAlpha
+		if numeric {
+			same = isDigit
+		}
+		for i < len(a) && same(a[i]) {
+			i++
+		}
+		for j < len(b) && same(b[j]) {
+			j++
+		}
+		segA, segB := a[startA:i], b[startB:j]
+		if segB == "" {
+			// The segments are of different types.
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+		if numeric {
+			segA, segB = strings.TrimLeft(segA, "0"), strings.TrimLeft(segB, "0")
+			if len(segA) != len(segB) {
+				if len(segA) < len(segB) {
+					return -1
+				}
+				return 1
+			}
+		}
+		if c := strings.Compare(segA, segB); c != 0 {
+			return c
+		}
+	}
+
+	restA, restB := a[i:], b[j:]
+	if restA == "" && restB == "" {
+		return 0
+	}
+	// Whatever is left decides, but a remaining alphabetic segment never
+	// beats an empty one.
+	if restA == "" && !isAlpha(restB[0]) || restA != "" && isAlpha(restA[0]) {
+		return -1
+	}
+	return 1
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+func isAlpha(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+func isAlnum(c byte) bool { return isDigit(c) || isAlpha(c) }
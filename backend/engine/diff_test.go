@@ -0,0 +1,52 @@
+package engine
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	// Cases from pacman's vercmp tests.
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.5.0", "1.5.0", 0},
+		{"1.5.1", "1.5.0", 1},
+		{"1.5.1", "1.5", 1},
+		{"1.5.0", "1.5", 1},
+		{"1.5", "1.5.", 0},
+		{"1.0", "1.0a", 1},
+		{"1.0a", "1.0b", -1},
+		{"1.0a", "1.0.0", -1},
+		{"1.0rc1", "1.0", -1},
+		{"1.0alpha", "1.0beta", -1},
+		{"1.01", "1.1", 0},
+		{"1.10", "1.9", 1},
+		{"1.0.a", "1.0.1", -1},
+		{"1.5-1", "1.5-2", -1},
+		{"1.5-1", "1.5", 0},
+		{"1.5.b-1", "1.5.b", 0},
+		{"1:1.0-1", "2.0-1", 1},
+		{"0:1.0", "1.0", 0},
+		{"1:1.0", "2:0.5", -1},
+		{"1.0", "1_0", 0},
+		{"1.0", "1..0", -1},
+		{"6.9.1.arch1-1", "6.10.arch1-1", -1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); sign(got) != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareVersions(tt.b, tt.a); sign(got) != -tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
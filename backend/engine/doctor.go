@@ -0,0 +1,214 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+// DoctorReport is the result of engine.doctor.
+type DoctorReport struct {
+	Healthy  bool             `json:"healthy"` // false if errors remain that weren't fixed
+	Findings []plugin.Finding `json:"findings"`
+}
+
+// doctor checks the data directory, the host requirements and state of every
+// plugin and the project registry. With fix set, problems that have a safe
+// automatic fix are fixed: missing directories are created, permissions
+// tightened, leftover mounts unmounted and orphaned projects registered again.
+func (e *Engine) doctor(ctx context.Context, fix bool) DoctorReport {
+	findings := e.checkDataDir(fix)
+
+	var building []string
+	for _, b := range e.queue.List() {
+		if b.State == BuildRunning {
+			building = append(building, b.ProjectID)
+		}
+	}
+
+	for _, details := range e.plugins.GetAvailablePlugins() {
+		p, _ := e.plugins.GetPlugin(details.ID)
+		diagnoser, ok := p.(plugin.Diagnoser)
+		if !ok {
+			continue
+		}
+		for _, f := range diagnoser.Diagnose(ctx, plugin.DiagnoseOptions{Fix: fix, Building: building}) {
+			f.Message = fmt.Sprintf("%s: %s", details.ID, f.Message)
+			findings = append(findings, f)
+		}
+		findings = append(findings, e.checkRegistry(details.ID, diagnoser, fix)...)
+	}
+
+	report := DoctorReport{Healthy: true, Findings: findings}
+	if report.Findings == nil {
+		report.Findings = []plugin.Finding{}
+	}
+	for _, f := range findings {
+		if f.Severity == plugin.SeverityError && !f.Fixed {
+			report.Healthy = false
+		}
+	}
+	return report
+}
+
+// checkDataDir checks that the data directory and the engine's own
+// subdirectories exist, belong to the engine's user and aren't writable by others.
+func (e *Engine) checkDataDir(fix bool) []plugin.Finding {
+	var findings []plugin.Finding
+	uid := os.Geteuid()
+	for _, dir := range []string{e.dataDir, filepath.Join(e.dataDir, "builds")} {
+		info, err := os.Stat(dir)
+		if os.IsNotExist(err) {
+			f := plugin.Finding{Check: "data_dir", Severity: plugin.SeverityError, Message: fmt.Sprintf("Directory %s is missing", dir), Fix: fmt.Sprintf("mkdir -p %s", dir), AutoFix: true}
+			if fix {
+				f.Fixed = os.MkdirAll(dir, 0755) == nil
+			}
+			findings = append(findings, f)
+			continue
+		}
+		if err != nil {
+			findings = append(findings, plugin.Finding{Check: "data_dir", Severity: plugin.SeverityError, Message: fmt.Sprintf("Cannot access %s: %v", dir, err)})
+			continue
+		}
+		if !info.IsDir() {
+			findings = append(findings, plugin.Finding{Check: "data_dir", Severity: plugin.SeverityError, Message: fmt.Sprintf("%s is not a directory", dir), Fix: fmt.Sprintf("Move %s out of the way", dir)})
+			continue
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != uid {
+			findings = append(findings, plugin.Finding{Check: "permissions", Severity: plugin.SeverityError, Message: fmt.Sprintf("%s is owned by uid %d, not by the engine's user (uid %d)", dir, st.Uid, uid), Fix: fmt.Sprintf("sudo chown -R %d %s", uid, e.dataDir)})
+		} else if syscall.Access(dir, 2 /* W_OK */) != nil {
+			findings = append(findings, plugin.Finding{Check: "permissions", Severity: plugin.SeverityError, Message: fmt.Sprintf("%s is not writable", dir), Fix: fmt.Sprintf("chmod u+w %s", dir)})
+		}
+		if mode := info.Mode().Perm(); mode&0022 != 0 {
+			f := plugin.Finding{Check: "permissions", Severity: plugin.SeverityWarning, Message: fmt.Sprintf("%s is writable by other users (mode %04o)", dir, mode), Fix: fmt.Sprintf("chmod go-w %s", dir), AutoFix: true}
+			if fix {
+				f.Fixed = os.Chmod(dir, mode&^0022) == nil
+			}
+			findings = append(findings, f)
+		}
+	}
+
+	// Build tools run as root and may leave files the engine can't clean up.
+	// Work directories are skipped: they are meant to be owned by root.
+	for _, sub := range []string{"projects", "isos", "builds"} {
+		root := filepath.Join(e.dataDir, sub)
+		var foreign []string
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != uid {
+				foreign = append(foreign, path)
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		})
+		if len(foreign) > 0 {
+			findings = append(findings, plugin.Finding{
+				Check:    "permissions",
+				Severity: plugin.SeverityWarning,
+				Message:  fmt.Sprintf("%d entries below %s belong to another user, e.g. %s", len(foreign), root, foreign[0]),
+				Fix:      fmt.Sprintf("sudo chown -R %d %s", uid, root),
+			})
+		}
+	}
+	return findings
+}
+
+// projectsOnDisk returns the projects the plugins keep data for, registered
+// or not. Plugins that can't list their projects are skipped.
+func (e *Engine) projectsOnDisk() map[string]bool {
+	onDisk := make(map[string]bool)
+	for _, details := range e.plugins.GetAvailablePlugins() {
+		p, _ := e.plugins.GetPlugin(details.ID)
+		diagnoser, ok := p.(plugin.Diagnoser)
+		if !ok {
+			continue
+		}
+		ids, err := diagnoser.ProjectIDs()
+		if err != nil {
+			log.Printf("Failed to list the projects of %s: %v", details.ID, err)
+			continue
+		}
+		for _, id := range ids {
+			onDisk[id] = true
+		}
+	}
+	return onDisk
+}
+
+// checkRegistry compares the projects a plugin keeps data for with the
+// project registry. Orphaned projects are registered again when fixing;
+// otherwise a new project could be given the same ID and overwrite them.
+func (e *Engine) checkRegistry(distroID string, diagnoser plugin.Diagnoser, fix bool) []plugin.Finding {
+	ids, err := diagnoser.ProjectIDs()
+	if err != nil {
+		return []plugin.Finding{{Check: "registry", Severity: plugin.SeverityWarning, Message: fmt.Sprintf("%s: could not list projects: %v", distroID, err)}}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var findings []plugin.Finding
+	onDisk := make(map[string]bool)
+	adopted := false
+	for _, id := range ids {
+		onDisk[id] = true
+		meta, registered := e.projects[id]
+		switch {
+		case !registered:
+			f := plugin.Finding{
+				Check:    "registry",
+				Severity: plugin.SeverityWarning,
+				Message:  fmt.Sprintf("%s: project directory %s is not in the project registry", distroID, id),
+				Fix:      "Register the project again",
+				AutoFix:  true,
+			}
+			if fix {
+				e.projects[id] = ProjectMetadata{ID: id, DistroID: distroID}
+				f.Fixed, adopted = true, true
+			}
+			findings = append(findings, f)
+		case meta.DistroID != distroID:
+			findings = append(findings, plugin.Finding{
+				Check:    "registry",
+				Severity: plugin.SeverityWarning,
+				Message:  fmt.Sprintf("%s: project %s has data here but is registered as a %s project", distroID, id, meta.DistroID),
+			})
+		}
+	}
+
+	var missing []string
+	for id, meta := range e.projects {
+		if meta.DistroID == distroID && !onDisk[id] {
+			missing = append(missing, id)
+		}
+	}
+	sort.Strings(missing)
+	for _, id := range missing {
+		findings = append(findings, plugin.Finding{
+			Check:    "registry",
+			Severity: plugin.SeverityWarning,
+			Message:  fmt.Sprintf("%s: project %s is registered but its directory is missing", distroID, id),
+		})
+	}
+
+	if adopted {
+		if err := e.saveProjectsLocked(); err != nil {
+			log.Printf("Failed to save project registry: %v", err)
+		}
+	}
+	return findings
+}
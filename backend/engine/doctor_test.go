@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"example.com/jsonrpcengine/plugin"
+	"example.com/jsonrpcengine/plugin/plugintest"
+	"example.com/jsonrpcengine/plugin/runner"
+)
+
+func TestCreateProjectSkipsOrphanedProjects(t *testing.T) {
+	dataDir := t.TempDir()
+	fake := plugintest.NewFakePlugin("fake", filepath.Join(dataDir, "isos"), runner.NewFake(nil))
+	pm := plugin.NewPluginManager()
+	pm.RegisterPlugin("fake", fake)
+	e, err := New(pm, Config{DataDir: dataDir, Clock: testClock()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A project of an older version, which kept no registry.
+	if err := fake.CreateProject("project-1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "engine.createProject", Params: json.RawMessage(`{"distro_id":"fake"}`), ID: 1})
+	if result, _ := resp.Result.(map[string]string); result["project_id"] != "project-2" {
+		t.Errorf("createProject with an orphaned project-1 returned %+v, want project-2", resp.Result)
+	}
+	if _, found := e.lookupProject("project-1"); found {
+		t.Errorf("orphaned project registered by createProject")
+	}
+}
+
+func TestDoctorRegistersOrphanedProjects(t *testing.T) {
+	dataDir := t.TempDir()
+	fake := plugintest.NewFakePlugin("fake", filepath.Join(dataDir, "isos"), runner.NewFake(nil))
+	pm := plugin.NewPluginManager()
+	pm.RegisterPlugin("fake", fake)
+	e, err := New(pm, Config{DataDir: dataDir, Clock: testClock()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "engine.createProject", Params: json.RawMessage(`{"distro_id":"fake"}`), ID: 1}); resp.Error != nil {
+		t.Fatalf("createProject failed: %v", resp.Error.Message)
+	}
+	// A project the plugin knows about but the registry lost, e.g. after
+	// projects.json was deleted.
+	if err := fake.CreateProject("project-3", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dataDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	checks := func(report DoctorReport) map[string]plugin.Finding {
+		got := make(map[string]plugin.Finding)
+		for _, f := range report.Findings {
+			got[f.Check] = f
+		}
+		return got
+	}
+
+	report := e.doctor(context.Background(), false)
+	got := checks(report)
+	// The fake runner has no fake-build tool, so the host check fails.
+	if report.Healthy || got["host_tools"].Severity != plugin.SeverityError {
+		t.Errorf("missing host tool not reported as error: %+v", report)
+	}
+	if f := got["registry"]; f.Message != "fake: project directory project-3 is not in the project registry" || f.Fixed {
+		t.Errorf("registry finding = %+v", f)
+	}
+	if f := got["permissions"]; f.Severity != plugin.SeverityWarning || f.Fixed {
+		t.Errorf("permissions finding = %+v", f)
+	}
+	if _, found := e.lookupProject("project-3"); found {
+		t.Fatal("doctor without fix registered the orphaned project")
+	}
+
+	got = checks(e.doctor(context.Background(), true))
+	if !got["registry"].Fixed || !got["permissions"].Fixed {
+		t.Errorf("fixable findings not fixed: %+v", got)
+	}
+	if meta, found := e.lookupProject("project-3"); !found || meta.DistroID != "fake" {
+		t.Errorf("orphaned project not registered again: %+v", meta)
+	}
+	if info, err := os.Stat(dataDir); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("data dir mode not fixed: %v %v", info.Mode(), err)
+	}
+
+	// New projects must not reuse the ID of a project registered again.
+	resp := e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "engine.createProject", Params: json.RawMessage(`{"distro_id":"fake"}`), ID: 2})
+	if result, _ := resp.Result.(map[string]string); result["project_id"] != "project-4" {
+		t.Errorf("createProject after doctor returned %+v, want project-4", resp.Result)
+	}
+
+	// The registry change survives a restart.
+	e2, err := New(pm, Config{DataDir: dataDir, Clock: testClock()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := e2.lookupProject("project-3"); !found {
+		t.Error("registered project lost after restart")
+	}
+}
@@ -0,0 +1,693 @@
+// Package engine implements the DistroForge JSON-RPC engine: it keeps the
+// project registry, dispatches requests to distro plugins and writes
+// responses and stream notifications to a Transport.
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/jsonrpcengine/plugin"
+	"example.com/jsonrpcengine/plugin/runner"
+)
+
+// ProjectMetadata stores basic info about a project, including its distro type.
+type ProjectMetadata struct {
+	ID       string `json:"id"`
+	DistroID string `json:"distro_id"`
+	// Other project-specific metadata can be stored here
+}
+
+// Engine serves the JSON-RPC API on top of a set of registered plugins.
+type Engine struct {
+	plugins       *plugin.PluginManager
+	dataDir       string
+	now           func() time.Time
+	buildTimeout  time.Duration
+	workdirPolicy string
+	signing       SigningConfig
+	runner        runner.Runner // runs the engine's own commands, e.g. signing tools
+
+	// Artifact retention, see applyRetention. artifactMu serializes deletions.
+	keepBuilds       int
+	maxArtifactBytes int64
+	artifactMu       sync.Mutex
+
+	// projects is the project registry, persisted to projects.json in the data dir.
+	mu       sync.Mutex
+	projects map[string]ProjectMetadata
+
+	queue  *BuildQueue
+	builds *buildStore
+
+	// outputs holds the live output of builds that haven't finished yet.
+	outputMu sync.Mutex
+	outputs  map[string]*buildOutput
+
+	// workdirLocks holds a *sync.Mutex per project ID, see workdirLock.
+	workdirLocks sync.Map
+
+	// streams tracks goroutines still forwarding build output to a client.
+	streams sync.WaitGroup
+}
+
+// Config holds engine settings.
+type Config struct {
+	// MaxConcurrentBuilds limits how many builds run at once across all
+	// projects. Values below 1 mean one build at a time.
+	MaxConcurrentBuilds int
+
+	// DataDir is where the engine keeps its project registry and build
+	// history. It is shared with the plugins, which use their own subdirectories.
+	DataDir string
+
+	// Clock returns the current time. Defaults to time.Now.
+	Clock func() time.Time
+
+	// BuildTimeout stops builds that run longer than this, the same way
+	// project.cancelBuild does. Zero means no timeout.
+	BuildTimeout time.Duration
+
+	// WorkdirPolicy decides whether a project's work directory is removed
+	// after a build: WorkdirClean, WorkdirKeepFailed (the default) or WorkdirKeep.
+	WorkdirPolicy string
+
+	// KeepBuilds is how many of its newest builds' artifacts every project
+	// keeps; builds marked as releases are kept in addition. Zero keeps all.
+	KeepBuilds int
+	// MaxArtifactBytes caps the total size of all artifacts; the oldest are
+	// deleted first, except releases and every project's newest build. Zero means no cap.
+	MaxArtifactBytes int64
+
+	// Signing configures detached signatures of the ISOs builds produce.
+	Signing SigningConfig
+	// Runner runs the engine's own commands, such as the signing tool.
+	// Defaults to runner.Direct.
+	Runner runner.Runner
+}
+
+var (
+	// ErrBuildCancelled is the cause of a build context cancelled through project.cancelBuild.
+	ErrBuildCancelled = errors.New("build cancelled")
+	// ErrBuildTimedOut is the cause of a build context cancelled by Config.BuildTimeout.
+	ErrBuildTimedOut = errors.New("build timed out")
+)
+
+// New creates an engine serving the plugins registered in pm. Projects and
+// builds recorded in cfg.DataDir by a previous engine are loaded.
+func New(pm *plugin.PluginManager, cfg Config) (*Engine, error) {
+	e := &Engine{
+		plugins:          pm,
+		dataDir:          cfg.DataDir,
+		now:              cfg.Clock,
+		buildTimeout:     cfg.BuildTimeout,
+		workdirPolicy:    cfg.WorkdirPolicy,
+		signing:          cfg.Signing,
+		runner:           cfg.Runner,
+		keepBuilds:       cfg.KeepBuilds,
+		maxArtifactBytes: cfg.MaxArtifactBytes,
+		projects:         make(map[string]ProjectMetadata),
+		outputs:          make(map[string]*buildOutput),
+	}
+	if e.now == nil {
+		e.now = time.Now
+	}
+	if e.runner == nil {
+		e.runner = runner.Direct{}
+	}
+	if err := e.signing.check(e.runner); err != nil {
+		return nil, err
+	}
+	switch e.workdirPolicy {
+	case "":
+		e.workdirPolicy = WorkdirKeepFailed
+	case WorkdirClean, WorkdirKeepFailed, WorkdirKeep:
+	default:
+		return nil, fmt.Errorf("unknown work directory policy %q (expected %s, %s or %s)", e.workdirPolicy, WorkdirClean, WorkdirKeepFailed, WorkdirKeep)
+	}
+	if err := e.loadProjects(); err != nil {
+		return nil, err
+	}
+	builds, err := openBuildStore(filepath.Join(cfg.DataDir, "builds"), e.now())
+	if err != nil {
+		return nil, err
+	}
+	e.builds = builds
+	e.queue = NewBuildQueue(cfg.MaxConcurrentBuilds, e.runBuild, e.now)
+	return e, nil
+}
+
+// projectsFile returns the path of the persisted project registry.
+func (e *Engine) projectsFile() string {
+	return filepath.Join(e.dataDir, "projects.json")
+}
+
+// loadProjects reads the project registry, if one has been saved before.
+func (e *Engine) loadProjects() error {
+	data, err := os.ReadFile(e.projectsFile())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read project registry: %w", err)
+	}
+	var projects []ProjectMetadata
+	if err := json.Unmarshal(data, &projects); err != nil {
+		return fmt.Errorf("failed to parse project registry %s: %w", e.projectsFile(), err)
+	}
+	for _, meta := range projects {
+		e.projects[meta.ID] = meta
+	}
+	return nil
+}
+
+// saveProjectsLocked writes the project registry. Callers must hold e.mu.
+func (e *Engine) saveProjectsLocked() error {
+	projects := make([]ProjectMetadata, 0, len(e.projects))
+	for _, meta := range e.projects {
+		projects = append(projects, meta)
+	}
+	sort.Slice(projects, func(i, j int) bool { return projects[i].ID < projects[j].ID })
+	data, err := json.MarshalIndent(projects, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(e.dataDir, 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	tmp := e.projectsFile() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to save project registry: %w", err)
+	}
+	return os.Rename(tmp, e.projectsFile())
+}
+
+// runBuild hands a build that left the queue to the project's plugin and
+// records the outcome in the build history. ctx is cancelled with
+// ErrBuildCancelled when the build is cancelled.
+func (e *Engine) runBuild(ctx context.Context, b QueuedBuild) error {
+	if e.buildTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, e.buildTimeout, ErrBuildTimedOut)
+		defer cancel()
+	}
+
+	started := *b.StartedAt
+	rec, err := e.builds.update(b.BuildID, func(r *BuildRecord) {
+		r.Status = StatusBuilding
+		r.StartedAt = &started
+	})
+	if err != nil {
+		return err
+	}
+
+	lock := e.workdirLock(b.ProjectID)
+	lock.Lock()
+	result, buildErr := e.executeBuild(ctx, rec)
+	e.applyWorkdirPolicy(rec, buildErr)
+	lock.Unlock()
+	var hashes map[string]string
+	var verification *Verification
+	if rec.Reproducible != nil && buildErr == nil {
+		hashes = outputHashes(result.Artifacts)
+		if orig, found := e.builds.get(rec.RebuildOf); found {
+			v := e.verifyRebuild(orig, hashes, result)
+			verification = &v
+			e.reportVerification(rec, v)
+		}
+	}
+	if result.Manifest != nil {
+		if err := e.builds.saveManifest(b.BuildID, *result.Manifest); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+	if result.Inputs != nil {
+		if err := e.builds.saveInputs(b.BuildID, *result.Inputs); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+
+	finished := e.now()
+	final, err := e.builds.update(b.BuildID, func(r *BuildRecord) {
+		r.FinishedAt = &finished
+		r.ToolVersions = result.ToolVersions
+		r.ConfigHash = result.ConfigHash
+		r.Artifacts = result.Artifacts
+		r.CommandLine = result.CommandLine
+		r.Environment = result.Environment
+		r.OutputHashes = hashes
+		r.Verification = verification
+		if len(result.CommandLine) > 0 {
+			exitCode := result.ExitCode
+			r.ExitCode = &exitCode
+		}
+		switch cause := context.Cause(ctx); {
+		case buildErr == nil:
+			r.Status = StatusCompleted
+			r.Progress = 100
+		case errors.Is(cause, ErrBuildCancelled):
+			r.Status = StatusCancelled
+			r.ErrorMessage = "Build cancelled"
+		case errors.Is(cause, ErrBuildTimedOut):
+			r.Status = StatusFailed
+			r.ErrorMessage = fmt.Sprintf("Build timed out after %s", e.buildTimeout)
+		default:
+			r.Status = StatusFailed
+			r.ErrorMessage = buildErr.Error()
+		}
+	})
+	if err != nil {
+		log.Printf("Failed to record outcome of build %s: %v", b.BuildID, err)
+	}
+	e.closeOutput(final)
+	if final.Status == StatusCompleted {
+		e.applyRetention()
+	}
+	return buildErr
+}
+
+// executeBuild runs the plugin's build with its output going to the build log.
+func (e *Engine) executeBuild(ctx context.Context, rec BuildRecord) (plugin.BuildResult, error) {
+	p, found := e.plugins.GetPlugin(rec.DistroID)
+	if !found {
+		return plugin.BuildResult{}, fmt.Errorf("plugin %s not found", rec.DistroID)
+	}
+	output := e.output(rec.BuildID)
+	if output == nil {
+		return plugin.BuildResult{}, fmt.Errorf("output of build %s is gone", rec.BuildID)
+	}
+
+	req := plugin.BuildRequest{
+		ProjectID: rec.ProjectID,
+		BuildID:   rec.BuildID,
+		Output:    output,
+		Progress: func(progress plugin.Progress) {
+			updated, err := e.builds.update(rec.BuildID, func(r *BuildRecord) {
+				r.Stage = progress.Stage
+				r.Progress = progress.Percent
+			})
+			if err != nil {
+				log.Printf("Failed to record progress of build %s: %v", rec.BuildID, err)
+			}
+			output.publish(updated)
+		},
+		Reproducible: rec.Reproducible,
+	}
+	if rec.Reproducible != nil {
+		req.SaveConfigTo = e.builds.configDir(rec.BuildID)
+	}
+	if rec.RebuildOf != "" {
+		req.ConfigFrom = e.builds.configDir(rec.RebuildOf)
+	}
+	result, err := p.BuildISO(ctx, req)
+	if err != nil {
+		return result, err
+	}
+	result.Artifacts, err = e.sealArtifacts(ctx, result.Artifacts, output)
+	return result, err
+}
+
+// Serve reads requests from t until the client disconnects, writing one
+// response per request. Queued builds and output streams started by the
+// client are drained before Serve returns, so a client that closes its end
+// right after project.buildIso or project.streamBuildOutput still gets the
+// build and the whole log.
+func (e *Engine) Serve(t Transport) error {
+	defer e.streams.Wait()
+	defer e.queue.Wait()
+	for {
+		msg, err := t.ReadMessage()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		e.handleMessage(t, msg)
+	}
+}
+
+// Handle processes a single request and returns its response. Follow-up
+// messages such as stream notifications are discarded; use Serve to get them.
+func (e *Engine) Handle(req JSONRPCRequest) JSONRPCResponse {
+	resp, _ := e.handleRequest(req)
+	return resp
+}
+
+// handleMessage parses one raw message, dispatches it and writes the response.
+// Work that produces further messages (streaming) is started only after the
+// response has been written, so clients always see the response first.
+func (e *Engine) handleMessage(t Transport, msg []byte) {
+	var req JSONRPCRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		e.send(t, JSONRPCResponse{JSONRPC: "2.0", Error: &RPCError{Code: ParseErrorCode, Message: "Parse error", Data: err.Error()}})
+		return
+	}
+
+	if req.JSONRPC != "2.0" {
+		e.send(t, errorResponse(req, &RPCError{Code: InvalidRequestCode, Message: "Invalid Request", Data: "Invalid JSON-RPC version"}))
+		return
+	}
+
+	resp, followUp := e.handleRequest(req)
+	e.send(t, resp)
+	if followUp != nil {
+		e.streams.Add(1)
+		go func() {
+			defer e.streams.Done()
+			followUp(t)
+		}()
+	}
+}
+
+// send marshals v and writes it to the transport.
+func (e *Engine) send(t Transport, v interface{}) {
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error marshalling response: %v", err)
+		resp, ok := v.(JSONRPCResponse)
+		if !ok {
+			return
+		}
+		// Fallback error response
+		fallbackResp := JSONRPCResponse{
+			JSONRPC: "2.0",
+			Error: &RPCError{
+				Code:    InternalErrorCode,
+				Message: "Internal error marshalling response",
+			},
+			ID: resp.ID, // Try to use original ID
+		}
+		jsonData, _ = json.Marshal(fallbackResp)
+	}
+	if err := t.WriteMessage(jsonData); err != nil {
+		log.Printf("Error writing message: %v", err)
+	}
+}
+
+// followUpFunc writes additional messages for a request after its response.
+type followUpFunc func(t Transport)
+
+func (e *Engine) handleRequest(req JSONRPCRequest) (JSONRPCResponse, followUpFunc) {
+	parts := strings.SplitN(req.Method, ".", 2)
+	if len(parts) != 2 {
+		return errorResponse(req, &RPCError{Code: MethodNotFoundCode, Message: "Invalid method format. Expected 'namespace.method'"}), nil
+	}
+	namespace, method := parts[0], parts[1]
+
+	switch namespace {
+	case "engine":
+		return e.handleEngineCommands(req, method), nil
+	case "project":
+		return e.handleProjectCommands(req, method)
+	default:
+		if _, found := e.plugins.GetPlugin(namespace); found {
+			return e.handlePluginCommands(req, namespace, method), nil
+		}
+		return errorResponse(req, &RPCError{Code: MethodNotFoundCode, Message: fmt.Sprintf("Namespace '%s' not found", namespace)}), nil
+	}
+}
+
+func (e *Engine) handleEngineCommands(req JSONRPCRequest, method string) JSONRPCResponse {
+	switch method {
+	case "getDistroPlugins":
+		distros := e.plugins.GetAvailablePlugins()
+		return JSONRPCResponse{JSONRPC: "2.0", Result: map[string]interface{}{"distros": distros}, ID: req.ID}
+
+	case "listBuildQueue":
+		return JSONRPCResponse{JSONRPC: "2.0", Result: map[string]interface{}{"builds": e.queue.List()}, ID: req.ID}
+
+	case "reprioritizeBuild":
+		var params struct {
+			BuildID  string `json:"build_id"`
+			Position *int   `json:"position"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "Invalid params for reprioritizeBuild", Data: err.Error()})
+		}
+		if params.BuildID == "" || params.Position == nil {
+			return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "Missing build_id or position"})
+		}
+		entry, err := e.queue.Move(params.BuildID, *params.Position)
+		if err != nil {
+			return errorResponse(req, &RPCError{Code: BuildNotFoundCode, Message: fmt.Sprintf("Build '%s' is not queued", params.BuildID)})
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: entry, ID: req.ID}
+
+	case "cancelQueuedBuild":
+		var params struct {
+			BuildID string `json:"build_id"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "Invalid params for cancelQueuedBuild", Data: err.Error()})
+		}
+		if params.BuildID == "" {
+			return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "Missing build_id"})
+		}
+		if err := e.cancelQueued(params.BuildID); err != nil {
+			return errorResponse(req, &RPCError{Code: BuildNotFoundCode, Message: fmt.Sprintf("Build '%s' is not queued", params.BuildID)})
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: map[string]bool{"success": true}, ID: req.ID}
+
+	case "gc":
+		return JSONRPCResponse{JSONRPC: "2.0", Result: e.gc(), ID: req.ID}
+
+	case "doctor":
+		var params struct {
+			Fix bool `json:"fix"`
+		}
+		if len(req.Params) > 0 {
+			if err := json.Unmarshal(req.Params, &params); err != nil {
+				return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "Invalid params for doctor", Data: err.Error()})
+			}
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: e.doctor(context.Background(), params.Fix), ID: req.ID}
+
+	case "listTemplates":
+		var params struct {
+			DistroID string `json:"distro_id"`
+		}
+		if len(req.Params) > 0 {
+			if err := json.Unmarshal(req.Params, &params); err != nil {
+				return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "Invalid params for listTemplates", Data: err.Error()})
+			}
+		}
+		if params.DistroID != "" {
+			if _, found := e.plugins.GetPlugin(params.DistroID); !found {
+				return errorResponse(req, &RPCError{Code: PluginNotFoundCode, Message: fmt.Sprintf("Distro plugin '%s' not found", params.DistroID)})
+			}
+		}
+		templates, rpcErr := e.listTemplates(params.DistroID)
+		if rpcErr != nil {
+			return errorResponse(req, rpcErr)
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: map[string]interface{}{"templates": templates}, ID: req.ID}
+
+	case "createProject":
+		var params struct {
+			DistroID        string `json:"distro_id"`
+			Template        string `json:"template"`
+			TemplateVersion int    `json:"template_version"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "Invalid params for createProject", Data: err.Error()})
+		}
+		if params.DistroID == "" {
+			return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "Missing distro_id"})
+		}
+
+		p, found := e.plugins.GetPlugin(params.DistroID)
+		if !found {
+			return errorResponse(req, &RPCError{Code: PluginNotFoundCode, Message: fmt.Sprintf("Distro plugin '%s' not found", params.DistroID)})
+		}
+		if params.TemplateVersion != 0 && params.Template == "" {
+			return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "template_version needs a template"})
+		}
+		if params.Template != "" {
+			if rpcErr := checkTemplate(p, params.DistroID, params.Template, params.TemplateVersion); rpcErr != nil {
+				return errorResponse(req, rpcErr)
+			}
+		}
+
+		// Generate a unique project ID. Projects registered again by
+		// engine.doctor may have taken numbers above the registry size,
+		// and the data of orphaned projects must not be overwritten.
+		onDisk := e.projectsOnDisk()
+		e.mu.Lock()
+		projectID := fmt.Sprintf("project-%d", len(e.projects)+1)
+		for n := len(e.projects) + 2; ; n++ {
+			if _, taken := e.projects[projectID]; !taken && !onDisk[projectID] {
+				break
+			}
+			projectID = fmt.Sprintf("project-%d", n)
+		}
+		e.projects[projectID] = ProjectMetadata{ID: projectID, DistroID: params.DistroID}
+		saveErr := e.saveProjectsLocked()
+		e.mu.Unlock()
+
+		// Any additional params are passed to the plugin as distro-specific creation options.
+		var createParams map[string]interface{}
+		_ = json.Unmarshal(req.Params, &createParams)
+
+		if err := p.CreateProject(projectID, createParams); err != nil {
+			// If plugin fails to create, remove metadata (or handle more gracefully)
+			e.mu.Lock()
+			delete(e.projects, projectID)
+			if err := e.saveProjectsLocked(); err != nil {
+				log.Printf("Failed to save project registry: %v", err)
+			}
+			e.mu.Unlock()
+			rpcErr := pluginErrorToRPC(err)
+			if rpcErr.Code == InternalErrorCode {
+				rpcErr.Message = fmt.Sprintf("Error creating project with plugin: %v", err)
+			}
+			return errorResponse(req, rpcErr)
+		}
+		if saveErr != nil {
+			log.Printf("Failed to save project registry: %v", saveErr)
+		}
+
+		return JSONRPCResponse{JSONRPC: "2.0", Result: map[string]string{"project_id": projectID}, ID: req.ID}
+
+	default:
+		return errorResponse(req, &RPCError{Code: MethodNotFoundCode, Message: fmt.Sprintf("Method '%s' not found in engine namespace", method)})
+	}
+}
+
+// cancelQueued removes a build from the queue before it started and records
+// it as cancelled.
+func (e *Engine) cancelQueued(buildID string) error {
+	if err := e.queue.Cancel(buildID); err != nil {
+		return err
+	}
+	cancelled := e.now()
+	final, err := e.builds.update(buildID, func(r *BuildRecord) {
+		r.Status = StatusCancelled
+		r.FinishedAt = &cancelled
+	})
+	if err != nil {
+		log.Printf("Failed to record cancellation of build %s: %v", buildID, err)
+	}
+	e.closeOutput(final)
+	return nil
+}
+
+// openOutput creates the log of a new build and keeps it available to
+// subscribers until the build has finished.
+func (e *Engine) openOutput(rec BuildRecord) error {
+	output, err := newBuildOutput(rec.LogPath, outputRingSize)
+	if err != nil {
+		return err
+	}
+	e.outputMu.Lock()
+	defer e.outputMu.Unlock()
+	e.outputs[rec.BuildID] = output
+	return nil
+}
+
+// output returns the live output of a build, or nil once it has finished.
+func (e *Engine) output(buildID string) *buildOutput {
+	e.outputMu.Lock()
+	defer e.outputMu.Unlock()
+	return e.outputs[buildID]
+}
+
+// closeOutput ends the output of a finished build. The final record must
+// already be stored, so that subscribers that no longer find the output
+// see the outcome in the build history.
+func (e *Engine) closeOutput(final BuildRecord) {
+	e.outputMu.Lock()
+	output := e.outputs[final.BuildID]
+	delete(e.outputs, final.BuildID)
+	e.outputMu.Unlock()
+	if output != nil {
+		output.close(final)
+	}
+}
+
+// cancelBuild stops a queued or running build. Queued builds are cancelled
+// right away; running builds are asked to stop without waiting for their
+// process tree and the plugin's cleanup, which can take a while, and report
+// the outcome in their final build event. It fails with ErrBuildNotRunning
+// if the build had already finished.
+func (e *Engine) cancelBuild(buildID string) (BuildRecord, error) {
+	if err := e.cancelQueued(buildID); err != nil {
+		if _, err := e.queue.Stop(buildID, ErrBuildCancelled); err != nil {
+			return BuildRecord{}, err
+		}
+	}
+	rec, _ := e.builds.get(buildID)
+	return rec, nil
+}
+
+// lookupProject returns the metadata of a registered project.
+func (e *Engine) lookupProject(projectID string) (ProjectMetadata, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	meta, found := e.projects[projectID]
+	return meta, found
+}
+
+// resolveProject decodes the params of a project-scoped request, extracts the
+// project_id and looks up the project and its plugin.
+func (e *Engine) resolveProject(req JSONRPCRequest) (map[string]interface{}, string, ProjectMetadata, plugin.DistroPlugin, *RPCError) {
+	var tempParams map[string]interface{}
+	if err := json.Unmarshal(req.Params, &tempParams); err != nil {
+		return nil, "", ProjectMetadata{}, nil, &RPCError{Code: InvalidParamsCode, Message: "Invalid params structure", Data: err.Error()}
+	}
+
+	projectIDInterface, ok := tempParams["project_id"]
+	if !ok {
+		return nil, "", ProjectMetadata{}, nil, &RPCError{Code: InvalidParamsCode, Message: "Missing project_id in params"}
+	}
+	projectID, ok := projectIDInterface.(string)
+	if !ok || projectID == "" {
+		return nil, "", ProjectMetadata{}, nil, &RPCError{Code: InvalidParamsCode, Message: "Invalid or empty project_id"}
+	}
+
+	meta, found := e.lookupProject(projectID)
+	if !found {
+		return nil, "", ProjectMetadata{}, nil, &RPCError{Code: ProjectNotFoundCode, Message: fmt.Sprintf("Project '%s' not found", projectID)}
+	}
+
+	p, found := e.plugins.GetPlugin(meta.DistroID)
+	if !found {
+		// This should ideally not happen if project creation was successful
+		return nil, "", ProjectMetadata{}, nil, &RPCError{Code: PluginNotFoundCode, Message: fmt.Sprintf("Plugin '%s' for project '%s' not found", meta.DistroID, projectID)}
+	}
+	return tempParams, projectID, meta, p, nil
+}
+
+// handlePluginCommands dispatches "<distro>.<method>" calls to plugins implementing
+// plugin.MethodProvider. The methods are project-scoped: the project must exist
+// and belong to the plugin owning the namespace.
+func (e *Engine) handlePluginCommands(req JSONRPCRequest, namespace string, method string) JSONRPCResponse {
+	handler, found := e.plugins.GetMethod(namespace, method)
+	if !found {
+		return errorResponse(req, &RPCError{Code: MethodNotFoundCode, Message: fmt.Sprintf("Method '%s' not found in %s namespace", method, namespace)})
+	}
+
+	_, projectID, meta, _, rpcErr := e.resolveProject(req)
+	if rpcErr != nil {
+		return errorResponse(req, rpcErr)
+	}
+	if meta.DistroID != namespace {
+		return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: fmt.Sprintf("Project '%s' is not a %s project", projectID, namespace)})
+	}
+
+	result, err := handler(projectID, req.Params)
+	if err != nil {
+		return errorResponse(req, pluginErrorToRPC(err))
+	}
+	return JSONRPCResponse{JSONRPC: "2.0", Result: result, ID: req.ID}
+}
@@ -0,0 +1,389 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/jsonrpcengine/plugin"
+	"example.com/jsonrpcengine/plugin/plugintest"
+	"example.com/jsonrpcengine/plugin/runner"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden transcripts in testdata/")
+
+// Golden transcripts live in testdata/*.golden. Lines starting with "-> " are
+// sent to a fresh engine in order; every message the engine writes in reply
+// (the response, then any stream notifications) is recorded as a "<- " line.
+// Builds started by a request run to completion before the next request.
+// Blank lines and "#" comments are kept as they are. Run
+//
+//	go test ./engine -update
+//
+// to regenerate the expected output after an intentional protocol change.
+const (
+	requestPrefix  = "-> "
+	responsePrefix = "<- "
+)
+
+// recordingTransport collects written messages in memory.
+type recordingTransport struct {
+	mu       sync.Mutex
+	messages [][]byte
+}
+
+func (r *recordingTransport) ReadMessage() ([]byte, error) {
+	return nil, fmt.Errorf("recordingTransport is write-only")
+}
+
+func (r *recordingTransport) WriteMessage(msg []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, append([]byte(nil), msg...))
+	return nil
+}
+
+func (r *recordingTransport) contains(s string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, msg := range r.messages {
+		if strings.Contains(string(msg), s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *recordingTransport) take() [][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	msgs := r.messages
+	r.messages = nil
+	return msgs
+}
+
+// testClock returns a clock that starts at 2024-01-01 00:00:00 UTC and
+// advances by one second every time it is read.
+func testClock() func() time.Time {
+	var mu sync.Mutex
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(time.Second)
+		return now
+	}
+}
+
+// newTestEngine returns an engine with two fake plugins, "fake" and "other",
+// keeping its state in a fresh temporary directory.
+func newTestEngine(t *testing.T) *Engine {
+	t.Helper()
+	return newTestEngineWithDataDir(t, t.TempDir())
+}
+
+func newTestEngineWithDataDir(t *testing.T, dataDir string) *Engine {
+	t.Helper()
+	fakeRunner := runner.NewFake(map[string]runner.FakeFunc{
+		plugintest.FakeBuildTool: func(ctx context.Context, cmd runner.Command) error {
+			fmt.Fprintf(cmd.Stdout, "building %s\n", cmd.Args[0])
+			fmt.Fprintln(cmd.Stdout, "done")
+			return nil
+		},
+	})
+	pm := plugin.NewPluginManager()
+	isoDir := filepath.Join(dataDir, "isos")
+	pm.RegisterPlugin("fake", plugintest.NewFakePlugin("fake", isoDir, fakeRunner))
+	pm.RegisterPlugin("other", plugintest.NewFakePlugin("other", isoDir, fakeRunner))
+	e, err := New(pm, Config{DataDir: dataDir, Clock: testClock()})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return e
+}
+
+// runTranscript replays the requests of a golden file and returns the
+// transcript the engine produces for them. The engine's data directory is
+// written as $DATA_DIR so that paths in responses are stable.
+func runTranscript(t *testing.T, e *Engine, golden []byte) []byte {
+	t.Helper()
+	transport := &recordingTransport{}
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(golden))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, responsePrefix) {
+			continue
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+		if !strings.HasPrefix(line, requestPrefix) {
+			continue
+		}
+
+		e.handleMessage(transport, []byte(strings.TrimPrefix(line, requestPrefix)))
+		e.queue.Wait()
+		e.streams.Wait()
+		for _, msg := range transport.take() {
+			out.WriteString(responsePrefix)
+			out.Write(bytes.ReplaceAll(msg, []byte(e.dataDir), []byte("$DATA_DIR")))
+			out.WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("failed to read transcript: %v", err)
+	}
+	return out.Bytes()
+}
+
+func TestGoldenTranscripts(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no golden transcripts found in testdata/")
+	}
+
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".golden")
+		t.Run(name, func(t *testing.T) {
+			want, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := runTranscript(t, newTestEngine(t), want)
+
+			if *update {
+				if err := os.WriteFile(file, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("transcript %s differs from golden file (run with -update to accept):\n%s", file, diffLines(want, got))
+			}
+		})
+	}
+}
+
+// diffLines reports the first line where the two transcripts diverge.
+func diffLines(want, got []byte) string {
+	wantLines := strings.Split(string(want), "\n")
+	gotLines := strings.Split(string(got), "\n")
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g {
+			return fmt.Sprintf("line %d:\n  want: %s\n  got:  %s", i+1, w, g)
+		}
+	}
+	return "(no difference)"
+}
+
+// A project whose plugin has disappeared can't be produced through the API,
+// so this error path of resolveProject is exercised directly.
+func TestProjectWithUnregisteredPlugin(t *testing.T) {
+	e := newTestEngine(t)
+	e.projects["project-1"] = ProjectMetadata{ID: "project-1", DistroID: "gone"}
+
+	resp := e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "project.getDetails", Params: []byte(`{"project_id":"project-1"}`), ID: 1})
+	if resp.Error == nil || resp.Error.Code != PluginNotFoundCode {
+		t.Fatalf("expected PluginNotFound error, got %+v", resp)
+	}
+}
+
+func TestServeWaitsForQueuedBuilds(t *testing.T) {
+	e := newTestEngine(t)
+	in := strings.NewReader(`{"jsonrpc":"2.0","method":"engine.createProject","params":{"distro_id":"fake"},"id":1}
+{"jsonrpc":"2.0","method":"project.buildIso","params":{"project_id":"project-1"},"id":2}`)
+	var out bytes.Buffer
+	if err := e.Serve(NewStdioTransport(in, &out)); err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], `"status":"queued"`) {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+	var built struct {
+		Result plugin.BuildResponse `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &built); err != nil {
+		t.Fatal(err)
+	}
+	// The input ended right after buildIso; Serve must still have let the build finish.
+	resp := e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "project.getBuildStatus", Params: []byte(`{"project_id":"project-1","build_id":"` + built.Result.BuildID + `"}`), ID: 3})
+	status, ok := resp.Result.(plugin.BuildStatusResponse)
+	if !ok || status.Status != "completed" {
+		t.Errorf("build should have completed before Serve returned, got %+v", resp)
+	}
+}
+
+func TestBuildIsoReturnsBuildInProgress(t *testing.T) {
+	release := make(chan struct{})
+	fakeRunner := runner.NewFake(map[string]runner.FakeFunc{
+		plugintest.FakeBuildTool: func(ctx context.Context, cmd runner.Command) error {
+			<-release
+			return nil
+		},
+	})
+	pm := plugin.NewPluginManager()
+	dataDir := t.TempDir()
+	pm.RegisterPlugin("fake", plugintest.NewFakePlugin("fake", filepath.Join(dataDir, "isos"), fakeRunner))
+	e, err := New(pm, Config{DataDir: dataDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "engine.createProject", Params: []byte(`{"distro_id":"fake"}`), ID: 1})
+	build := JSONRPCRequest{JSONRPC: "2.0", Method: "project.buildIso", Params: []byte(`{"project_id":"project-1"}`), ID: 2}
+	if resp := e.Handle(build); resp.Error != nil {
+		t.Fatalf("first build failed: %+v", resp.Error)
+	}
+	resp := e.Handle(build)
+	if resp.Error == nil || resp.Error.Code != BuildInProgressCode {
+		t.Fatalf("expected BuildInProgress error, got %+v", resp)
+	}
+
+	close(release)
+	e.queue.Wait()
+	if resp := e.Handle(build); resp.Error != nil {
+		t.Fatalf("build after the previous one finished failed: %+v", resp.Error)
+	}
+	e.queue.Wait()
+}
+
+func TestBuildHistorySurvivesRestart(t *testing.T) {
+	dataDir := t.TempDir()
+	e := newTestEngineWithDataDir(t, dataDir)
+	e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "engine.createProject", Params: []byte(`{"distro_id":"fake"}`), ID: 1})
+	resp := e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "project.buildIso", Params: []byte(`{"project_id":"project-1"}`), ID: 2})
+	buildID := resp.Result.(plugin.BuildResponse).BuildID
+	e.queue.Wait()
+
+	// A build that was running when the engine went away must not stay "building" forever.
+	stale, err := e.builds.create("project-1", "fake", time.Now(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newTestEngineWithDataDir(t, dataDir)
+	resp = restarted.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "project.getBuild", Params: []byte(`{"project_id":"project-1","build_id":"` + buildID + `"}`), ID: 3})
+	if resp.Error != nil {
+		t.Fatalf("getBuild after restart failed: %+v", resp.Error)
+	}
+	rec := resp.Result.(BuildRecord)
+	if rec.Status != StatusCompleted || rec.ExitCode == nil || *rec.ExitCode != 0 || len(rec.Artifacts) != 4 || rec.Artifacts[0].Checksums["sha256"] == "" {
+		t.Errorf("unexpected build record after restart: %+v", rec)
+	}
+	if log, err := os.ReadFile(rec.LogPath); err != nil || !strings.Contains(string(log), "done") {
+		t.Errorf("build log was not kept: %q, %v", log, err)
+	}
+
+	staleRec, _ := restarted.builds.get(stale.BuildID)
+	if staleRec.Status != StatusFailed {
+		t.Errorf("interrupted build has status %q after restart, want %q", staleRec.Status, StatusFailed)
+	}
+
+	resp = restarted.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "engine.createProject", Params: []byte(`{"distro_id":"fake"}`), ID: 4})
+	if got := resp.Result.(map[string]string)["project_id"]; got != "project-2" {
+		t.Errorf("new project after restart got ID %q, want project-2", got)
+	}
+}
+
+// newBlockingEngine returns an engine whose fake builds run until their context is cancelled.
+func newBlockingEngine(t *testing.T, cfg Config) *Engine {
+	t.Helper()
+	fakeRunner := runner.NewFake(map[string]runner.FakeFunc{
+		plugintest.FakeBuildTool: func(ctx context.Context, cmd runner.Command) error {
+			fmt.Fprintln(cmd.Stdout, "working")
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+	pm := plugin.NewPluginManager()
+	cfg.DataDir = t.TempDir()
+	pm.RegisterPlugin("fake", plugintest.NewFakePlugin("fake", filepath.Join(cfg.DataDir, "isos"), fakeRunner))
+	e, err := New(pm, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "engine.createProject", Params: []byte(`{"distro_id":"fake"}`), ID: 1})
+	return e
+}
+
+// waitForStatus polls the build record until it has the wanted status.
+func waitForStatus(t *testing.T, e *Engine, buildID, want string) BuildRecord {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec, _ := e.builds.get(buildID)
+		if rec.Status == want {
+			return rec
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("build %s has status %q, want %q", buildID, rec.Status, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCancelRunningBuild(t *testing.T) {
+	e := newBlockingEngine(t, Config{})
+	resp := e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "project.buildIso", Params: []byte(`{"project_id":"project-1"}`), ID: 2})
+	buildID := resp.Result.(plugin.BuildResponse).BuildID
+	waitForStatus(t, e, buildID, StatusBuilding)
+
+	transport := &recordingTransport{}
+	e.handleMessage(transport, []byte(`{"jsonrpc":"2.0","method":"project.streamBuildOutput","params":{"project_id":"project-1","build_id":"`+buildID+`"},"id":3}`))
+	progressEvent := `"type":"progress","project_id":"project-1","build_id":"` + buildID + `","status":"building","stage":"building","progress":50`
+	for deadline := time.Now().Add(5 * time.Second); !transport.contains(progressEvent); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("stream of a running build carried no progress event")
+		}
+	}
+
+	resp = e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "project.cancelBuild", Params: []byte(`{"project_id":"project-1","build_id":"` + buildID + `"}`), ID: 4})
+	if resp.Error != nil {
+		t.Fatalf("cancelBuild failed: %+v", resp.Error)
+	}
+	if status := resp.Result.(plugin.BuildStatusResponse); status.Status != StatusBuilding {
+		t.Errorf("cancelBuild returned status %q, want %q until the build has stopped", status.Status, StatusBuilding)
+	}
+	e.queue.Wait()
+	e.streams.Wait()
+	waitForStatus(t, e, buildID, StatusCancelled)
+
+	msgs := transport.take()
+	last := string(msgs[len(msgs)-1])
+	if !strings.Contains(last, `"method":"project.buildEvent"`) || !strings.Contains(last, `"status":"cancelled"`) {
+		t.Errorf("stream did not end with a cancelled build event: %s", last)
+	}
+}
+
+func TestBuildTimeoutStopsBuild(t *testing.T) {
+	e := newBlockingEngine(t, Config{BuildTimeout: 50 * time.Millisecond})
+	resp := e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "project.buildIso", Params: []byte(`{"project_id":"project-1"}`), ID: 2})
+	buildID := resp.Result.(plugin.BuildResponse).BuildID
+	e.queue.Wait()
+
+	rec := waitForStatus(t, e, buildID, StatusFailed)
+	if !strings.Contains(rec.ErrorMessage, "timed out") {
+		t.Errorf("timed out build has error message %q", rec.ErrorMessage)
+	}
+}
@@ -0,0 +1,40 @@
+package engine
+
+// BuildEventMethod is the notification method used for build events.
+const BuildEventMethod = "project.buildEvent"
+
+// Build event types.
+const (
+	// BuildEventProgress is sent when a running build's stage or progress changes.
+	BuildEventProgress = "progress"
+	// BuildEventFinished is sent once a build has reached its final status.
+	BuildEventFinished = "finished"
+)
+
+// BuildEvent is the params object of a project.buildEvent notification.
+type BuildEvent struct {
+	Type         string `json:"type"`
+	ProjectID    string `json:"project_id"`
+	BuildID      string `json:"build_id"`
+	Status       string `json:"status"`
+	Stage        string `json:"stage,omitempty"`
+	Progress     int    `json:"progress,omitempty"`
+	ErrorMessage string `json:"error_message,omitempty"`
+}
+
+// buildEvent builds a project.buildEvent notification describing rec.
+func buildEvent(eventType string, rec BuildRecord) JSONRPCNotification {
+	return JSONRPCNotification{
+		JSONRPC: "2.0",
+		Method:  BuildEventMethod,
+		Params: BuildEvent{
+			Type:         eventType,
+			ProjectID:    rec.ProjectID,
+			BuildID:      rec.BuildID,
+			Status:       rec.Status,
+			Stage:        rec.Stage,
+			Progress:     rec.Progress,
+			ErrorMessage: rec.ErrorMessage,
+		},
+	}
+}
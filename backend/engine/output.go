@@ -0,0 +1,204 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// outputRingSize is how many log lines and events a build keeps in memory.
+// Subscribers that fall further behind catch up from the log file.
+const outputRingSize = 4096
+
+// outputEntry is a complete log line or a progress event of a build.
+type outputEntry struct {
+	offset int64 // where the line starts in the log file; for events, the end of the last complete line
+	line   []byte
+	event  *BuildRecord
+}
+
+// buildOutput collects what a build prints and fans it out to any number of
+// subscribers as it arrives. Everything is written to the build's log file;
+// the most recent lines and progress events are also kept in a ring buffer,
+// so subscribers following a running build are served from memory.
+type buildOutput struct {
+	path string
+	file *os.File
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	ring     []outputEntry
+	next     uint64 // sequence number of the next entry; entry n lives at ring[n%len(ring)]
+	written  int64  // bytes of complete lines written to the log file
+	partial  []byte // start of a line that has no newline yet
+	writeErr error
+	closed   bool
+	final    BuildRecord
+}
+
+// newBuildOutput creates the log file at path, replacing any previous one.
+func newBuildOutput(path string, ringSize int) (*buildOutput, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create build log: %w", err)
+	}
+	o := &buildOutput{path: path, file: file, ring: make([]outputEntry, ringSize)}
+	o.cond = sync.NewCond(&o.mu)
+	return o, nil
+}
+
+// Write persists b and passes complete lines on to subscribers. It never
+// blocks on slow subscribers and never fails, so a full disk can't break the build.
+func (o *buildOutput) Write(b []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return len(b), nil
+	}
+	if _, err := o.file.Write(b); err != nil && o.writeErr == nil {
+		o.writeErr = err
+		log.Printf("Failed to write build log %s: %v", o.path, err)
+	}
+
+	o.partial = append(o.partial, b...)
+	for {
+		i := bytes.IndexByte(o.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := make([]byte, i+1)
+		copy(line, o.partial)
+		o.appendLocked(outputEntry{line: line})
+		o.partial = o.partial[i+1:]
+	}
+	o.cond.Broadcast()
+	return len(b), nil
+}
+
+// publish passes a progress update to subscribers.
+func (o *buildOutput) publish(rec BuildRecord) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return
+	}
+	o.appendLocked(outputEntry{event: &rec})
+	o.cond.Broadcast()
+}
+
+// close flushes a final line without newline, closes the log file and lets
+// subscribers finish with the build's final record.
+func (o *buildOutput) close(final BuildRecord) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return
+	}
+	if len(o.partial) > 0 {
+		o.appendLocked(outputEntry{line: o.partial})
+		o.partial = nil
+	}
+	if err := o.file.Close(); err != nil {
+		log.Printf("Failed to close build log %s: %v", o.path, err)
+	}
+	o.closed = true
+	o.final = final
+	o.cond.Broadcast()
+}
+
+// appendLocked adds an entry to the ring, overwriting the oldest one once it
+// is full. Callers must hold o.mu.
+func (o *buildOutput) appendLocked(entry outputEntry) {
+	entry.offset = o.written
+	if entry.event == nil {
+		o.written += int64(len(entry.line))
+	}
+	o.ring[o.next%uint64(len(o.ring))] = entry
+	o.next++
+}
+
+// follow passes the build's output to send line by line from the beginning,
+// and its progress events to event, until the build has finished. It returns
+// the final record. Lines that have already left the ring are read back from
+// the log file; the events between them are skipped.
+func (o *buildOutput) follow(send func(line []byte), event func(BuildRecord)) BuildRecord {
+	var seq uint64
+	var offset int64 // end of the last line sent
+	for {
+		o.mu.Lock()
+		for seq == o.next && !o.closed {
+			o.cond.Wait()
+		}
+		if seq == o.next {
+			final := o.final
+			o.mu.Unlock()
+			return final
+		}
+		oldest := uint64(0)
+		if o.next > uint64(len(o.ring)) {
+			oldest = o.next - uint64(len(o.ring))
+		}
+		var missed int64 = -1
+		if seq < oldest {
+			missed = o.ring[oldest%uint64(len(o.ring))].offset
+			seq = oldest
+		}
+		entries := make([]outputEntry, 0, o.next-seq)
+		for ; seq < o.next; seq++ {
+			entries = append(entries, o.ring[seq%uint64(len(o.ring))])
+		}
+		o.mu.Unlock()
+
+		if missed >= 0 {
+			if err := readLogLines(o.path, offset, missed, send); err != nil {
+				log.Printf("Failed to read back build log %s: %v", o.path, err)
+			}
+			offset = missed
+		}
+		for _, entry := range entries {
+			if entry.event != nil {
+				event(*entry.event)
+				continue
+			}
+			send(entry.line)
+			offset = entry.offset + int64(len(entry.line))
+		}
+	}
+}
+
+// readLogLines passes the lines of the log file between the byte offsets
+// from and to to send. A negative to reads up to the end of the file.
+func readLogLines(path string, from, to int64, send func(line []byte)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if to >= 0 {
+		r = io.NewSectionReader(file, from, to-from)
+	} else if _, err := file.Seek(from, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			send(line)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestBuildOutputFanOut checks that every subscriber gets the whole log in
+// order, whether it follows from the start or joins after lines have left
+// the ring buffer, and that the log file holds the same output.
+func TestBuildOutputFanOut(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "output.log")
+	output, err := newBuildOutput(path, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want strings.Builder
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&want, "line %d\n", i)
+	}
+	want.WriteString("no newline")
+
+	type result struct {
+		log    string
+		events int
+		final  BuildRecord
+	}
+	follow := func() result {
+		var r result
+		var log strings.Builder
+		r.final = output.follow(func(line []byte) {
+			log.Write(line)
+		}, func(BuildRecord) {
+			r.events++
+		})
+		r.log = log.String()
+		return r
+	}
+
+	var wg sync.WaitGroup
+	results := make([]result, 3)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = follow()
+		}(i)
+	}
+
+	// Split writes across line boundaries, like pipe reads do.
+	data := want.String()
+	for i := 0; i < len(data); i += 7 {
+		output.Write([]byte(data[i:min(i+7, len(data))]))
+		if i == 21 {
+			output.publish(BuildRecord{Status: StatusBuilding, Stage: "installing packages"})
+		}
+	}
+
+	// This subscriber joins after the first lines were dropped from the ring.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[2] = follow()
+	}()
+
+	output.close(BuildRecord{BuildID: "build-1", Status: StatusCompleted})
+	wg.Wait()
+
+	for i, r := range results {
+		if r.log != want.String() {
+			t.Errorf("subscriber %d got log %q, want %q", i, r.log, want.String())
+		}
+		if r.final.Status != StatusCompleted {
+			t.Errorf("subscriber %d got final status %q, want %q", i, r.final.Status, StatusCompleted)
+		}
+	}
+	if results[2].events != 0 {
+		t.Errorf("late subscriber got %d progress events from dropped entries, want 0", results[2].events)
+	}
+
+	persisted, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(persisted) != want.String() {
+		t.Errorf("log file = %q, want %q", persisted, want.String())
+	}
+}
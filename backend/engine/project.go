@@ -0,0 +1,529 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+func (e *Engine) handleProjectCommands(req JSONRPCRequest, method string) (JSONRPCResponse, followUpFunc) {
+	// All project commands require a project_id; it selects the plugin that
+	// handles the call. Method-specific params are extracted from tempParams.
+	tempParams, projectID, meta, p, rpcErr := e.resolveProject(req)
+	if rpcErr != nil {
+		return errorResponse(req, rpcErr), nil
+	}
+
+	// Now dispatch to the plugin method
+	switch method {
+	case "getDetails":
+		details, err := p.GetDetails(projectID)
+		if err != nil {
+			return errorResponse(req, pluginErrorToRPC(err)), nil
+		}
+		details.BuildStatus = "unknown"
+		if latest, found := e.builds.latest(projectID); found {
+			details.BuildStatus = latest.Status
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: details, ID: req.ID}, nil
+
+	case "setPackages":
+		packagesVal, ok := tempParams["packages"]
+		if !ok {
+			return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "Missing 'packages' in params for setPackages"}), nil
+		}
+
+		packageInterfaceList, ok := packagesVal.([]interface{})
+		if !ok {
+			return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "'packages' must be a list for setPackages"}), nil
+		}
+
+		var packages []string
+		for _, pkgInterface := range packageInterfaceList {
+			pkgStr, ok := pkgInterface.(string)
+			if !ok {
+				return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "All items in 'packages' must be strings"}), nil
+			}
+			packages = append(packages, pkgStr)
+		}
+
+		if err := p.SetPackages(projectID, packages); err != nil {
+			return errorResponse(req, pluginErrorToRPC(err)), nil
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: map[string]bool{"success": true}, ID: req.ID}, nil
+
+	case "getPackages":
+		pkgs, err := p.GetPackages(projectID)
+		if err != nil {
+			return errorResponse(req, pluginErrorToRPC(err)), nil
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: pkgs, ID: req.ID}, nil
+
+	case "setBootloader":
+		bootloaderVal, ok := tempParams["bootloader"]
+		if !ok {
+			return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "Missing 'bootloader' in params for setBootloader"}), nil
+		}
+		bootloaderStr, ok := bootloaderVal.(string)
+		if !ok {
+			return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "'bootloader' must be a string"}), nil
+		}
+
+		if err := p.SetBootloader(projectID, bootloaderStr); err != nil {
+			return errorResponse(req, pluginErrorToRPC(err)), nil
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: map[string]bool{"success": true}, ID: req.ID}, nil
+
+	case "getBootloader":
+		bootloader, err := p.GetBootloader(projectID)
+		if err != nil {
+			return errorResponse(req, pluginErrorToRPC(err)), nil
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: bootloader, ID: req.ID}, nil
+
+	case "setHostname":
+		hostnameVal, ok := tempParams["hostname"]
+		if !ok {
+			return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "Missing 'hostname' in params for setHostname"}), nil
+		}
+		hostnameStr, ok := hostnameVal.(string)
+		if !ok {
+			return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "'hostname' must be a string"}), nil
+		}
+
+		if err := p.SetHostname(projectID, hostnameStr); err != nil {
+			return errorResponse(req, pluginErrorToRPC(err)), nil
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: map[string]bool{"success": true}, ID: req.ID}, nil
+
+	case "getHostname":
+		hostname, err := p.GetHostname(projectID)
+		if err != nil {
+			return errorResponse(req, pluginErrorToRPC(err)), nil
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: hostname, ID: req.ID}, nil
+
+	case "getProfileDef", "setProfileDef":
+		editor, ok := p.(plugin.ProfileDefEditor)
+		if !ok {
+			return errorResponse(req, &RPCError{Code: MethodNotFoundCode, Message: fmt.Sprintf("Distro '%s' has no profiledef.sh", meta.DistroID)}), nil
+		}
+		var def plugin.ProfileDef
+		var err error
+		if method == "getProfileDef" {
+			def, err = editor.GetProfileDef(projectID)
+		} else {
+			variables, ok := tempParams["variables"].(map[string]interface{})
+			if !ok {
+				return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "Missing or invalid 'variables' in params for setProfileDef, expected an object"}), nil
+			}
+			def, err = editor.SetProfileDef(projectID, variables)
+		}
+		if err != nil {
+			return errorResponse(req, pluginErrorToRPC(err)), nil
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: def, ID: req.ID}, nil
+
+	case "getBootMenu", "setBootMenu":
+		editor, ok := p.(plugin.BootMenuEditor)
+		if !ok {
+			return errorResponse(req, &RPCError{Code: MethodNotFoundCode, Message: fmt.Sprintf("Distro '%s' has no boot menu", meta.DistroID)}), nil
+		}
+		var menu plugin.BootMenu
+		var err error
+		if method == "getBootMenu" {
+			menu, err = editor.GetBootMenu(projectID)
+		} else {
+			var params struct {
+				Menu *plugin.BootMenu `json:"menu"`
+			}
+			if err := json.Unmarshal(req.Params, &params); err != nil || params.Menu == nil {
+				return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "Missing or invalid 'menu' in params for setBootMenu"}), nil
+			}
+			menu, err = editor.SetBootMenu(projectID, *params.Menu)
+		}
+		if err != nil {
+			return errorResponse(req, pluginErrorToRPC(err)), nil
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: menu, ID: req.ID}, nil
+
+	case "listRepos", "addRepo", "removeRepo", "setRepoOrder":
+		editor, ok := p.(plugin.RepoEditor)
+		if !ok {
+			return errorResponse(req, &RPCError{Code: MethodNotFoundCode, Message: fmt.Sprintf("Distro '%s' has no repositories", meta.DistroID)}), nil
+		}
+		var repos plugin.ReposResponse
+		var err error
+		switch method {
+		case "listRepos":
+			repos, err = editor.ListRepos(projectID)
+		case "addRepo":
+			var params struct {
+				Repo     *plugin.Repo `json:"repo"`
+				Position *int         `json:"position"`
+			}
+			if err := json.Unmarshal(req.Params, &params); err != nil || params.Repo == nil {
+				return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "Missing or invalid 'repo' in params for addRepo"}), nil
+			}
+			position := -1
+			if params.Position != nil {
+				if *params.Position < 0 {
+					return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "Invalid 'position' in params for addRepo, expected a number from 0"}), nil
+				}
+				position = *params.Position
+			}
+			repos, err = editor.AddRepo(projectID, *params.Repo, position)
+		case "removeRepo":
+			name, ok := tempParams["name"].(string)
+			if !ok || name == "" {
+				return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "Missing or invalid 'name' in params for removeRepo"}), nil
+			}
+			repos, err = editor.RemoveRepo(projectID, name)
+		case "setRepoOrder":
+			var params struct {
+				Repos []string `json:"repos"`
+			}
+			if err := json.Unmarshal(req.Params, &params); err != nil || params.Repos == nil {
+				return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "Missing or invalid 'repos' in params for setRepoOrder, expected a list of repository names"}), nil
+			}
+			repos, err = editor.SetRepoOrder(projectID, params.Repos)
+		}
+		if err != nil {
+			return errorResponse(req, pluginErrorToRPC(err)), nil
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: repos, ID: req.ID}, nil
+
+	case "getRepoOptions", "setRepoOptions":
+		editor, ok := p.(plugin.RepoEditor)
+		if !ok {
+			return errorResponse(req, &RPCError{Code: MethodNotFoundCode, Message: fmt.Sprintf("Distro '%s' has no repositories", meta.DistroID)}), nil
+		}
+		var options plugin.RepoOptions
+		var err error
+		if method == "getRepoOptions" {
+			options, err = editor.GetRepoOptions(projectID)
+		} else {
+			var params struct {
+				Options *plugin.RepoOptions `json:"options"`
+			}
+			if err := json.Unmarshal(req.Params, &params); err != nil || params.Options == nil {
+				return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "Missing or invalid 'options' in params for setRepoOptions"}), nil
+			}
+			options, err = editor.SetRepoOptions(projectID, *params.Options)
+		}
+		if err != nil {
+			return errorResponse(req, pluginErrorToRPC(err)), nil
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: options, ID: req.ID}, nil
+
+	case "saveAsTemplate":
+		saver, ok := p.(plugin.TemplateSaver)
+		if !ok {
+			return errorResponse(req, &RPCError{Code: MethodNotFoundCode, Message: fmt.Sprintf("Distro '%s' can't save templates", meta.DistroID)}), nil
+		}
+		name, ok := tempParams["name"].(string)
+		if !ok || name == "" {
+			return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "Missing or invalid 'name' in params for saveAsTemplate"}), nil
+		}
+		description, _ := tempParams["description"].(string)
+		template, err := saver.SaveAsTemplate(projectID, name, description)
+		if err != nil {
+			return errorResponse(req, pluginErrorToRPC(err)), nil
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: template, ID: req.ID}, nil
+
+	case "cleanWorkdir":
+		cleaner, ok := p.(plugin.WorkdirCleaner)
+		if !ok {
+			return JSONRPCResponse{JSONRPC: "2.0", Result: Reclaimed{What: "workdir", ProjectID: projectID, DistroID: meta.DistroID}, ID: req.ID}, nil
+		}
+		reclaimed, err := e.cleanWorkdir(meta, cleaner)
+		if err == ErrBuildInProgress {
+			return errorResponse(req, &RPCError{Code: BuildInProgressCode, Message: fmt.Sprintf("A build of project '%s' is using its work directory", projectID)}), nil
+		}
+		if err != nil {
+			return errorResponse(req, pluginErrorToRPC(err)), nil
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: reclaimed, ID: req.ID}, nil
+
+	case "preflight":
+		report, err := e.preflight(p, projectID)
+		if err != nil {
+			return errorResponse(req, pluginErrorToRPC(err)), nil
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: report, ID: req.ID}, nil
+
+	case "buildIso":
+		// The build itself runs from the queue; the response only confirms it
+		// was accepted. Builds that would fail preflight checks are refused
+		// unless skip_preflight is set.
+		reproducible, rpcErr := parseReproducible(tempParams, e.now)
+		if rpcErr != nil {
+			return errorResponse(req, rpcErr), nil
+		}
+		var report plugin.PreflightReport
+		if skip, _ := tempParams["skip_preflight"].(bool); !skip {
+			var err error
+			report, err = e.preflight(p, projectID)
+			if err != nil {
+				return errorResponse(req, pluginErrorToRPC(err)), nil
+			}
+			if !report.Passed {
+				return errorResponse(req, &RPCError{Code: PreflightFailedCode, Message: fmt.Sprintf("Preflight checks failed for project '%s'", projectID), Data: report}), nil
+			}
+		}
+		entry, rpcErr := e.queueBuild(projectID, meta.DistroID, func(r *BuildRecord) {
+			if reproducible != nil && reproducible.SourceDateEpoch == 0 {
+				reproducible.SourceDateEpoch = r.QueuedAt.Unix()
+			}
+			r.Reproducible = reproducible
+		})
+		if rpcErr != nil {
+			return errorResponse(req, rpcErr), nil
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: plugin.BuildResponse{BuildID: entry.BuildID, Status: entry.State, Warnings: report.Warnings}, ID: req.ID}, nil
+
+	case "rebuild":
+		// The rebuild uses the configuration the original build saved, not
+		// the project's current one, so preflight checks don't apply.
+		orig, rpcErr := e.lookupBuild(tempParams, projectID, method)
+		if rpcErr != nil {
+			return errorResponse(req, rpcErr), nil
+		}
+		if orig.Reproducible == nil || orig.Status != StatusCompleted {
+			return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: fmt.Sprintf("Build '%s' is not a completed reproducible build", orig.BuildID)}), nil
+		}
+		entry, rpcErr := e.queueBuild(projectID, meta.DistroID, func(r *BuildRecord) {
+			r.Reproducible = orig.Reproducible
+			r.RebuildOf = orig.BuildID
+		})
+		if rpcErr != nil {
+			return errorResponse(req, rpcErr), nil
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: plugin.BuildResponse{BuildID: entry.BuildID, Status: entry.State}, ID: req.ID}, nil
+
+	case "streamBuildOutput":
+		rec, rpcErr := e.lookupBuild(tempParams, projectID, method)
+		if rpcErr != nil {
+			return errorResponse(req, rpcErr), nil
+		}
+
+		// JSON-RPC is request/response, so the request is acknowledged right
+		// away and each log line follows as a separate JSON object without an ID.
+		// Once the build is over a final build event reports its outcome.
+		forward := func(t Transport) {
+			final := e.followBuild(rec, func(line []byte) {
+				streamData := JSONRPCResponse{
+					JSONRPC: "2.0",
+					Result: map[string]interface{}{
+						"project_id": projectID,
+						"build_id":   rec.BuildID,
+						"log_line":   string(line),
+					},
+				}
+				e.send(t, streamData)
+			}, func(progress BuildRecord) {
+				e.send(t, buildEvent(BuildEventProgress, progress))
+			})
+			e.send(t, buildEvent(BuildEventFinished, final))
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: map[string]string{"message": "Streaming initiated. Log lines will be sent as separate JSON objects if any."}, ID: req.ID}, forward
+
+	case "getBuildStatus":
+		rec, rpcErr := e.lookupBuild(tempParams, projectID, method)
+		if rpcErr != nil {
+			return errorResponse(req, rpcErr), nil
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: rec.statusResponse(), ID: req.ID}, nil
+
+	case "cancelBuild":
+		rec, rpcErr := e.lookupBuild(tempParams, projectID, method)
+		if rpcErr != nil {
+			return errorResponse(req, rpcErr), nil
+		}
+		cancelled, err := e.cancelBuild(rec.BuildID)
+		if err != nil {
+			return errorResponse(req, &RPCError{Code: BuildNotFoundCode, Message: fmt.Sprintf("Build '%s' is not queued or running", rec.BuildID)}), nil
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: cancelled.statusResponse(), ID: req.ID}, nil
+
+	case "listBuilds":
+		return JSONRPCResponse{JSONRPC: "2.0", Result: map[string]interface{}{"builds": e.builds.list(projectID)}, ID: req.ID}, nil
+
+	case "getBuild":
+		rec, rpcErr := e.lookupBuild(tempParams, projectID, method)
+		if rpcErr != nil {
+			return errorResponse(req, rpcErr), nil
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: rec, ID: req.ID}, nil
+
+	case "getBuildManifest":
+		rec, rpcErr := e.lookupBuild(tempParams, projectID, method)
+		if rpcErr != nil {
+			return errorResponse(req, rpcErr), nil
+		}
+		manifest, found, err := e.builds.manifest(rec.BuildID)
+		if err != nil {
+			return errorResponse(req, &RPCError{Code: InternalErrorCode, Message: err.Error()}), nil
+		}
+		if !found {
+			return errorResponse(req, &RPCError{Code: ArtifactNotFoundCode, Message: fmt.Sprintf("Build '%s' has no manifest", rec.BuildID)}), nil
+		}
+		result := map[string]interface{}{"build_id": rec.BuildID, "packages": manifest.Packages}
+		for _, a := range rec.Artifacts {
+			if a.Kind == "sbom" {
+				result["sbom"] = a
+			}
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: result, ID: req.ID}, nil
+
+	case "diffBuilds":
+		buildA, rpcErr := e.lookupBuildParam(tempParams, "build_a", projectID, method)
+		if rpcErr != nil {
+			return errorResponse(req, rpcErr), nil
+		}
+		buildB, rpcErr := e.lookupBuildParam(tempParams, "build_b", projectID, method)
+		if rpcErr != nil {
+			return errorResponse(req, rpcErr), nil
+		}
+		diff, err := e.diffBuilds(buildA, buildB)
+		if err != nil {
+			return errorResponse(req, &RPCError{Code: InternalErrorCode, Message: err.Error()}), nil
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: diff, ID: req.ID}, nil
+
+	case "markRelease":
+		rec, rpcErr := e.lookupBuild(tempParams, projectID, method)
+		if rpcErr != nil {
+			return errorResponse(req, rpcErr), nil
+		}
+		release := true
+		if value, present := tempParams["release"]; present {
+			var ok bool
+			if release, ok = value.(bool); !ok {
+				return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: "Invalid release for markRelease, expected a boolean"}), nil
+			}
+		}
+		if release && rec.Status != StatusCompleted {
+			return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: fmt.Sprintf("Build '%s' did not complete and cannot be a release", rec.BuildID)}), nil
+		}
+		if _, err := e.builds.update(rec.BuildID, func(r *BuildRecord) { r.Release = release }); err != nil {
+			return errorResponse(req, &RPCError{Code: InternalErrorCode, Message: err.Error()}), nil
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: map[string]bool{"success": true}, ID: req.ID}, nil
+
+	case "listArtifacts":
+		artifacts, total := e.listArtifacts(projectID)
+		return JSONRPCResponse{JSONRPC: "2.0", Result: map[string]interface{}{"artifacts": artifacts, "total_size": total}, ID: req.ID}, nil
+
+	case "deleteArtifact":
+		rec, rpcErr := e.lookupBuild(tempParams, projectID, method)
+		if rpcErr != nil {
+			return errorResponse(req, rpcErr), nil
+		}
+		name, _ := tempParams["name"].(string)
+		if value, present := tempParams["name"]; present && name == "" {
+			return errorResponse(req, &RPCError{Code: InvalidParamsCode, Message: fmt.Sprintf("Invalid or empty name for deleteArtifact: %v", value)}), nil
+		}
+		reclaimed, err := e.deleteArtifacts(rec, name)
+		if err == ErrArtifactNotFound {
+			message := fmt.Sprintf("Build '%s' has no artifacts", rec.BuildID)
+			if name != "" {
+				message = fmt.Sprintf("Build '%s' has no artifact '%s'", rec.BuildID, name)
+			}
+			return errorResponse(req, &RPCError{Code: ArtifactNotFoundCode, Message: message}), nil
+		}
+		if err != nil {
+			return errorResponse(req, &RPCError{Code: InternalErrorCode, Message: err.Error()}), nil
+		}
+		return JSONRPCResponse{JSONRPC: "2.0", Result: reclaimed, ID: req.ID}, nil
+
+	default:
+		return errorResponse(req, &RPCError{Code: MethodNotFoundCode, Message: fmt.Sprintf("Method '%s' not found in project namespace", method)}), nil
+	}
+}
+
+// preflight runs the plugin's preflight checks on a project. Plugins that
+// don't implement plugin.Preflighter always pass.
+func (e *Engine) preflight(p plugin.DistroPlugin, projectID string) (plugin.PreflightReport, error) {
+	checker, ok := p.(plugin.Preflighter)
+	if !ok {
+		return plugin.NewPreflightReport(nil), nil
+	}
+	findings, err := checker.Preflight(context.Background(), projectID)
+	if err != nil {
+		return plugin.PreflightReport{}, err
+	}
+	return plugin.NewPreflightReport(findings), nil
+}
+
+// queueBuild creates a build record, set up further by setup, and queues
+// the build.
+func (e *Engine) queueBuild(projectID, distroID string, setup func(*BuildRecord)) (QueuedBuild, *RPCError) {
+	rec, err := e.builds.create(projectID, distroID, e.now(), setup)
+	if err != nil {
+		return QueuedBuild{}, &RPCError{Code: InternalErrorCode, Message: err.Error()}
+	}
+	if err := e.openOutput(rec); err != nil {
+		if rmErr := e.builds.remove(rec.BuildID); rmErr != nil {
+			log.Printf("Failed to remove build %s: %v", rec.BuildID, rmErr)
+		}
+		return QueuedBuild{}, &RPCError{Code: InternalErrorCode, Message: err.Error()}
+	}
+	entry, err := e.queue.Enqueue(projectID, rec.BuildID, rec.QueuedAt)
+	if err != nil {
+		e.closeOutput(rec)
+		if rmErr := e.builds.remove(rec.BuildID); rmErr != nil {
+			log.Printf("Failed to remove rejected build %s: %v", rec.BuildID, rmErr)
+		}
+	}
+	if err == ErrBuildInProgress {
+		return QueuedBuild{}, &RPCError{Code: BuildInProgressCode, Message: fmt.Sprintf("A build is already queued or running for project '%s'", projectID)}
+	}
+	if err != nil {
+		return QueuedBuild{}, &RPCError{Code: InternalErrorCode, Message: err.Error()}
+	}
+	return entry, nil
+}
+
+// lookupBuild extracts the build_id param of a project command and returns
+// the build's record. Builds of other projects are reported as not found.
+func (e *Engine) lookupBuild(params map[string]interface{}, projectID, method string) (BuildRecord, *RPCError) {
+	return e.lookupBuildParam(params, "build_id", projectID, method)
+}
+
+// lookupBuildParam is lookupBuild for a build ID passed as the param key.
+func (e *Engine) lookupBuildParam(params map[string]interface{}, key, projectID, method string) (BuildRecord, *RPCError) {
+	buildIDInterface, ok := params[key]
+	if !ok {
+		return BuildRecord{}, &RPCError{Code: InvalidParamsCode, Message: fmt.Sprintf("Missing %s in params for %s", key, method)}
+	}
+	buildID, ok := buildIDInterface.(string)
+	if !ok || buildID == "" {
+		return BuildRecord{}, &RPCError{Code: InvalidParamsCode, Message: fmt.Sprintf("Invalid or empty %s for %s", key, method)}
+	}
+	rec, found := e.builds.get(buildID)
+	if !found || rec.ProjectID != projectID {
+		return BuildRecord{}, &RPCError{Code: BuildNotFoundCode, Message: fmt.Sprintf("Build '%s' not found for project '%s'", buildID, projectID)}
+	}
+	return rec, nil
+}
+
+// followBuild passes the build log to send line by line from the beginning
+// and progress updates to progress, until the build has finished, and returns
+// the final record. Running builds are followed live; the logs of finished
+// builds, including those cut short by an engine restart, are read from disk.
+func (e *Engine) followBuild(rec BuildRecord, send func(line []byte), progress func(BuildRecord)) BuildRecord {
+	if output := e.output(rec.BuildID); output != nil {
+		return output.follow(send, progress)
+	}
+	// The output is dropped only after the final record was stored.
+	final, _ := e.builds.get(rec.BuildID)
+	if err := readLogLines(final.LogPath, 0, -1, send); err != nil {
+		log.Printf("Error reading log of build %s: %v", rec.BuildID, err)
+	}
+	return final
+}
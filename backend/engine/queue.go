@@ -0,0 +1,266 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Build queue states. A build is queued until a slot frees up, running while
+// its plugin works on it and finished afterwards, whatever the outcome.
+// Builds removed from the queue before they started end up cancelled.
+const (
+	BuildQueued    = "queued"
+	BuildRunning   = "running"
+	BuildFinished  = "finished"
+	BuildCancelled = "cancelled"
+)
+
+var (
+	// ErrBuildInProgress is returned when a project already has a queued or running build.
+	ErrBuildInProgress = errors.New("a build is already queued or running for this project")
+	// ErrBuildNotQueued is returned when a queue operation targets a build that isn't waiting in the queue.
+	ErrBuildNotQueued = errors.New("build is not waiting in the queue")
+	// ErrBuildNotRunning is returned by Stop for builds that aren't running.
+	ErrBuildNotRunning = errors.New("build is not running")
+)
+
+// QueuedBuild describes a build known to the queue.
+type QueuedBuild struct {
+	BuildID   string     `json:"build_id"`
+	ProjectID string     `json:"project_id"`
+	State     string     `json:"state"`
+	Position  int        `json:"position"` // 0-based position among queued builds; 0 for running builds
+	QueuedAt  time.Time  `json:"queued_at"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+}
+
+// buildFunc runs a build to completion. ctx is cancelled if the build must
+// stop. b.StartedAt is set.
+type buildFunc func(ctx context.Context, b QueuedBuild) error
+
+// maxDoneStates is how many finished or cancelled builds the queue keeps
+// the state of. Older ones are forgotten; their build records remain.
+const maxDoneStates = 256
+
+// BuildQueue schedules builds: at most one per project, and at most limit at
+// a time across all projects. Builds start in queue order.
+type BuildQueue struct {
+	run   buildFunc
+	limit int
+	now   func() time.Time
+
+	mu      sync.Mutex
+	pending []*QueuedBuild
+	running map[string]*runningBuild // keyed by build ID
+	states  map[string]string        // last known state of recent builds, keyed by build ID
+	done    []string                 // finished and cancelled builds in states, oldest first
+	wg      sync.WaitGroup
+}
+
+// runningBuild is a build that has left the queue and can still be stopped.
+type runningBuild struct {
+	QueuedBuild
+	cancel context.CancelCauseFunc
+	done   chan struct{} // closed once the build function has returned
+}
+
+// NewBuildQueue creates a queue running up to limit builds concurrently.
+// A limit below 1 is treated as 1. now stamps builds when they start; nil
+// means time.Now.
+func NewBuildQueue(limit int, run buildFunc, now func() time.Time) *BuildQueue {
+	if limit < 1 {
+		limit = 1
+	}
+	if now == nil {
+		now = time.Now
+	}
+	return &BuildQueue{
+		run:     run,
+		limit:   limit,
+		now:     now,
+		running: make(map[string]*runningBuild),
+		states:  make(map[string]string),
+	}
+}
+
+// Enqueue adds a build for projectID, queued at queuedAt. It fails with
+// ErrBuildInProgress if the project already has a build that hasn't finished.
+func (q *BuildQueue) Enqueue(projectID, buildID string, queuedAt time.Time) (QueuedBuild, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, b := range q.running {
+		if b.ProjectID == projectID {
+			return QueuedBuild{}, ErrBuildInProgress
+		}
+	}
+	for _, b := range q.pending {
+		if b.ProjectID == projectID {
+			return QueuedBuild{}, ErrBuildInProgress
+		}
+	}
+
+	b := &QueuedBuild{BuildID: buildID, ProjectID: projectID, State: BuildQueued, QueuedAt: queuedAt}
+	q.pending = append(q.pending, b)
+	q.states[buildID] = BuildQueued
+	q.wg.Add(1)
+	entry := *b
+	entry.Position = len(q.pending) - 1
+	q.dispatchLocked()
+	return entry, nil
+}
+
+// dispatchLocked starts queued builds while there are free slots. Callers must hold q.mu.
+func (q *BuildQueue) dispatchLocked() {
+	for len(q.running) < q.limit && len(q.pending) > 0 {
+		b := q.pending[0]
+		q.pending = q.pending[1:]
+
+		now := q.now()
+		b.State = BuildRunning
+		b.StartedAt = &now
+		ctx, cancel := context.WithCancelCause(context.Background())
+		r := &runningBuild{QueuedBuild: *b, cancel: cancel, done: make(chan struct{})}
+		q.running[b.BuildID] = r
+		q.states[b.BuildID] = BuildRunning
+
+		go q.execute(ctx, r)
+	}
+}
+
+func (q *BuildQueue) execute(ctx context.Context, r *runningBuild) {
+	defer q.wg.Done()
+	b := r.QueuedBuild
+	log.Printf("Build %s for project %s started", b.BuildID, b.ProjectID)
+	if err := q.run(ctx, b); err != nil {
+		log.Printf("Build %s for project %s failed: %v", b.BuildID, b.ProjectID, err)
+	}
+	r.cancel(nil)
+	close(r.done)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.running, b.BuildID)
+	q.doneLocked(b.BuildID, BuildFinished)
+	log.Printf("Build %s for project %s finished", b.BuildID, b.ProjectID)
+	q.dispatchLocked()
+}
+
+// List returns the running builds followed by the queued ones in start order.
+func (q *BuildQueue) List() []QueuedBuild {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	list := []QueuedBuild{}
+	for _, b := range q.running {
+		list = append(list, b.QueuedBuild)
+	}
+	sortByStart(list)
+	for i, b := range q.pending {
+		entry := *b
+		entry.Position = i
+		list = append(list, entry)
+	}
+	return list
+}
+
+// sortByStart orders running builds by start time (insertion sort; the list is tiny).
+func sortByStart(list []QueuedBuild) {
+	for i := 1; i < len(list); i++ {
+		for j := i; j > 0 && list[j].StartedAt.Before(*list[j-1].StartedAt); j-- {
+			list[j], list[j-1] = list[j-1], list[j]
+		}
+	}
+}
+
+// Move changes the position of a queued build. Positions outside the queue
+// are clamped, so 0 moves a build to the front and a large value to the back.
+func (q *BuildQueue) Move(buildID string, position int) (QueuedBuild, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	idx := q.pendingIndexLocked(buildID)
+	if idx < 0 {
+		return QueuedBuild{}, ErrBuildNotQueued
+	}
+	b := q.pending[idx]
+	q.pending = append(q.pending[:idx], q.pending[idx+1:]...)
+
+	if position < 0 {
+		position = 0
+	}
+	if position > len(q.pending) {
+		position = len(q.pending)
+	}
+	q.pending = append(q.pending[:position], append([]*QueuedBuild{b}, q.pending[position:]...)...)
+
+	entry := *b
+	entry.Position = position
+	return entry, nil
+}
+
+// Stop cancels the context of a running build with the given cause. The
+// returned channel is closed once the build function has returned.
+func (q *BuildQueue) Stop(buildID string, cause error) (<-chan struct{}, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	r, found := q.running[buildID]
+	if !found {
+		return nil, ErrBuildNotRunning
+	}
+	r.cancel(cause)
+	return r.done, nil
+}
+
+// Cancel removes a build from the queue before it starts.
+func (q *BuildQueue) Cancel(buildID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	idx := q.pendingIndexLocked(buildID)
+	if idx < 0 {
+		return ErrBuildNotQueued
+	}
+	q.pending = append(q.pending[:idx], q.pending[idx+1:]...)
+	q.doneLocked(buildID, BuildCancelled)
+	q.wg.Done()
+	return nil
+}
+
+// doneLocked records the final state of a build, forgetting the oldest
+// finished builds beyond maxDoneStates. Callers must hold q.mu.
+func (q *BuildQueue) doneLocked(buildID, state string) {
+	q.states[buildID] = state
+	q.done = append(q.done, buildID)
+	if len(q.done) > maxDoneStates {
+		delete(q.states, q.done[0])
+		q.done = q.done[1:]
+	}
+}
+
+func (q *BuildQueue) pendingIndexLocked(buildID string) int {
+	for i, b := range q.pending {
+		if b.BuildID == buildID {
+			return i
+		}
+	}
+	return -1
+}
+
+// State returns the queue state of a build, if the queue has seen it
+// recently.
+func (q *BuildQueue) State(buildID string) (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	state, found := q.states[buildID]
+	return state, found
+}
+
+// Wait blocks until every queued and running build has finished.
+func (q *BuildQueue) Wait() {
+	q.wg.Wait()
+}
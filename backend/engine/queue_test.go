@@ -0,0 +1,199 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingRunner lets tests decide when each build finishes.
+type blockingRunner struct {
+	mu      sync.Mutex
+	started []string
+	release map[string]chan struct{}
+}
+
+func newBlockingRunner() *blockingRunner {
+	return &blockingRunner{release: make(map[string]chan struct{})}
+}
+
+func (r *blockingRunner) gate(buildID string) chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ch, found := r.release[buildID]
+	if !found {
+		ch = make(chan struct{})
+		r.release[buildID] = ch
+	}
+	return ch
+}
+
+func (r *blockingRunner) run(ctx context.Context, b QueuedBuild) error {
+	r.mu.Lock()
+	r.started = append(r.started, b.BuildID)
+	r.mu.Unlock()
+	select {
+	case <-r.gate(b.BuildID):
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+func (r *blockingRunner) finish(buildID string) {
+	close(r.gate(buildID))
+}
+
+func (r *blockingRunner) startedBuilds() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.started...)
+}
+
+func queueIDs(list []QueuedBuild) []string {
+	var ids []string
+	for _, b := range list {
+		ids = append(ids, b.BuildID+":"+b.State)
+	}
+	return ids
+}
+
+func TestBuildQueueRejectsSecondBuildOfProject(t *testing.T) {
+	r := newBlockingRunner()
+	q := NewBuildQueue(2, r.run, nil)
+	if _, err := q.Enqueue("p1", "b1", time.Time{}); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	if _, err := q.Enqueue("p1", "b2", time.Time{}); err != ErrBuildInProgress {
+		t.Fatalf("second build of the same project: got %v, want ErrBuildInProgress", err)
+	}
+	r.finish("b1")
+	q.Wait()
+
+	if _, err := q.Enqueue("p1", "b3", time.Time{}); err != nil {
+		t.Fatalf("a new build should be accepted once the previous one finished: %v", err)
+	}
+	r.finish("b3")
+	q.Wait()
+}
+
+func TestBuildQueueLimitAndOrdering(t *testing.T) {
+	r := newBlockingRunner()
+	q := NewBuildQueue(1, r.run, nil)
+	for _, id := range []string{"a", "b", "c", "d"} {
+		if _, err := q.Enqueue("project-"+id, id, time.Time{}); err != nil {
+			t.Fatalf("Enqueue(%s) failed: %v", id, err)
+		}
+	}
+
+	got := queueIDs(q.List())
+	want := []string{"a:running", "b:queued", "c:queued", "d:queued"}
+	if len(got) != len(want) {
+		t.Fatalf("List = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("List = %v, want %v", got, want)
+		}
+	}
+
+	if entry, err := q.Move("d", 0); err != nil || entry.Position != 0 {
+		t.Fatalf("Move(d, 0) = %+v, %v", entry, err)
+	}
+	if err := q.Cancel("c"); err != nil {
+		t.Fatalf("Cancel(c) failed: %v", err)
+	}
+	if state, _ := q.State("c"); state != BuildCancelled {
+		t.Errorf("cancelled build state = %q, want %q", state, BuildCancelled)
+	}
+	if _, err := q.Move("a", 0); err != ErrBuildNotQueued {
+		t.Errorf("moving a running build: got %v, want ErrBuildNotQueued", err)
+	}
+	if err := q.Cancel("a"); err != ErrBuildNotQueued {
+		t.Errorf("cancelling a running build from the queue: got %v, want ErrBuildNotQueued", err)
+	}
+
+	for _, id := range []string{"a", "d", "b"} {
+		r.finish(id)
+	}
+	q.Wait()
+
+	started := r.startedBuilds()
+	wantStarted := []string{"a", "d", "b"}
+	for i := range wantStarted {
+		if i >= len(started) || started[i] != wantStarted[i] {
+			t.Fatalf("builds started in order %v, want %v", started, wantStarted)
+		}
+	}
+	if state, _ := q.State("b"); state != BuildFinished {
+		t.Errorf("build state = %q, want %q", state, BuildFinished)
+	}
+	if len(q.List()) != 0 {
+		t.Errorf("queue should be empty, got %v", queueIDs(q.List()))
+	}
+}
+
+func TestBuildQueueStop(t *testing.T) {
+	r := newBlockingRunner()
+	q := NewBuildQueue(1, r.run, nil)
+	q.Enqueue("p1", "a", time.Time{})
+	q.Enqueue("p2", "b", time.Time{})
+
+	if _, err := q.Stop("b", ErrBuildCancelled); err != ErrBuildNotRunning {
+		t.Errorf("stopping a queued build: got %v, want ErrBuildNotRunning", err)
+	}
+	done, err := q.Stop("a", ErrBuildCancelled)
+	if err != nil {
+		t.Fatalf("Stop(a) failed: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopped build did not finish")
+	}
+
+	// The freed slot goes to the next build.
+	r.finish("b")
+	q.Wait()
+	if got := r.startedBuilds(); len(got) != 2 || got[1] != "b" {
+		t.Errorf("started builds = %v, want [a b]", got)
+	}
+}
+
+func TestBuildQueueClockAndOldStates(t *testing.T) {
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var started []time.Time
+	q := NewBuildQueue(1, func(ctx context.Context, b QueuedBuild) error {
+		started = append(started, *b.StartedAt)
+		return nil
+	}, func() time.Time { return clock })
+	entry, err := q.Enqueue("p1", "b0", clock.Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	if !entry.QueuedAt.Equal(clock.Add(-time.Minute)) {
+		t.Errorf("QueuedAt = %v, want %v", entry.QueuedAt, clock.Add(-time.Minute))
+	}
+	q.Wait()
+	if len(started) != 1 || !started[0].Equal(clock) {
+		t.Errorf("builds started at %v, want the queue's clock %v", started, clock)
+	}
+
+	for i := 1; i <= maxDoneStates; i++ {
+		if _, err := q.Enqueue("p1", fmt.Sprintf("b%d", i), clock); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+		q.Wait()
+	}
+	if _, found := q.State("b0"); found {
+		t.Errorf("the oldest build's state should be forgotten after %d more builds", maxDoneStates)
+	}
+	if state, _ := q.State(fmt.Sprintf("b%d", maxDoneStates)); state != BuildFinished {
+		t.Errorf("latest build state = %q, want %q", state, BuildFinished)
+	}
+	if len(q.states) != maxDoneStates {
+		t.Errorf("queue keeps %d states, want %d", len(q.states), maxDoneStates)
+	}
+}
@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+// reproducibleKinds are the kinds of artifacts a reproducible build has to
+// reproduce bit for bit. SBOMs and checksum files name the build they
+// belong to, so they differ between a build and its rebuild anyway.
+var reproducibleKinds = map[string]bool{"iso": true}
+
+// Verification compares a rebuild with the build it repeated.
+type Verification struct {
+	// Reproduced reports whether the rebuild's images are identical to the
+	// original ones.
+	Reproduced bool `json:"reproduced"`
+	// Mismatches lists what came out differently. Different packages or
+	// tool versions explain why the images differ.
+	Mismatches []Mismatch `json:"mismatches"`
+}
+
+// Mismatch is something that came out differently in a rebuild.
+type Mismatch struct {
+	What     string `json:"what"` // "artifact", "package" or "tool"
+	Name     string `json:"name"` // the artifact kind, package or tool
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// parseReproducible reads the reproducible, snapshot and source_date_epoch
+// params of project.buildIso. It returns nil for ordinary builds. Builds
+// pinned to a snapshot date from the start of that day; for other
+// reproducible builds SourceDateEpoch is left 0, to be set to the time the
+// build is queued.
+func parseReproducible(params map[string]interface{}, now func() time.Time) (*plugin.Reproducible, *RPCError) {
+	invalid := func(format string, args ...interface{}) *RPCError {
+		return &RPCError{Code: InvalidParamsCode, Message: fmt.Sprintf(format, args...)}
+	}
+	var r plugin.Reproducible
+	enabled := false
+	if value, found := params["reproducible"]; found {
+		b, ok := value.(bool)
+		if !ok {
+			return nil, invalid("Invalid reproducible for buildIso, expected a boolean")
+		}
+		enabled = b
+	}
+	if value, found := params["snapshot"]; found {
+		snapshot, ok := value.(string)
+		day, err := time.Parse(plugin.SnapshotLayout, snapshot)
+		if !ok || err != nil {
+			return nil, invalid("Invalid snapshot for buildIso, expected a date like 2024-06-01")
+		}
+		if day.After(now()) {
+			return nil, invalid("Snapshot %s is in the future", snapshot)
+		}
+		r.Snapshot = snapshot
+		r.SourceDateEpoch = day.Unix()
+		enabled = true
+	}
+	if value, found := params["source_date_epoch"]; found {
+		epoch, ok := value.(float64)
+		if !ok || epoch <= 0 || epoch != float64(int64(epoch)) {
+			return nil, invalid("Invalid source_date_epoch for buildIso, expected seconds since 1970")
+		}
+		r.SourceDateEpoch = int64(epoch)
+		enabled = true
+	}
+	if !enabled {
+		return nil, nil
+	}
+	return &r, nil
+}
+
+// configDir is where a reproducible build keeps the configuration it was
+// made from.
+func (s *buildStore) configDir(buildID string) string {
+	return filepath.Join(s.dir, buildID, "config")
+}
+
+// outputHashes returns the SHA-256 of the artifacts a rebuild has to
+// reproduce, keyed by kind.
+func outputHashes(artifacts []plugin.Artifact) map[string]string {
+	hashes := make(map[string]string)
+	for _, a := range artifacts {
+		if reproducibleKinds[a.Kind] && a.Checksums["sha256"] != "" {
+			hashes[a.Kind] = a.Checksums["sha256"]
+		}
+	}
+	return hashes
+}
+
+// verifyRebuild compares what a rebuild produced with the build it
+// repeated. The original's hashes are kept with its record, so it can be
+// verified even after its artifacts were deleted.
+func (e *Engine) verifyRebuild(orig BuildRecord, hashes map[string]string, result plugin.BuildResult) Verification {
+	v := Verification{Mismatches: []Mismatch{}}
+	for _, kind := range changedKeys(orig.OutputHashes, hashes) {
+		v.Mismatches = append(v.Mismatches, Mismatch{What: "artifact", Name: kind, Expected: orig.OutputHashes[kind], Actual: hashes[kind]})
+	}
+	v.Reproduced = len(v.Mismatches) == 0 && len(hashes) > 0
+
+	if manifest, found, err := e.builds.manifest(orig.BuildID); err == nil && found && result.Manifest != nil {
+		expected, actual := packageVersions(manifest.Packages), packageVersions(result.Manifest.Packages)
+		for _, name := range changedKeys(expected, actual) {
+			v.Mismatches = append(v.Mismatches, Mismatch{What: "package", Name: name, Expected: expected[name], Actual: actual[name]})
+		}
+	}
+	for _, tool := range changedKeys(orig.ToolVersions, result.ToolVersions) {
+		v.Mismatches = append(v.Mismatches, Mismatch{What: "tool", Name: tool, Expected: orig.ToolVersions[tool], Actual: result.ToolVersions[tool]})
+	}
+	return v
+}
+
+// reportVerification writes the outcome of a rebuild to its build log.
+func (e *Engine) reportVerification(rec BuildRecord, v Verification) {
+	output := e.output(rec.BuildID)
+	if output == nil {
+		return
+	}
+	if v.Reproduced {
+		fmt.Fprintf(output, "Reproduced build %s bit for bit\n", rec.RebuildOf)
+	} else {
+		fmt.Fprintf(output, "The rebuild differs from build %s\n", rec.RebuildOf)
+	}
+	for _, m := range v.Mismatches {
+		fmt.Fprintf(output, "  %s %s: expected %q, got %q\n", m.What, m.Name, m.Expected, m.Actual)
+	}
+}
@@ -0,0 +1,41 @@
+package engine
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+func TestRebuildReportsMismatches(t *testing.T) {
+	e := newTestEngine(t)
+	e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "engine.createProject", Params: []byte(`{"distro_id":"fake"}`), ID: 1})
+	resp := e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "project.buildIso", Params: []byte(`{"project_id":"project-1","skip_preflight":true,"reproducible":true}`), ID: 2})
+	if resp.Error != nil {
+		t.Fatalf("buildIso failed: %+v", resp.Error)
+	}
+	e.queue.Wait()
+	orig, _ := e.builds.get(resp.Result.(plugin.BuildResponse).BuildID)
+	if orig.Reproducible == nil || orig.Reproducible.SourceDateEpoch != orig.QueuedAt.Unix() {
+		t.Fatalf("reproducible build recorded %+v, want SOURCE_DATE_EPOCH pinned to %d", orig.Reproducible, orig.QueuedAt.Unix())
+	}
+
+	// Pretend the original build produced a different image.
+	if _, err := e.builds.update(orig.BuildID, func(r *BuildRecord) { r.OutputHashes["iso"] = "0000" }); err != nil {
+		t.Fatal(err)
+	}
+	resp = e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "project.rebuild", Params: []byte(`{"project_id":"project-1","build_id":"` + orig.BuildID + `"}`), ID: 3})
+	if resp.Error != nil {
+		t.Fatalf("rebuild failed: %+v", resp.Error)
+	}
+	e.queue.Wait()
+	rebuild, _ := e.builds.get(resp.Result.(plugin.BuildResponse).BuildID)
+	v := rebuild.Verification
+	if v == nil || v.Reproduced || len(v.Mismatches) != 1 || v.Mismatches[0].What != "artifact" || v.Mismatches[0].Expected != "0000" {
+		t.Fatalf("verification = %+v, want the ISO reported as different", v)
+	}
+	if log, _ := os.ReadFile(rebuild.LogPath); !strings.Contains(string(log), "The rebuild differs from build "+orig.BuildID) {
+		t.Errorf("build log doesn't report the mismatch:\n%s", log)
+	}
+}
@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+// JSONRPCRequest defines the structure for incoming JSON-RPC requests.
+type JSONRPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"` // Use RawMessage to delay parsing of params
+	ID      interface{}     `json:"id"`     // Can be string, number, or null
+}
+
+// JSONRPCResponse defines the structure for outgoing JSON-RPC responses.
+type JSONRPCResponse struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Result  interface{} `json:"result,omitempty"`
+	Error   *RPCError   `json:"error,omitempty"`
+	ID      interface{} `json:"id"`
+}
+
+// JSONRPCNotification is a message the engine sends on its own initiative,
+// e.g. build events. It carries no ID and expects no response.
+type JSONRPCNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// RPCError defines the structure for JSON-RPC error objects.
+type RPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Error Constants
+const (
+	ParseErrorCode        = -32700
+	InvalidRequestCode    = -32600
+	MethodNotFoundCode    = -32601
+	InvalidParamsCode     = -32602
+	InternalErrorCode     = -32603
+	ProjectNotFoundCode   = -32000 // Example application-specific error
+	PluginNotFoundCode    = -32001
+	BuildInProgressCode   = -32002
+	BuildNotFoundCode     = -32003
+	PreflightFailedCode   = -32004
+	ArtifactNotFoundCode  = -32005
+	InvalidBootloaderCode = -32006
+	InvalidPackageCode    = -32007
+)
+
+// pluginErrorToRPC maps an error returned by a plugin onto a JSON-RPC error.
+// Errors that don't carry a plugin.ErrorCode are reported as internal errors.
+func pluginErrorToRPC(err error) *RPCError {
+	var perr *plugin.Error
+	if !errors.As(err, &perr) {
+		return &RPCError{Code: InternalErrorCode, Message: err.Error()}
+	}
+	code := InternalErrorCode
+	switch perr.Code {
+	case plugin.ErrInvalidParams:
+		code = InvalidParamsCode
+	case plugin.ErrInvalidBootloader:
+		code = InvalidBootloaderCode
+	case plugin.ErrInvalidPackage:
+		code = InvalidPackageCode
+	}
+	return &RPCError{Code: code, Message: perr.Message, Data: perr.Data}
+}
+
+// errorResponse builds an error response for req.
+func errorResponse(req JSONRPCRequest, rpcErr *RPCError) JSONRPCResponse {
+	return JSONRPCResponse{JSONRPC: "2.0", Error: rpcErr, ID: req.ID}
+}
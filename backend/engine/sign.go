@@ -0,0 +1,173 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"example.com/jsonrpcengine/plugin"
+	"example.com/jsonrpcengine/plugin/runner"
+)
+
+// Tools the engine can sign artifacts with.
+const (
+	SignerGPG      = "gpg"
+	SignerMinisign = "minisign"
+)
+
+// SigningConfig selects how the engine signs the ISOs builds produce.
+// Checksums are made for every ISO, signatures only if Tool is set.
+type SigningConfig struct {
+	// Tool is SignerGPG, SignerMinisign or empty for no signatures.
+	Tool string
+	// Key is the GPG key ID or fingerprint to sign with, or the path of the
+	// minisign secret key. Nobody can type a passphrase for the engine, so
+	// the key must be usable without one (gpg-agent with a cached
+	// passphrase, or a minisign key created with -W).
+	Key string
+}
+
+// check reports an error if the configuration can't work on this host.
+func (c SigningConfig) check(r runner.Runner) error {
+	switch c.Tool {
+	case "":
+		return nil
+	case SignerGPG, SignerMinisign:
+	default:
+		return fmt.Errorf("unknown signing tool %q (expected %s or %s)", c.Tool, SignerGPG, SignerMinisign)
+	}
+	if c.Key == "" {
+		return fmt.Errorf("signing with %s needs a key", c.Tool)
+	}
+	if err := runner.LookPath(r, c.Tool); err != nil {
+		return fmt.Errorf("signing tool %s: %w", c.Tool, err)
+	}
+	return nil
+}
+
+// checksums are the sum files made for every ISO, by file extension.
+var checksums = []struct {
+	ext string
+	new func() hash.Hash
+}{
+	{"sha256", sha256.New},
+	{"sha512", sha512.New},
+}
+
+// sealArtifacts writes SHA-256 and SHA-512 sum files next to every ISO a
+// build produced and, if the engine has a signing key, a detached signature.
+// The ISOs get their checksums recorded; the files written are returned as
+// additional artifacts, so they are served and deleted along with the ISO.
+func (e *Engine) sealArtifacts(ctx context.Context, artifacts []plugin.Artifact, output io.Writer) ([]plugin.Artifact, error) {
+	sealed := make([]plugin.Artifact, 0, len(artifacts))
+	var extra []plugin.Artifact
+	// On failure the files made so far are still reported, so that
+	// deleting the build's artifacts removes them.
+	fail := func(err error) ([]plugin.Artifact, error) {
+		return append(append(sealed, artifacts[len(sealed):]...), extra...), err
+	}
+	for _, a := range artifacts {
+		if a.Kind != "iso" {
+			sealed = append(sealed, a)
+			continue
+		}
+		fmt.Fprintf(output, "Computing checksums of %s\n", a.Name)
+		sums, err := fileChecksums(a.Path)
+		if err != nil {
+			return fail(err)
+		}
+		a.Checksums = sums
+		sealed = append(sealed, a)
+
+		for _, c := range checksums {
+			sumFile, err := writeArtifactFile(a, c.ext, "checksum", []byte(fmt.Sprintf("%s  %s\n", sums[c.ext], a.Name)))
+			if err != nil {
+				return fail(err)
+			}
+			extra = append(extra, sumFile)
+		}
+		if e.signing.Tool != "" {
+			signature, err := e.sign(ctx, a, output)
+			if err != nil {
+				return fail(err)
+			}
+			extra = append(extra, signature)
+		}
+	}
+	return append(sealed, extra...), nil
+}
+
+// fileChecksums hashes a file with all algorithms in checksums at once.
+func fileChecksums(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute checksums: %w", err)
+	}
+	defer f.Close()
+	hashes := make([]hash.Hash, len(checksums))
+	writers := make([]io.Writer, len(checksums))
+	for i, c := range checksums {
+		hashes[i] = c.new()
+		writers[i] = hashes[i]
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), f); err != nil {
+		return nil, fmt.Errorf("failed to compute checksums of %s: %w", path, err)
+	}
+	sums := make(map[string]string, len(checksums))
+	for i, c := range checksums {
+		sums[c.ext] = hex.EncodeToString(hashes[i].Sum(nil))
+	}
+	return sums, nil
+}
+
+// writeArtifactFile writes content next to an artifact, named after it with
+// ext appended, and returns the new file as an artifact of the given kind.
+func writeArtifactFile(a plugin.Artifact, ext, kind string, content []byte) (plugin.Artifact, error) {
+	path := a.Path + "." + ext
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return plugin.Artifact{}, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return sidecarArtifact(a, ext, kind, int64(len(content))), nil
+}
+
+// sidecarArtifact describes a file stored next to artifact a with ext appended.
+func sidecarArtifact(a plugin.Artifact, ext, kind string, size int64) plugin.Artifact {
+	sidecar := plugin.Artifact{Name: a.Name + "." + ext, Kind: kind, Path: a.Path + "." + ext, Size: size}
+	if a.DownloadURL != "" {
+		sidecar.DownloadURL = a.DownloadURL + "." + ext
+	}
+	return sidecar
+}
+
+// sign makes a detached signature of an artifact with the configured tool.
+// It runs as the engine's user, whose keyring holds the key.
+func (e *Engine) sign(ctx context.Context, a plugin.Artifact, output io.Writer) (plugin.Artifact, error) {
+	var ext string
+	var args []string
+	switch e.signing.Tool {
+	case SignerGPG:
+		ext = "sig"
+		args = []string{"--batch", "--yes", "--local-user", e.signing.Key, "--detach-sign", "--output", a.Path + ".sig", "--", a.Path}
+	case SignerMinisign:
+		ext = "minisig"
+		args = []string{"-S", "-s", e.signing.Key, "-m", a.Path, "-x", a.Path + ".minisig"}
+	}
+	fmt.Fprintf(output, "Signing %s with %s\n", a.Name, e.signing.Tool)
+	proc, err := e.runner.Start(ctx, runner.Command{Name: e.signing.Tool, Args: args, Stdout: output, Stderr: output})
+	if err == nil {
+		err = proc.Wait()
+	}
+	if err != nil {
+		return plugin.Artifact{}, fmt.Errorf("signing %s with %s failed: %w", a.Name, e.signing.Tool, err)
+	}
+	info, err := os.Stat(a.Path + "." + ext)
+	if err != nil {
+		return plugin.Artifact{}, fmt.Errorf("%s wrote no signature: %w", e.signing.Tool, err)
+	}
+	return sidecarArtifact(a, ext, "signature", info.Size()), nil
+}
@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"example.com/jsonrpcengine/plugin"
+	"example.com/jsonrpcengine/plugin/plugintest"
+	"example.com/jsonrpcengine/plugin/runner"
+)
+
+func TestBuildArtifactsAreSigned(t *testing.T) {
+	// fakeGPG writes a signature to the file after --output.
+	fakeGPG := func(ctx context.Context, cmd runner.Command) error {
+		for i, arg := range cmd.Args[:len(cmd.Args)-1] {
+			if arg == "--output" {
+				return os.WriteFile(cmd.Args[i+1], []byte("signature"), 0644)
+			}
+		}
+		return fmt.Errorf("gpg called without --output: %v", cmd.Args)
+	}
+	fakeRunner := runner.NewFake(map[string]runner.FakeFunc{
+		plugintest.FakeBuildTool: func(ctx context.Context, cmd runner.Command) error { return nil },
+		SignerGPG:                fakeGPG,
+	})
+	dataDir := t.TempDir()
+	pm := plugin.NewPluginManager()
+	pm.RegisterPlugin("fake", plugintest.NewFakePlugin("fake", filepath.Join(dataDir, "isos"), fakeRunner))
+	signing := SigningConfig{Tool: SignerGPG, Key: "0xDEADBEEF"}
+	e, err := New(pm, Config{DataDir: dataDir, Signing: signing, Runner: fakeRunner})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "engine.createProject", Params: []byte(`{"distro_id":"fake"}`), ID: 1})
+	resp := e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "project.buildIso", Params: []byte(`{"project_id":"project-1","skip_preflight":true}`), ID: 2})
+	buildID := resp.Result.(plugin.BuildResponse).BuildID
+	e.queue.Wait()
+
+	rec, _ := e.builds.get(buildID)
+	if rec.Status != StatusCompleted {
+		t.Fatalf("build %s: %s", rec.Status, rec.ErrorMessage)
+	}
+	var kinds []string
+	for _, a := range rec.Artifacts {
+		kinds = append(kinds, a.Name[len(buildID):]+" "+a.Kind)
+		if _, err := os.Stat(a.Path); err != nil {
+			t.Errorf("artifact %s: %v", a.Name, err)
+		}
+	}
+	want := []string{".iso iso", ".iso.cdx.json sbom", ".iso.sha256 checksum", ".iso.sha512 checksum", ".iso.sig signature"}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("artifacts = %q, want %q", kinds, want)
+	}
+
+	iso := rec.Artifacts[0]
+	sum := sha256.Sum256(make([]byte, plugintest.FakeISOSize))
+	if got := iso.Checksums["sha256"]; got != hex.EncodeToString(sum[:]) {
+		t.Errorf("recorded sha256 = %s, want %x", got, sum)
+	}
+	// The sum file can be checked with sha256sum -c in the ISO's directory.
+	content, _ := os.ReadFile(iso.Path + ".sha256")
+	if want := fmt.Sprintf("%x  %s\n", sum, iso.Name); string(content) != want {
+		t.Errorf("sum file holds %q, want %q", content, want)
+	}
+
+	signing.Key = ""
+	if _, err := New(pm, Config{DataDir: dataDir, Signing: signing, Runner: fakeRunner}); err == nil {
+		t.Error("New accepted signing without a key")
+	}
+	signing = SigningConfig{Tool: SignerMinisign, Key: "/etc/distroforge/minisign.key"}
+	if _, err := New(pm, Config{DataDir: dataDir, Signing: signing, Runner: fakeRunner}); err == nil {
+		t.Error("New accepted a signing tool that isn't installed")
+	}
+}
@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"fmt"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+// listTemplates collects the templates of all plugins, or of one if
+// distroID is set. Plugins that don't implement plugin.TemplateProvider
+// have none.
+func (e *Engine) listTemplates(distroID string) ([]plugin.Template, *RPCError) {
+	templates := []plugin.Template{}
+	for _, details := range e.plugins.GetAvailablePlugins() {
+		if distroID != "" && details.ID != distroID {
+			continue
+		}
+		p, _ := e.plugins.GetPlugin(details.ID)
+		provider, ok := p.(plugin.TemplateProvider)
+		if !ok {
+			continue
+		}
+		list, err := provider.ListTemplates()
+		if err != nil {
+			return nil, pluginErrorToRPC(err)
+		}
+		templates = append(templates, list...)
+	}
+	return templates, nil
+}
+
+// checkTemplate makes sure a plugin offers the template a project is to be
+// created from, and the version asked for if any, before the project gets
+// an ID.
+func checkTemplate(p plugin.DistroPlugin, distroID, name string, version int) *RPCError {
+	provider, ok := p.(plugin.TemplateProvider)
+	if !ok {
+		return &RPCError{Code: InvalidParamsCode, Message: fmt.Sprintf("Distro '%s' has no templates", distroID)}
+	}
+	templates, err := provider.ListTemplates()
+	if err != nil {
+		return pluginErrorToRPC(err)
+	}
+	names := []string{}
+	for _, t := range templates {
+		if t.Name == name {
+			if version != 0 && !containsVersion(t.Versions, version) {
+				return &RPCError{Code: InvalidParamsCode, Message: fmt.Sprintf("Template '%s' has no version %d", name, version), Data: map[string]interface{}{"versions": t.Versions}}
+			}
+			return nil
+		}
+		names = append(names, t.Name)
+	}
+	return &RPCError{Code: InvalidParamsCode, Message: fmt.Sprintf("Template '%s' not found for distro '%s'", name, distroID), Data: map[string]interface{}{"templates": names}}
+}
+
+func containsVersion(versions []int, version int) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// Transport carries JSON-RPC messages between the engine and a client.
+// Each message is one complete JSON document. ReadMessage returns io.EOF
+// once the client has gone away.
+type Transport interface {
+	ReadMessage() ([]byte, error)
+	WriteMessage(msg []byte) error
+}
+
+// StdioTransport exchanges newline-delimited JSON messages over a reader and
+// writer, typically the engine's stdin and stdout.
+type StdioTransport struct {
+	reader *bufio.Reader
+
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+// NewStdioTransport creates a newline-delimited transport over r and w.
+func NewStdioTransport(r io.Reader, w io.Writer) *StdioTransport {
+	return &StdioTransport{reader: bufio.NewReader(r), writer: w}
+}
+
+// ReadMessage returns the next line, without its trailing newline.
+func (t *StdioTransport) ReadMessage() ([]byte, error) {
+	line, err := t.reader.ReadBytes('\n')
+	if len(line) > 0 && err == io.EOF {
+		// Accept a final message that isn't newline-terminated.
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if line[len(line)-1] == '\n' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+// WriteMessage writes msg followed by a newline. It is safe for concurrent use.
+func (t *StdioTransport) WriteMessage(msg []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, err := t.writer.Write(append(msg, '\n'))
+	return err
+}
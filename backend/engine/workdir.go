@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+// Work directory policies: what happens to a project's work directory after a build.
+const (
+	WorkdirClean      = "clean"       // always remove it
+	WorkdirKeepFailed = "keep-failed" // remove it after successful builds, keep it for debugging otherwise
+	WorkdirKeep       = "keep"        // always keep it
+)
+
+// Reclaimed reports the disk space freed by cleaning up one thing.
+type Reclaimed struct {
+	What      string `json:"what"` // "workdir", "cache" or "artifacts"
+	ProjectID string `json:"project_id,omitempty"`
+	DistroID  string `json:"distro_id,omitempty"`
+	BuildID   string `json:"build_id,omitempty"`
+	Bytes     int64  `json:"reclaimed_bytes"`
+	Error     string `json:"error,omitempty"`
+}
+
+// GCReport is the result of engine.gc.
+type GCReport struct {
+	ReclaimedBytes int64       `json:"reclaimed_bytes"`
+	Items          []Reclaimed `json:"items"`
+	// Skipped lists what was left alone because builds were using it.
+	Skipped []string `json:"skipped"`
+}
+
+// workdirLock returns the lock that guards a project's work directory. It
+// is held while the project builds, so that cleaning up never pulls the work
+// directory out from under a running build.
+func (e *Engine) workdirLock(projectID string) *sync.Mutex {
+	lock, _ := e.workdirLocks.LoadOrStore(projectID, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// applyWorkdirPolicy removes a project's work directory after a build if
+// the policy says so. Callers must hold the project's work directory lock.
+func (e *Engine) applyWorkdirPolicy(rec BuildRecord, buildErr error) {
+	if e.workdirPolicy == WorkdirKeep || (e.workdirPolicy == WorkdirKeepFailed && buildErr != nil) {
+		return
+	}
+	p, found := e.plugins.GetPlugin(rec.DistroID)
+	if !found {
+		return
+	}
+	cleaner, ok := p.(plugin.WorkdirCleaner)
+	if !ok {
+		return
+	}
+	reclaimed, err := cleaner.CleanWorkdir(context.Background(), rec.ProjectID)
+	if err != nil {
+		log.Printf("Failed to clean work directory of project %s: %v", rec.ProjectID, err)
+		return
+	}
+	if output := e.output(rec.BuildID); output != nil && reclaimed > 0 {
+		fmt.Fprintf(output, "Removed the work directory, %s freed\n", plugin.FormatBytes(uint64(reclaimed)))
+	}
+}
+
+// cleanWorkdir removes a project's work directory unless a build is using it.
+func (e *Engine) cleanWorkdir(meta ProjectMetadata, cleaner plugin.WorkdirCleaner) (Reclaimed, error) {
+	lock := e.workdirLock(meta.ID)
+	if !lock.TryLock() {
+		return Reclaimed{}, ErrBuildInProgress
+	}
+	defer lock.Unlock()
+	reclaimed, err := cleaner.CleanWorkdir(context.Background(), meta.ID)
+	return Reclaimed{What: "workdir", ProjectID: meta.ID, DistroID: meta.DistroID, Bytes: reclaimed}, err
+}
+
+// gc removes the work directories of all projects that aren't building,
+// applies the artifact retention rules and, if no build is running at all,
+// trims the plugins' shared caches.
+func (e *Engine) gc() GCReport {
+	report := GCReport{Items: []Reclaimed{}, Skipped: []string{}}
+	add := func(item Reclaimed, err error) {
+		if err != nil {
+			item.Error = err.Error()
+		}
+		report.Items = append(report.Items, item)
+		report.ReclaimedBytes += item.Bytes
+	}
+
+	e.mu.Lock()
+	projects := make([]ProjectMetadata, 0, len(e.projects))
+	for _, meta := range e.projects {
+		projects = append(projects, meta)
+	}
+	e.mu.Unlock()
+	sort.Slice(projects, func(i, j int) bool { return projects[i].ID < projects[j].ID })
+
+	for _, meta := range projects {
+		p, found := e.plugins.GetPlugin(meta.DistroID)
+		if !found {
+			continue
+		}
+		cleaner, ok := p.(plugin.WorkdirCleaner)
+		if !ok {
+			continue
+		}
+		item, err := e.cleanWorkdir(meta, cleaner)
+		if err == ErrBuildInProgress {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("work directory of %s", meta.ID))
+			continue
+		}
+		add(item, err)
+	}
+	for _, item := range e.applyRetention() {
+		add(item, nil)
+	}
+
+	running := false
+	for _, b := range e.queue.List() {
+		running = running || b.State == BuildRunning
+	}
+	for _, details := range e.plugins.GetAvailablePlugins() {
+		p, _ := e.plugins.GetPlugin(details.ID)
+		cleaner, ok := p.(plugin.WorkdirCleaner)
+		if !ok {
+			continue
+		}
+		if running {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("cache of %s", details.ID))
+			continue
+		}
+		reclaimed, err := cleaner.CleanCache(context.Background())
+		add(Reclaimed{What: "cache", DistroID: details.ID, Bytes: reclaimed}, err)
+	}
+	return report
+}
@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"example.com/jsonrpcengine/plugin"
+	"example.com/jsonrpcengine/plugin/plugintest"
+	"example.com/jsonrpcengine/plugin/runner"
+)
+
+func TestWorkdirPolicy(t *testing.T) {
+	tests := []struct {
+		policy string
+		fail   bool
+		kept   bool
+	}{
+		{WorkdirClean, false, false},
+		{WorkdirClean, true, false},
+		{WorkdirKeepFailed, false, false},
+		{WorkdirKeepFailed, true, true},
+		{WorkdirKeep, false, true},
+		{WorkdirKeep, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/fail=%v", tt.policy, tt.fail), func(t *testing.T) {
+			fakeRunner := runner.NewFake(map[string]runner.FakeFunc{
+				plugintest.FakeBuildTool: func(ctx context.Context, cmd runner.Command) error {
+					if tt.fail {
+						return fmt.Errorf("exit status 1")
+					}
+					return nil
+				},
+			})
+			pm := plugin.NewPluginManager()
+			dataDir := t.TempDir()
+			pm.RegisterPlugin("fake", plugintest.NewFakePlugin("fake", filepath.Join(dataDir, "isos"), fakeRunner))
+			e, err := New(pm, Config{DataDir: dataDir, WorkdirPolicy: tt.policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "engine.createProject", Params: []byte(`{"distro_id":"fake"}`), ID: 1})
+			if resp := e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "project.buildIso", Params: []byte(`{"project_id":"project-1","skip_preflight":true}`), ID: 2}); resp.Error != nil {
+				t.Fatalf("buildIso failed: %+v", resp.Error)
+			}
+			e.queue.Wait()
+
+			resp := e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "project.cleanWorkdir", Params: []byte(`{"project_id":"project-1"}`), ID: 3})
+			if resp.Error != nil {
+				t.Fatalf("cleanWorkdir failed: %+v", resp.Error)
+			}
+			var want int64
+			if tt.kept {
+				want = plugintest.FakeWorkdirSize
+			}
+			if got := resp.Result.(Reclaimed).Bytes; got != want {
+				t.Errorf("cleanWorkdir after the build reclaimed %d bytes, want %d", got, want)
+			}
+		})
+	}
+
+	if _, err := New(plugin.NewPluginManager(), Config{DataDir: t.TempDir(), WorkdirPolicy: "sometimes"}); err == nil {
+		t.Error("New accepted an unknown work directory policy")
+	}
+}
+
+func TestCleanupSkipsRunningBuilds(t *testing.T) {
+	e := newBlockingEngine(t, Config{WorkdirPolicy: WorkdirKeep})
+	resp := e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "project.buildIso", Params: []byte(`{"project_id":"project-1","skip_preflight":true}`), ID: 2})
+	buildID := resp.Result.(plugin.BuildResponse).BuildID
+	waitForStatus(t, e, buildID, StatusBuilding)
+
+	resp = e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "project.cleanWorkdir", Params: []byte(`{"project_id":"project-1"}`), ID: 3})
+	if resp.Error == nil || resp.Error.Code != BuildInProgressCode {
+		t.Errorf("cleanWorkdir during a build returned %+v, want BuildInProgress", resp)
+	}
+	report := e.gc()
+	if len(report.Items) != 0 || len(report.Skipped) != 2 {
+		t.Errorf("gc during a build = %+v, want the work directory and cache skipped", report)
+	}
+
+	e.Handle(JSONRPCRequest{JSONRPC: "2.0", Method: "project.cancelBuild", Params: []byte(`{"project_id":"project-1","build_id":"` + buildID + `"}`), ID: 4})
+	e.queue.Wait()
+	report = e.gc()
+	if want := int64(plugintest.FakeWorkdirSize + plugintest.FakeCacheSize); report.ReclaimedBytes != want || len(report.Skipped) != 0 {
+		t.Errorf("gc after the build = %+v, want %d bytes reclaimed", report, want)
+	}
+}
@@ -1,53 +1,55 @@
 package arch
 
 import (
-	"bufio"
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
-	"time"
 
 	"example.com/jsonrpcengine/plugin" // Module path from go.mod
+	"example.com/jsonrpcengine/plugin/runner"
 )
 
 // ArchPlugin implements the plugin.DistroPlugin interface for Arch Linux.
 type ArchPlugin struct {
-	projectsRoot string
-	isosRoot     string
-	workRoot     string
+	projectsRoot   string
+	isosRoot       string
+	workRoot       string
+	cacheDir       string // pacman package cache shared by all projects
+	templatesRoot  string // user templates
+	archisoConfigs string // profiles of the archiso package
+	runner         runner.Runner
+	mountInfo      string // mount table checked for mounts mkarchiso left behind
+	syncDBDir      string // pacman's sync databases, read to check package names
+
+	syncDBsMu sync.Mutex
+	syncDBs   map[string]*syncDB // by path
+
+	templatesMu sync.Mutex // serializes saving templates, which numbers versions
 }
 
 // NewArchPlugin creates and initializes a new ArchPlugin.
 // It now determines paths based on the user's home directory.
-func NewArchPlugin() (*ArchPlugin, error) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		log.Printf("Warning: Could not get user home directory (%v), using current directory for .distroforge_data", err)
-		// Get current working directory as fallback base
-		currentDir, cwdErr := os.Getwd()
-		if cwdErr != nil {
-			// This is a more serious fallback, unlikely to happen but possible
-			log.Printf("Critical: Could not get current working directory (%v), using \".\" as homeDir fallback", cwdErr)
-			homeDir = "."
-		} else {
-			homeDir = currentDir
-		}
-		// To avoid cluttering the current directory directly if it's a fallback,
-		// still use a subdirectory.
-		homeDir = filepath.Join(homeDir, ".distroforge_data_fallback")
-		log.Printf("Fallback data directory will be: %s", homeDir)
-	}
+// Build tools are executed through r, which decides how root privileges are obtained.
+func NewArchPlugin(r runner.Runner) (*ArchPlugin, error) {
+	return NewArchPluginWithDataDir(plugin.DefaultDataDir(), r)
+}
 
-	projectsRoot := filepath.Join(homeDir, ".distroforge", "projects")
-	isosRoot := filepath.Join(homeDir, ".distroforge", "isos")
-	workRoot := filepath.Join(homeDir, ".distroforge", "work", "archiso")
+// NewArchPluginWithDataDir creates an ArchPlugin that keeps all of its state
+// (profiles, ISOs, mkarchiso work directories and the package cache) below dataDir.
+func NewArchPluginWithDataDir(dataDir string, r runner.Runner) (*ArchPlugin, error) {
+	projectsRoot := filepath.Join(dataDir, "projects")
+	isosRoot := filepath.Join(dataDir, "isos")
+	workRoot := filepath.Join(dataDir, "work", "archiso")
+	cacheDir := filepath.Join(dataDir, "cache", "pacman", "pkg")
+	templatesRoot := filepath.Join(dataDir, "templates")
 
-	for _, path := range []string{projectsRoot, isosRoot, workRoot} {
+	for _, path := range []string{projectsRoot, isosRoot, workRoot, cacheDir, templatesRoot} {
 		if err := os.MkdirAll(path, 0755); err != nil {
 			return nil, fmt.Errorf("failed to create directory %s: %w", path, err)
 		}
@@ -56,9 +58,16 @@ func NewArchPlugin() (*ArchPlugin, error) {
 	log.Printf("ArchPlugin initialized with paths: projectsRoot=%s, isosRoot=%s, workRoot=%s", projectsRoot, isosRoot, workRoot)
 
 	return &ArchPlugin{
-		projectsRoot: projectsRoot,
-		isosRoot:     isosRoot,
-		workRoot:     workRoot,
+		projectsRoot:   projectsRoot,
+		isosRoot:       isosRoot,
+		workRoot:       workRoot,
+		cacheDir:       cacheDir,
+		templatesRoot:  templatesRoot,
+		archisoConfigs: archisoConfigsDir,
+		runner:         r,
+		mountInfo:      plugin.MountInfoPath,
+		syncDBDir:      hostSyncDBDir,
+		syncDBs:        make(map[string]*syncDB),
 	}, nil
 }
 
@@ -75,8 +84,32 @@ func (p *ArchPlugin) projectProfilePath(projectID string) string {
 	return filepath.Join(p.projectsRoot, projectID, "arch_profile")
 }
 
-// CreateProject initializes a new Arch Linux project.
+// CreateProject initializes a new Arch Linux project, from the template
+// named by params["template"] if there is one. The project's directory
+// must not exist yet; a project that can't be set up completely is removed
+// again.
 func (p *ArchPlugin) CreateProject(projectID string, params map[string]interface{}) error {
+	projectDir := filepath.Dir(p.projectProfilePath(projectID))
+	if _, err := os.Lstat(projectDir); err == nil {
+		return fmt.Errorf("project directory %s already exists", projectDir)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to check project directory %s: %w", projectDir, err)
+	}
+	if err := p.createProfile(projectID, params); err != nil {
+		os.RemoveAll(projectDir)
+		return err
+	}
+	return nil
+}
+
+func (p *ArchPlugin) createProfile(projectID string, params map[string]interface{}) error {
+	if template, _ := params["template"].(string); template != "" && template != defaultTemplate {
+		version, _ := params["template_version"].(float64)
+		if err := p.createFromTemplate(projectID, template, int(version)); err != nil {
+			return err
+		}
+		return p.finishProfile(projectID)
+	}
 	profilePath := p.projectProfilePath(projectID)
 	airootfsPath := filepath.Join(profilePath, "airootfs")
 
@@ -98,7 +131,7 @@ iso_name="archlinux-%s"
 iso_label="ARCH_%s"
 iso_publisher="Arch Linux Custom Build"
 iso_application="Arch Linux Live/Rescue Image"
-iso_version="$(date +%%Y.%%m.%%d)"
+iso_version="$(date --date="@${SOURCE_DATE_EPOCH:-$(date +%%s)}" +%%Y.%%m.%%d)"
 install_dir="arch"
 buildmodes=('iso')
 bootmodes=('bios.syslinux.mbr' 'bios.syslinux.eltorito'
@@ -118,28 +151,48 @@ file_permissions=(
 	}
 
 	pacmanConfFile := filepath.Join(profilePath, "pacman.conf")
-	// A very basic pacman.conf. For a real build, this needs to be more robust,
-	// potentially copying from host or allowing user customization.
-	// IMPORTANT: mkarchiso needs a valid mirrorlist. This basic conf assumes
-	// the build environment (container/VM) has /etc/pacman.d/mirrorlist correctly set up.
+	// A basic pacman.conf that checks signatures like Arch's own; the
+	// repositories and options can be changed with the RepoEditor methods.
+	// Builds use the project's mirrorlist in place of the host's.
 	pacmanConfContent := []byte(`[options]
 HoldPkg     = pacman glibc
 Architecture = auto
-SigLevel    = Never
+ParallelDownloads = 5
+SigLevel    = Required DatabaseOptional
+LocalFileSigLevel = Optional
 
 [core]
 Include = /etc/pacman.d/mirrorlist
 
 [extra]
 Include = /etc/pacman.d/mirrorlist
-
-[community]
-Include = /etc/pacman.d/mirrorlist
 `)
 	if err := os.WriteFile(pacmanConfFile, pacmanConfContent, 0644); err != nil {
 		return fmt.Errorf("failed to write pacman.conf: %w", err)
 	}
-	return nil
+	return p.finishProfile(projectID)
+}
+
+// finishProfile gives a new project the default mirror unless its profile
+// brought a mirrorlist, and the packages and boot configuration of its
+// bootloader. Profiles with custom bootmodes are left as they are. The
+// bootloader's packages aren't checked against the repositories: a host
+// without their sync databases can still create projects.
+func (p *ArchPlugin) finishProfile(projectID string) error {
+	profilePath := p.projectProfilePath(projectID)
+	if _, err := os.Stat(filepath.Join(profilePath, mirrorlistFile)); os.IsNotExist(err) {
+		if _, err := p.setMirrors(projectID, []string{defaultMirror}, true); err != nil {
+			return err
+		}
+	}
+	bootloader, err := readBootloader(profilePath)
+	if err != nil {
+		return err
+	}
+	if bootloader.Bootloader == customBootloader {
+		return nil
+	}
+	return p.setBootloader(projectID, bootloader.Bootloader, false)
 }
 
 func (p *ArchPlugin) GetDetails(projectID string) (plugin.DetailsResponse, error) {
@@ -151,20 +204,37 @@ func (p *ArchPlugin) GetDetails(projectID string) (plugin.DetailsResponse, error
 	packagesResp, _ := p.GetPackages(projectID) // Errors ignored for now, default to empty
 	hostnameResp, _ := p.GetHostname(projectID)
 	bootloaderResp, _ := p.GetBootloader(projectID)
-	buildStatusResp, _ := p.GetBuildStatus(projectID, projectID) // Use projectID as a simple buildID
 
 	return plugin.DetailsResponse{
-		ProjectID:   projectID,
-		DistroID:    "arch",
-		Packages:    packagesResp.Packages,
-		Bootloader:  bootloaderResp.Bootloader,
-		Hostname:    hostnameResp.Hostname,
-		BuildStatus: buildStatusResp.Status,
+		ProjectID:  projectID,
+		DistroID:   "arch",
+		Packages:   packagesResp.Packages,
+		Bootloader: bootloaderResp.Bootloader,
+		Hostname:   hostnameResp.Hostname,
 	}, nil
 }
 
+// SetPackages replaces the package list after checking the names it adds
+// against the sync databases of the project's repositories. Names already
+// on the list aren't checked again, so that a package the repositories
+// dropped since doesn't get in the way of other changes.
 func (p *ArchPlugin) SetPackages(projectID string, packages []string) error {
 	profilePath := p.projectProfilePath(projectID)
+	current, _ := p.GetPackages(projectID)
+	var added []string
+	for _, pkg := range packages {
+		if !containsString(current.Packages, pkg) {
+			added = append(added, pkg)
+		}
+	}
+	if err := p.validatePackages(profilePath, added); err != nil {
+		return err
+	}
+	return writePackages(profilePath, packages)
+}
+
+// writePackages writes the package list of a profile.
+func writePackages(profilePath string, packages []string) error {
 	packagesFile := filepath.Join(profilePath, "packages.x86_64")
 	var content strings.Builder
 	for _, pkg := range packages {
@@ -237,196 +307,179 @@ func (p *ArchPlugin) GetHostname(projectID string) (plugin.HostnameResponse, err
 	return plugin.HostnameResponse{Hostname: strings.TrimSpace(string(content))}, nil
 }
 
-func (p *ArchPlugin) SetBootloader(projectID string, bootloader string) error {
-	// Storing the choice. Real implementation requires modifying profiledef.sh bootmodes
-	// and ensuring necessary packages (grub, systemd-boot, syslinux) are listed.
-	bootloaderFile := filepath.Join(p.projectProfilePath(projectID), ".bootloader")
-	if err := os.WriteFile(bootloaderFile, []byte(bootloader), 0644); err != nil {
-		return fmt.Errorf("failed to store bootloader choice: %w", err)
+// buildTools are the packages whose versions are recorded with every build.
+var buildTools = []string{"archiso", "squashfs-tools", "libisoburn"}
+
+// BuildISO executes mkarchiso and waits for it to finish.
+func (p *ArchPlugin) BuildISO(ctx context.Context, req plugin.BuildRequest) (plugin.BuildResult, error) {
+	projectID := req.ProjectID
+	profilePath := p.projectProfilePath(projectID)
+	if req.ConfigFrom != "" {
+		profilePath = req.ConfigFrom
 	}
-	log.Printf("Bootloader for project %s set to '%s'. Manual profiledef.sh adjustment may be needed.", projectID, bootloader)
-	return nil
-}
+	// Every build gets an ISO directory of its own, so that the ISO found
+	// below is the one this build made, not one left by an earlier build.
+	isoOutputDir := filepath.Join(p.isosRoot, projectID, req.BuildID)
+	workDir := filepath.Join(p.workRoot, projectID)
 
-func (p *ArchPlugin) GetBootloader(projectID string) (plugin.BootloaderResponse, error) {
-	// Reading stored choice. A more advanced version would parse profiledef.sh.
-	bootloaderFile := filepath.Join(p.projectProfilePath(projectID), ".bootloader")
-	content, err := os.ReadFile(bootloaderFile)
+	result := plugin.BuildResult{ToolVersions: p.toolVersions(ctx)}
+	configHash, err := plugin.HashDir(profilePath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			// Try to infer from default profiledef.sh if possible, or return common default
-			return plugin.BootloaderResponse{Bootloader: "grub/syslinux"}, nil // Default from template
-		}
-		return plugin.BootloaderResponse{}, fmt.Errorf("failed to read bootloader choice: %w", err)
+		return result, err
 	}
-	return plugin.BootloaderResponse{Bootloader: strings.TrimSpace(string(content))}, nil
-}
-
-// projectBuildLogPath returns the path to the build log file for a given project and build ID.
-func (p *ArchPlugin) projectBuildLogPath(projectID string, buildID string) string {
-	// For simplicity, using projectID as buildID for now if buildID is empty
-	// In a multi-build system, buildID would be distinct.
-	effectiveBuildID := buildID
-	if effectiveBuildID == "" {
-		effectiveBuildID = projectID
+	result.ConfigHash = configHash
+	if result.Inputs, err = readInputs(profilePath); err != nil {
+		return result, err
 	}
-	return filepath.Join(p.projectProfilePath(projectID), fmt.Sprintf("build-%s.log", effectiveBuildID))
-}
-
-
-// BuildISO executes mkarchiso. This is a simplified blocking version.
-// TODO: Implement non-blocking execution and proper streaming.
-func (p *ArchPlugin) BuildISO(projectID string) (plugin.BuildResponse, error) {
-	profilePath := p.projectProfilePath(projectID)
-	isoOutputDir := filepath.Join(p.isosRoot, projectID)
-	workDir := filepath.Join(p.workRoot, projectID)
-	buildID := projectID // Simple build ID for now
-
-	for _, path := range []string{isoOutputDir, workDir} {
-		if err := os.MkdirAll(path, 0755); err != nil {
-			return plugin.BuildResponse{}, fmt.Errorf("failed to create directory %s: %w", path, err)
+	if req.SaveConfigTo != "" {
+		if err := plugin.CopyDir(profilePath, req.SaveConfigTo); err != nil {
+			return result, err
 		}
 	}
 
-	// Store initial build status (simplified)
-	p.updateBuildStatus(projectID, buildID, "building", "", 0, "")
+	if err := os.MkdirAll(isoOutputDir, 0755); err != nil {
+		return result, fmt.Errorf("failed to create directory %s: %w", isoOutputDir, err)
+	}
+	// A build pinned to another date or snapshot must not resume this one.
+	stamp := configHash
+	if r := req.Reproducible; r != nil {
+		stamp = fmt.Sprintf("%s %d %s", configHash, r.SourceDateEpoch, r.Snapshot)
+	}
+	if err := p.prepareWorkdir(ctx, workDir, stamp, req.Output); err != nil {
+		return result, err
+	}
+	pacmanConf, err := p.writeBuildPacmanConf(profilePath, workDir, req.Reproducible)
+	if err != nil {
+		return result, err
+	}
 
+	// mkarchiso needs root for loopback mounts, etc.; the runner takes care of that.
+	// LC_ALL=C keeps its output in a stable, parseable form.
+	cmd := runner.Command{
+		Name: "mkarchiso",
+		Args: []string{"-v", "-w", workDir, "-o", isoOutputDir, "-C", pacmanConf, profilePath},
+		Env:  append([]string{"LC_ALL=C"}, req.Reproducible.Env()...),
+	}
+	output := io.MultiWriter(req.Output, newProgressParser(req.ReportProgress))
+	cmd.Stdout = output
+	cmd.Stderr = output
 
-	logFile, err := os.Create(p.projectBuildLogPath(projectID, buildID))
+	proc, err := p.runner.Start(ctx, cmd)
 	if err != nil {
-		return plugin.BuildResponse{}, fmt.Errorf("failed to create build log file: %w", err)
+		return result, fmt.Errorf("mkarchiso failed to start: %w", err)
 	}
-	defer logFile.Close()
 
-	cmd := exec.Command("sudo", "mkarchiso", // mkarchiso often needs root for loopback mounts, etc.
-		"-v",
-		"-w", workDir,
-		"-o", isoOutputDir,
-		profilePath,
-	)
-	cmd.Stdout = logFile
-	cmd.Stderr = logFile
+	inv := proc.Invocation()
+	result.CommandLine = inv.Args
+	result.Environment = inv.Env
+	fmt.Fprintf(req.Output, "$ %s\n", inv)
+	log.Printf("Executing command for project %s (build %s): %s", projectID, req.BuildID, inv)
 
-	log.Printf("Executing command for project %s: %s", projectID, cmd.String())
-	log.Printf("Build log: %s", logFile.Name())
+	err = proc.Wait()
+	result.ExitCode = runner.ExitCode(err)
+	if err != nil {
+		log.Printf("mkarchiso project %s (build %s) failed: %v", projectID, req.BuildID, err)
+		// A killed mkarchiso doesn't get to run its cleanup trap.
+		p.unmountLeftovers(workDir, req.Output)
+		return result, fmt.Errorf("mkarchiso failed: %w", err)
+	}
+	if err := finishWorkdir(workDir); err != nil {
+		log.Printf("Failed to mark work directory %s as finished: %v", workDir, err)
+	}
 
-	err = cmd.Start()
+	matches, _ := filepath.Glob(filepath.Join(isoOutputDir, "*.iso"))
+	if len(matches) != 1 {
+		log.Printf("mkarchiso project %s (build %s) completed but %d ISOs found in %s.", projectID, req.BuildID, len(matches), isoOutputDir)
+		return result, fmt.Errorf("build succeeded but %d ISOs instead of one found in %s", len(matches), isoOutputDir)
+	}
+	info, err := os.Stat(matches[0])
 	if err != nil {
-		p.updateBuildStatus(projectID, buildID, "failed", fmt.Sprintf("Failed to start mkarchiso: %v", err), 0, "")
-		return plugin.BuildResponse{}, fmt.Errorf("mkarchiso failed to start: %w", err)
-	}
-
-	// This is still somewhat blocking for the purpose of the JSON-RPC call,
-	// but the actual build runs in a subprocess. A true non-blocking approach
-	// would return immediately and update status via background goroutine.
-	go func() {
-		err := cmd.Wait()
-		if err != nil {
-			log.Printf("mkarchiso project %s (build %s) failed: %v", projectID, buildID, err)
-			p.updateBuildStatus(projectID, buildID, "failed", err.Error(), 0, "")
-		} else {
-			isoNamePattern := fmt.Sprintf("archlinux-%s-*.iso", projectID)
-			matches, _ := filepath.Glob(filepath.Join(isoOutputDir, isoNamePattern))
-			if len(matches) > 0 {
-				downloadURL := fmt.Sprintf("/isos/%s/%s", projectID, filepath.Base(matches[0]))
-				p.updateBuildStatus(projectID, buildID, "completed", "", 100, downloadURL)
-				log.Printf("mkarchiso project %s (build %s) completed. ISO: %s", projectID, buildID, matches[0])
-			} else {
-				p.updateBuildStatus(projectID, buildID, "failed", "Build succeeded but ISO not found", 0, "")
-				log.Printf("mkarchiso project %s (build %s) completed but no ISO found matching pattern %s in %s.", projectID, buildID, isoNamePattern, isoOutputDir)
-			}
-		}
-	}()
+		return result, fmt.Errorf("failed to stat ISO: %w", err)
+	}
+	iso := plugin.Artifact{
+		Name:        info.Name(),
+		Kind:        "iso",
+		Path:        matches[0],
+		Size:        info.Size(),
+		DownloadURL: fmt.Sprintf("/isos/%s/%s/%s", projectID, req.BuildID, info.Name()),
+	}
+	result.Artifacts = []plugin.Artifact{iso}
 
-	return plugin.BuildResponse{BuildID: buildID, Status: "building"}, nil
+	manifest, err := readManifest(workDir)
+	if err != nil {
+		return result, err
+	}
+	result.Manifest = &manifest
+	sbom, err := writeSBOM(manifest, iso, projectID, req.BuildID)
+	if err != nil {
+		return result, err
+	}
+	result.Artifacts = append(result.Artifacts, sbom)
+	log.Printf("mkarchiso project %s (build %s) completed. ISO: %s", projectID, req.BuildID, matches[0])
+	return result, nil
 }
 
-// StreamBuildOutput provides a channel to stream lines from the build log.
-func (p *ArchPlugin) StreamBuildOutput(projectID string, buildID string) (<-chan []byte, error) {
-	logPath := p.projectBuildLogPath(projectID, buildID)
-	if _, err := os.Stat(logPath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("build log for project %s build %s not found", projectID, buildID)
+// unmountLeftovers unmounts whatever is still mounted below workDir, e.g.
+// the proc and dev mounts of the airootfs chroot after a cancelled build.
+func (p *ArchPlugin) unmountLeftovers(workDir string, output io.Writer) {
+	mounts, err := plugin.MountsBelow(p.mountInfo, workDir)
+	if err != nil {
+		log.Printf("Could not check for leftover mounts in %s: %v", workDir, err)
+		return
 	}
-
-	outputChan := make(chan []byte)
-
-	// This is a simplified streaming: it will try to stream the current content
-	// and then new content as it's written. True real-time tailing is more complex.
-	go func() {
-		defer close(outputChan)
-		file, err := os.Open(logPath)
-		if err != nil {
-			log.Printf("Error opening log file for streaming: %v", err)
-			return
+	for _, mountPoint := range mounts {
+		fmt.Fprintf(output, "Unmounting leftover mount %s\n", mountPoint)
+		if err := p.unmount(mountPoint, output); err != nil {
+			log.Printf("Failed to unmount %s: %v", mountPoint, err)
 		}
-		defer file.Close()
-
-		reader := bufio.NewReader(file)
-		for {
-			line, err := reader.ReadBytes('\n')
-			if len(line) > 0 {
-				outputChan <- line
-			}
-			if err == io.EOF {
-				// Check build status, if completed or failed, stop streaming.
-				// This requires access to the build status or a way to signal completion.
-				// For this example, we'll just sleep and try again for a while.
-				// A more robust solution would use fsnotify or a similar mechanism.
-				status, _ := p.GetBuildStatus(projectID, buildID)
-				if status.Status == "completed" || status.Status == "failed" {
-					break
-				}
-				time.Sleep(1 * time.Second) // Poll for new lines
-			} else if err != nil {
-				log.Printf("Error reading log file line: %v", err)
-				break
-			}
-		}
-	}()
-	return outputChan, nil
+	}
 }
 
-// buildStatusStore is a simple in-memory store for build statuses.
-// In a real app, this would be persistent (e.g., database, Redis).
-var buildStatusStore = make(map[string]plugin.BuildStatusResponse)
-var buildStatusMutex = &sync.Mutex{}
-
-func (p *ArchPlugin) updateBuildStatus(projectID, buildID, status, errMsg string, progress int, downloadURL string) {
-	buildStatusMutex.Lock()
-	defer buildStatusMutex.Unlock()
-	key := projectID + "_" + buildID
-	buildStatusStore[key] = plugin.BuildStatusResponse{
-		BuildID:      buildID,
-		Status:       status,
-		Progress:     progress,
-		ErrorMessage: errMsg,
-		DownloadURL:  downloadURL,
+// unmount unmounts mountPoint, detaching it lazily if it is busy. It doesn't
+// take a context: it also runs after the build's own context was cancelled.
+func (p *ArchPlugin) unmount(mountPoint string, output io.Writer) error {
+	if err := p.run(context.Background(), output, "umount", mountPoint); err != nil {
+		log.Printf("umount %s failed (%v), detaching it lazily", mountPoint, err)
+		return p.run(context.Background(), output, "umount", "-l", mountPoint)
 	}
+	return nil
 }
 
+// run executes a command through the plugin's runner and waits for it.
+func (p *ArchPlugin) run(ctx context.Context, output io.Writer, name string, args ...string) error {
+	proc, err := p.runner.Start(ctx, runner.Command{Name: name, Args: args, Stdout: output, Stderr: output})
+	if err != nil {
+		return err
+	}
+	return proc.Wait()
+}
 
-func (p *ArchPlugin) GetBuildStatus(projectID string, buildID string) (plugin.BuildStatusResponse, error) {
-	buildStatusMutex.Lock()
-	defer buildStatusMutex.Unlock()
-	key := projectID + "_" + buildID
-	status, found := buildStatusStore[key]
-	if !found {
-		// If not in store, check if an old ISO exists (very rough heuristic for pre-existing builds)
-		isoNamePattern := fmt.Sprintf("archlinux-%s-*.iso", projectID)
-		isoDir := filepath.Join(p.isosRoot, projectID)
-		matches, _ := filepath.Glob(filepath.Join(isoDir, isoNamePattern))
-		if len(matches) > 0 {
-			return plugin.BuildStatusResponse{
-				BuildID:     buildID,
-				Status:      "completed",
-				DownloadURL: fmt.Sprintf("/isos/%s/%s", projectID, filepath.Base(matches[0])),
-				Progress: 100,
-			}, nil
+// toolVersions asks pacman for the installed versions of buildTools. A
+// failed query is logged and leaves the versions out of the build record.
+func (p *ArchPlugin) toolVersions(ctx context.Context) map[string]string {
+	var out bytes.Buffer
+	proc, err := runner.Unprivileged(p.runner).Start(ctx, runner.Command{
+		Name:   "pacman",
+		Args:   append([]string{"-Q"}, buildTools...),
+		Env:    []string{"LC_ALL=C"},
+		Stdout: &out,
+		Stderr: io.Discard,
+	})
+	if err == nil {
+		// pacman exits non-zero if any package is missing but still lists the others.
+		_ = proc.Wait()
+	}
+	versions := make(map[string]string)
+	for _, line := range strings.Split(out.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			versions[fields[0]] = fields[1]
 		}
-		return plugin.BuildStatusResponse{BuildID: buildID, Status: "unknown"}, nil
 	}
-	return status, nil
+	if len(versions) == 0 {
+		log.Printf("Could not determine versions of %s", strings.Join(buildTools, ", "))
+		return nil
+	}
+	return versions
 }
 
 var _ plugin.DistroPlugin = (*ArchPlugin)(nil)
-// Required imports: bufio, io, sync, time (for StreamBuildOutput and GetBuildStatus with polling/mutex)
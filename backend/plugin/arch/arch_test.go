@@ -0,0 +1,1557 @@
+package arch
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/jsonrpcengine/plugin"
+	"example.com/jsonrpcengine/plugin/plugintest"
+	"example.com/jsonrpcengine/plugin/runner"
+)
+
+var isoNameRe = regexp.MustCompile(`(?m)^iso_name="(.*)"$`)
+
+// fakeMkarchiso mimics a successful mkarchiso run: it logs a few lines,
+// records the profile's packages as installed in the airootfs of the -w
+// directory and writes an empty ISO named after the profile's iso_name
+// into the -o directory.
+func fakeMkarchiso(ctx context.Context, cmd runner.Command) error {
+	var outDir, workDir string
+	for i := 0; i < len(cmd.Args)-1; i++ {
+		switch cmd.Args[i] {
+		case "-o":
+			outDir = cmd.Args[i+1]
+		case "-w":
+			workDir = cmd.Args[i+1]
+		}
+	}
+	profile := cmd.Args[len(cmd.Args)-1]
+	profileDef, err := os.ReadFile(filepath.Join(profile, "profiledef.sh"))
+	if err != nil {
+		return err
+	}
+	m := isoNameRe.FindSubmatch(profileDef)
+	if m == nil {
+		return fmt.Errorf("no iso_name in profiledef.sh")
+	}
+
+	fmt.Fprintln(cmd.Stdout, "[mkarchiso] INFO: Validating options...")
+	fmt.Fprintln(cmd.Stdout, "[mkarchiso] INFO: Installing packages to '/work/x86_64/airootfs/'...")
+	packages, err := os.ReadFile(filepath.Join(profile, "packages.x86_64"))
+	if err != nil {
+		return err
+	}
+	for _, name := range strings.Fields(string(packages)) {
+		entry := filepath.Join(workDir, "x86_64", "airootfs", "var", "lib", "pacman", "local", name+"-1.0-1")
+		if err := os.MkdirAll(entry, 0755); err != nil {
+			return err
+		}
+		desc := fmt.Sprintf("%%NAME%%\n%s\n\n%%VERSION%%\n1.0-1\n\n%%ARCH%%\nx86_64\n", name)
+		if err := os.WriteFile(filepath.Join(entry, "desc"), []byte(desc), 0644); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(cmd.Stdout, "[mkarchiso] INFO: Creating ISO image...")
+	iso := filepath.Join(outDir, fmt.Sprintf("%s-2024.01.01-x86_64.iso", m[1]))
+	if err := os.WriteFile(iso, nil, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.Stdout, "[mkarchiso] INFO: Done!")
+	return nil
+}
+
+// fakePacman answers "pacman -Q" like a host with archiso installed but not libisoburn.
+func fakePacman(ctx context.Context, cmd runner.Command) error {
+	fmt.Fprintln(cmd.Stdout, "archiso 79-1")
+	fmt.Fprintln(cmd.Stdout, "squashfs-tools 4.6.1-1")
+	fmt.Fprintln(cmd.Stderr, "error: package 'libisoburn' was not found")
+	return &runner.ExitError{Code: 1}
+}
+
+// fakeRm removes the paths after "--" like the real rm run as root would.
+func fakeRm(ctx context.Context, cmd runner.Command) error {
+	for i, arg := range cmd.Args {
+		if arg == "--" {
+			for _, path := range cmd.Args[i+1:] {
+				if err := os.RemoveAll(path); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("rm called without --: %v", cmd.Args)
+}
+
+func TestToolVersions(t *testing.T) {
+	p, err := NewArchPluginWithDataDir(t.TempDir(), runner.NewFake(map[string]runner.FakeFunc{"pacman": fakePacman}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := p.toolVersions(context.Background())
+	want := map[string]string{"archiso": "79-1", "squashfs-tools": "4.6.1-1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("toolVersions = %v, want %v", got, want)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	plugintest.Run(t, plugintest.Config{
+		New: func(t *testing.T, dataDir string, r runner.Runner) plugin.DistroPlugin {
+			p, err := NewArchPluginWithDataDir(dataDir, r)
+			if err != nil {
+				t.Fatalf("NewArchPluginWithDataDir failed: %v", err)
+			}
+			// The conformance tests use made-up package names, which the
+			// host's sync databases would reject.
+			p.syncDBDir = filepath.Join(dataDir, "no-sync-dbs")
+			return p
+		},
+		FakeTools: map[string]runner.FakeFunc{
+			"mkarchiso": fakeMkarchiso,
+			"pacman":    fakePacman,
+			"rm":        fakeRm,
+		},
+		Bootloaders: []string{"syslinux+grub", "syslinux", "systemd-boot", "syslinux+systemd-boot+grub-ia32"},
+	})
+}
+
+func TestCancelledBuildUnmountsLeftovers(t *testing.T) {
+	dataDir := t.TempDir()
+	workDir := filepath.Join(dataDir, "work", "archiso", "p1")
+	mountInfo := filepath.Join(t.TempDir(), "mountinfo")
+	table := fmt.Sprintf(`22 1 0:21 / /proc rw,nosuid - proc proc rw
+90 1 0:50 / %[1]s/x86_64/airootfs rw - tmpfs tmpfs rw
+91 90 0:51 / %[1]s/x86_64/airootfs/proc rw - proc proc rw
+92 1 0:52 / %[1]s-other rw - tmpfs tmpfs rw
+`, workDir)
+	if err := os.WriteFile(mountInfo, []byte(table), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var unmounted []string
+	fake := runner.NewFake(map[string]runner.FakeFunc{
+		"mkarchiso": func(ctx context.Context, cmd runner.Command) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+		"umount": func(ctx context.Context, cmd runner.Command) error {
+			unmounted = append(unmounted, cmd.Args[len(cmd.Args)-1])
+			return nil
+		},
+	})
+	p, err := NewArchPluginWithDataDir(dataDir, fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.mountInfo = mountInfo
+	if err := p.CreateProject("p1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := p.BuildISO(ctx, plugin.BuildRequest{ProjectID: "p1", BuildID: "b1", Output: io.Discard}); err == nil {
+		t.Fatal("cancelled build reported success")
+	}
+	want := []string{workDir + "/x86_64/airootfs/proc", workDir + "/x86_64/airootfs"}
+	if !reflect.DeepEqual(unmounted, want) {
+		t.Errorf("unmounted %v, want %v", unmounted, want)
+	}
+}
+
+func TestProgressParser(t *testing.T) {
+	var got []plugin.Progress
+	parser := newProgressParser(func(p plugin.Progress) { got = append(got, p) })
+	log := `[mkarchiso] INFO: Validating options...
+[mkarchiso] INFO: Installing packages to '/work/x86_64/airootfs/'...
+resolving dependencies...
+Packages (2) base-3-2  linux-6.9.1-1
+
+:: Proceed with installation? [Y/n]
+:: Retrieving packages...
+ downloading base-3-2-any.pkg.tar.zst...
+ downloading linux-6.9.1-1-x86_64.pkg.tar.zst...
+(1/2) installing base
+(2/2) installing linux
+[mkarchiso] INFO: Creating SquashFS image, this may take some time...
+[mkarchiso] INFO: Creating ISO image...
+`
+	// Split writes must not confuse the parser.
+	for _, chunk := range []string{log[:50], log[50:]} {
+		parser.Write([]byte(chunk))
+	}
+
+	want := []plugin.Progress{
+		{Stage: "validating profile", Percent: 0},
+		{Stage: "installing packages", Percent: 2},
+		{Stage: "installing packages", Percent: 15},
+		{Stage: "installing packages", Percent: 28},
+		{Stage: "installing packages", Percent: 41},
+		{Stage: "installing packages", Percent: 55},
+		{Stage: "creating SquashFS image", Percent: 65},
+		{Stage: "creating ISO image", Percent: 91},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("progress updates:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	p, err := NewArchPluginWithDataDir(t.TempDir(), runner.NewFake(map[string]runner.FakeFunc{
+		"mkarchiso":  fakeMkarchiso,
+		"pacman":     fakePacman,
+		"mksquashfs": fakeMkarchiso,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.syncDBDir = filepath.Join(t.TempDir(), "sync")
+	if err := p.CreateProject("p1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// checks returns the messages of the findings, leaving out free space
+	// which depends on the machine running the test.
+	checks := func() []string {
+		t.Helper()
+		findings, err := p.Preflight(context.Background(), "p1")
+		if err != nil {
+			t.Fatalf("Preflight failed: %v", err)
+		}
+		var got []string
+		for _, f := range findings {
+			if f.Check != "disk_space" {
+				got = append(got, f.Severity+" "+f.Check+": "+f.Message)
+			}
+		}
+		return got
+	}
+
+	want := []string{
+		"error host_tools: xorriso is not installed",
+		"warning packages: Package names were not checked against the repositories: no sync database for core",
+	}
+	if got := checks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("fresh project: findings = %q, want %q", got, want)
+	}
+
+	if err := p.SetPackages("p1", nil); err != nil {
+		t.Fatal(err)
+	}
+	profileDef := filepath.Join(p.projectProfilePath("p1"), "profiledef.sh")
+	content, err := os.ReadFile(profileDef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content = regexp.MustCompile(`(?m)^install_dir=.*$`).ReplaceAll(content, []byte(`install_dir="Arch Linux"`))
+	content = regexp.MustCompile(`(?m)^pacman_conf=.*\n`).ReplaceAll(content, nil)
+	if err := os.WriteFile(profileDef, content, 0755); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		"error host_tools: xorriso is not installed",
+		"error profiledef: profiledef.sh does not set pacman_conf",
+		`error profiledef: install_dir "Arch Linux" must be 1 to 8 lowercase letters or digits`,
+		"error packages: The package list is empty",
+	}
+	if got := checks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("broken project: findings = %q, want %q", got, want)
+	}
+}
+
+func TestDiagnoseUnmountsLeftovers(t *testing.T) {
+	dataDir := t.TempDir()
+	workRoot := filepath.Join(dataDir, "work", "archiso")
+	mountInfo := filepath.Join(t.TempDir(), "mountinfo")
+	table := fmt.Sprintf(`22 1 0:21 / /proc rw,nosuid - proc proc rw
+90 1 7:0 / %[1]s/p1/x86_64/airootfs rw - ext4 /dev/loop0 rw
+91 1 0:51 / %[1]s/p2/x86_64/airootfs/proc rw - proc proc rw
+`, workRoot)
+	if err := os.WriteFile(mountInfo, []byte(table), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var unmounted []string
+	tools := map[string]runner.FakeFunc{
+		"umount": func(ctx context.Context, cmd runner.Command) error {
+			unmounted = append(unmounted, cmd.Args[len(cmd.Args)-1])
+			return nil
+		},
+	}
+	for _, req := range hostRequirements {
+		tools[req.Command] = fakePacman
+	}
+	p, err := NewArchPluginWithDataDir(dataDir, runner.NewFake(tools))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.mountInfo = mountInfo
+
+	// p2 is being built, so its mounts are in use.
+	opts := plugin.DiagnoseOptions{Building: []string{"p2"}}
+	findings := p.Diagnose(context.Background(), opts)
+	leftover := workRoot + "/p1/x86_64/airootfs"
+	want := []plugin.Finding{{
+		Check:    "mounts",
+		Severity: plugin.SeverityError,
+		Message:  leftover + " is still mounted from an earlier build",
+		Fix:      "sudo umount " + leftover,
+		AutoFix:  true,
+	}}
+	if !reflect.DeepEqual(findings, want) {
+		t.Errorf("Diagnose = %+v, want %+v", findings, want)
+	}
+	if len(unmounted) != 0 {
+		t.Errorf("Diagnose without fix unmounted %v", unmounted)
+	}
+
+	opts.Fix = true
+	findings = p.Diagnose(context.Background(), opts)
+	if len(findings) != 1 || !findings[0].Fixed {
+		t.Errorf("Diagnose with fix = %+v, want the leftover mount fixed", findings)
+	}
+	if !reflect.DeepEqual(unmounted, []string{leftover}) {
+		t.Errorf("unmounted %v, want %v", unmounted, []string{leftover})
+	}
+}
+
+func TestWorkdirReuse(t *testing.T) {
+	p, err := NewArchPluginWithDataDir(t.TempDir(), runner.NewFake(map[string]runner.FakeFunc{"rm": fakeRm}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	workDir := filepath.Join(p.workRoot, "p1")
+	leftover := filepath.Join(workDir, "x86_64", "airootfs", "etc", "hostname")
+	writeLeftover := func() {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(leftover), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(leftover, []byte("archiso\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An unfinished build of the same configuration is resumed.
+	if err := p.prepareWorkdir(context.Background(), workDir, "sha256:a", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	writeLeftover()
+	if err := p.prepareWorkdir(context.Background(), workDir, "sha256:a", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(leftover); err != nil {
+		t.Errorf("resuming a build removed its work directory: %v", err)
+	}
+
+	// A changed configuration starts from scratch.
+	if err := p.prepareWorkdir(context.Background(), workDir, "sha256:b", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+		t.Errorf("work directory of another configuration was reused: %v", err)
+	}
+
+	// So does the next build after a completed one.
+	writeLeftover()
+	if err := finishWorkdir(workDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.prepareWorkdir(context.Background(), workDir, "sha256:b", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+		t.Errorf("work directory of a completed build was reused: %v", err)
+	}
+
+	writeLeftover()
+	reclaimed, err := p.CleanWorkdir(context.Background(), "p1")
+	if err != nil || reclaimed <= 0 {
+		t.Errorf("CleanWorkdir = %d, %v, want the freed space", reclaimed, err)
+	}
+	if _, err := os.Stat(workDir); !os.IsNotExist(err) {
+		t.Errorf("CleanWorkdir left the work directory: %v", err)
+	}
+	if reclaimed, err := p.CleanWorkdir(context.Background(), "p1"); reclaimed != 0 || err != nil {
+		t.Errorf("CleanWorkdir without a work directory = %d, %v", reclaimed, err)
+	}
+}
+
+func TestCleanCache(t *testing.T) {
+	p, err := NewArchPluginWithDataDir(t.TempDir(), runner.NewFake(map[string]runner.FakeFunc{"rm": fakeRm}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []string{
+		"linux-6.9.1.arch1-1-x86_64.pkg.tar.zst",
+		"linux-6.9.1.arch1-1-x86_64.pkg.tar.zst.sig",
+		"linux-6.9.2.arch1-1-x86_64.pkg.tar.zst",
+		"linux-firmware-20240510.b9d2bf23-1-any.pkg.tar.zst",
+		"download-abc123", // a partial download pacman left behind
+	}
+	base := time.Now().Add(-time.Hour)
+	for i, name := range files {
+		path := filepath.Join(p.cacheDir, name)
+		if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, base, base.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reclaimed, err := p.CleanCache(context.Background())
+	if err != nil || reclaimed != 200 {
+		t.Errorf("CleanCache = %d, %v, want 200 bytes", reclaimed, err)
+	}
+	entries, err := os.ReadDir(p.cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, entry := range entries {
+		left = append(left, entry.Name())
+	}
+	want := []string{files[4], files[2], files[3]}
+	if !reflect.DeepEqual(left, want) {
+		t.Errorf("cache holds %q after CleanCache, want %q", left, want)
+	}
+}
+
+func TestBuildPacmanConf(t *testing.T) {
+	p, err := NewArchPluginWithDataDir(t.TempDir(), runner.NewFake(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, workDir := t.TempDir(), t.TempDir()
+	cacheLine := "CacheDir = " + p.cacheDir + "/\n"
+	tests := []struct{ in, want string }{
+		{"[options]\nArchitecture = auto\n\n[core]\nInclude = /etc/pacman.d/mirrorlist\n",
+			"[options]\n" + cacheLine + "Architecture = auto\n\n[core]\nInclude = /etc/pacman.d/mirrorlist\n"},
+		{"[options]\nCacheDir = /srv/cache/\n", "[options]\nCacheDir = /srv/cache/\n"},
+		{"[core]\nInclude = /etc/pacman.d/mirrorlist\n",
+			"[options]\n" + cacheLine + "\n[core]\nInclude = /etc/pacman.d/mirrorlist\n"},
+	}
+	for _, tt := range tests {
+		if err := os.WriteFile(filepath.Join(profile, "pacman.conf"), []byte(tt.in), 0644); err != nil {
+			t.Fatal(err)
+		}
+		path, err := p.writeBuildPacmanConf(profile, workDir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := os.ReadFile(path); string(got) != tt.want {
+			t.Errorf("pacman.conf for\n%s\n= %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	// A snapshot replaces the servers of the official repositories and of
+	// those using the mirrorlist, but not of custom ones.
+	in := "[options]\nCacheDir = /srv/cache/\n\n[core]\nInclude = /etc/pacman.d/mirrorlist\n\n[extra]\nServer = https://mirror.example/$repo/os/$arch\nSigLevel = Required\n\n" +
+		"[myrepo]\nServer = file:///srv/myrepo/$arch\n\n[mirrored]\nInclude = /etc/pacman.d/mirrorlist\n"
+	want := "[options]\nCacheDir = /srv/cache/\n\n[core]\nServer = https://archive.archlinux.org/repos/2024/06/01/$repo/os/$arch\n\n[extra]\nServer = https://archive.archlinux.org/repos/2024/06/01/$repo/os/$arch\nSigLevel = Required\n\n" +
+		"[myrepo]\nServer = file:///srv/myrepo/$arch\n\n[mirrored]\nServer = https://archive.archlinux.org/repos/2024/06/01/$repo/os/$arch\n"
+	if err := os.WriteFile(filepath.Join(profile, "pacman.conf"), []byte(in), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path, err := p.writeBuildPacmanConf(profile, workDir, &plugin.Reproducible{SourceDateEpoch: 1717200000, Snapshot: "2024-06-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != want {
+		t.Errorf("pacman.conf with a snapshot = %q, want %q", got, want)
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	workDir := t.TempDir()
+	local := filepath.Join(workDir, "x86_64", "airootfs", "var", "lib", "pacman", "local")
+	descs := map[string]string{
+		"bash-5.2.026-2": "%NAME%\nbash\n\n%VERSION%\n5.2.026-2\n\n%DESC%\nThe GNU Bourne Again shell\n\n%URL%\nhttps://www.gnu.org/software/bash/bash.html\n\n%ARCH%\nx86_64\n\n%LICENSE%\nGPL-3.0-or-later\n\n%DEPENDS%\nglibc\nreadline>=7.0\n\n%PROVIDES%\nsh\n",
+		"glibc-2.39-1":   "%NAME%\nglibc\n\n%VERSION%\n2.39-1\n\n%ARCH%\nx86_64\n\n%LICENSE%\nGPL-2.0-or-later\nLGPL-2.1-or-later\n\n%DEPENDS%\nlinux-api-headers>=4.10\n\n%PROVIDES%\nlibc.so=6-64\n",
+		"which-2.21-6":   "%NAME%\nwhich\n\n%VERSION%\n2.21-6\n\n%ARCH%\nx86_64\n\n%DEPENDS%\nsh\nlibc.so=6-64\n",
+	}
+	for dir, desc := range descs {
+		if err := os.MkdirAll(filepath.Join(local, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(local, dir, "desc"), []byte(desc), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(local, "ALPM_DB_VERSION"), []byte("9\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := readManifest(workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []plugin.ManifestPackage{
+		{Name: "bash", Version: "5.2.026-2", Arch: "x86_64", Description: "The GNU Bourne Again shell", URL: "https://www.gnu.org/software/bash/bash.html", Licenses: []string{"GPL-3.0-or-later"}, Depends: []string{"glibc"}, PURL: "pkg:alpm/arch/bash@5.2.026-2?arch=x86_64"},
+		{Name: "glibc", Version: "2.39-1", Arch: "x86_64", Licenses: []string{"GPL-2.0-or-later", "LGPL-2.1-or-later"}, PURL: "pkg:alpm/arch/glibc@2.39-1?arch=x86_64"},
+		{Name: "which", Version: "2.21-6", Arch: "x86_64", Depends: []string{"bash", "glibc"}, PURL: "pkg:alpm/arch/which@2.21-6?arch=x86_64"},
+	}
+	if !reflect.DeepEqual(manifest.Packages, want) {
+		t.Errorf("manifest = %+v, want %+v", manifest.Packages, want)
+	}
+
+	sbom, err := plugin.CycloneDX(manifest, "p1", "p1-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bom struct {
+		Components   []struct{ Name, PURL string }
+		Dependencies []struct {
+			Ref       string
+			DependsOn []string
+		}
+	}
+	if err := json.Unmarshal(sbom, &bom); err != nil {
+		t.Fatalf("SBOM is no valid JSON: %v", err)
+	}
+	if len(bom.Components) != 3 || len(bom.Dependencies) != 4 {
+		t.Errorf("SBOM has %d components and %d dependency entries, want 3 and 4", len(bom.Components), len(bom.Dependencies))
+	}
+	if dep := bom.Dependencies[3]; dep.Ref != want[2].PURL || !reflect.DeepEqual(dep.DependsOn, []string{want[0].PURL, want[1].PURL}) {
+		t.Errorf("SBOM dependencies of which = %+v", dep)
+	}
+
+	if _, err := readManifest(t.TempDir()); err == nil {
+		t.Error("readManifest succeeded without a package database")
+	}
+}
+
+func TestReadInputs(t *testing.T) {
+	p, err := NewArchPluginWithDataDir(t.TempDir(), runner.NewFake(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CreateProject("p1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetHostname("p1", "forge"); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs, err := readInputs(p.projectProfilePath("p1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := map[string]string{
+		"profiledef.iso_name":         `"archlinux-p1"`,
+		"profiledef.bootmodes":        `('bios.syslinux.mbr' 'bios.syslinux.eltorito' 'uefi-x64.grub.esp' 'uefi-x64.grub.eltorito')`,
+		"profiledef.file_permissions": `(["/etc/shadow"]="0:0:400" ["/root"]="0:0:750")`,
+	}
+	for name, want := range settings {
+		if got := inputs.Settings[name]; got != want {
+			t.Errorf("setting %s = %s, want %s", name, got, want)
+		}
+	}
+	if !strings.HasPrefix(inputs.Settings["pacman.conf"], "sha256:") {
+		t.Errorf("pacman.conf setting = %q, want its hash", inputs.Settings["pacman.conf"])
+	}
+	hostname := sha256.Sum256([]byte("forge\n"))
+	if got, want := inputs.Files["airootfs/etc/hostname"], fmt.Sprintf("-rw-r--r-- sha256:%x", hostname); got != want {
+		t.Errorf("airootfs/etc/hostname = %q, want %q", got, want)
+	}
+}
+
+func TestParseProfileDef(t *testing.T) {
+	content := `#!/usr/bin/env bash
+# shellcheck disable=SC2034
+
+iso_name="archlinux"  # the name
+iso_version="$(date --date="@${SOURCE_DATE_EPOCH:-$(date +%s)}" +%Y.%m.%d)"
+install_dir=arch
+bootmodes=('bios.syslinux.mbr'
+           # UEFI
+           'uefi-x64.grub.esp')
+file_permissions=(
+  ["/etc/shadow"]="0:0:400"
+  ["/root"]="0:0:750"
+)
+if [[ -n "$EXTRA" ]]; then
+  iso_label="EXTRA"
+fi
+airootfs_image_type="squashfs"`
+
+	def := parseProfileDef([]byte(content))
+	if got := string(def.bytes()); got != content {
+		t.Fatalf("unmodified file changed:\n%s", got)
+	}
+	vars := def.variables()
+	if v := vars["bootmodes"].value; !reflect.DeepEqual(v, []string{"bios.syslinux.mbr", "uefi-x64.grub.esp"}) {
+		t.Errorf("bootmodes = %#v", v)
+	}
+	if v := vars["file_permissions"].value.(profileAssoc).toMap(); !reflect.DeepEqual(v, map[string]string{"/etc/shadow": "0:0:400", "/root": "0:0:750"}) {
+		t.Errorf("file_permissions = %#v", v)
+	}
+	if a := vars["iso_version"]; !a.expr || a.value != `$(date --date="@${SOURCE_DATE_EPOCH:-$(date +%s)}" +%Y.%m.%d)` {
+		t.Errorf("iso_version = %q (expression %v)", a.value, a.expr)
+	}
+	if dir, ok := def.literal("install_dir"); !ok || dir != "arch" {
+		t.Errorf("install_dir = %q, %v", dir, ok)
+	}
+	if label, ok := def.literal("iso_label"); !ok || label != "EXTRA" {
+		t.Errorf("iso_label = %q, %v", label, ok)
+	}
+
+	def.set("iso_version", vars["iso_version"].value)
+	def.set("iso_name", `my "iso"`)
+	def.set("bootmodes", []string{"uefi-x64.systemd-boot.esp"})
+	def.set("file_permissions", map[string]string{"/root": "0:0:700", "/etc/gshadow": "0:0:400"})
+	def.set("install_dir", nil)
+	def.set("iso_publisher", "Forge <https://example.com>")
+	want := `#!/usr/bin/env bash
+# shellcheck disable=SC2034
+
+iso_name="my \"iso\""  # the name
+iso_version="$(date --date="@${SOURCE_DATE_EPOCH:-$(date +%s)}" +%Y.%m.%d)"
+bootmodes=('uefi-x64.systemd-boot.esp')
+file_permissions=(
+  ["/root"]="0:0:700"
+  ["/etc/gshadow"]="0:0:400"
+)
+if [[ -n "$EXTRA" ]]; then
+  iso_label="EXTRA"
+fi
+airootfs_image_type="squashfs"
+iso_publisher="Forge <https://example.com>"
+`
+	if got := string(def.bytes()); got != want {
+		t.Errorf("edited file:\n%s\nwant:\n%s", got, want)
+	}
+	reparsed := parseProfileDef(def.bytes())
+	if name, _ := reparsed.literal("iso_name"); name != `my "iso"` {
+		t.Errorf("iso_name reads back as %q", name)
+	}
+}
+
+func TestProfileDefEditor(t *testing.T) {
+	p, err := NewArchPluginWithDataDir(t.TempDir(), runner.NewFake(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CreateProject("p1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	def, err := p.GetProfileDef("p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def.Variables["iso_name"] != "archlinux-p1" || !reflect.DeepEqual(def.Expressions, []string{"iso_version"}) {
+		t.Errorf("GetProfileDef = %+v", def)
+	}
+
+	def, err = p.SetProfileDef("p1", map[string]interface{}{
+		"iso_publisher":       "Forge",
+		"airootfs_image_type": "erofs",
+		"bootmodes":           []interface{}{"uefi-x64.grub.esp"},
+		"file_permissions":    map[string]interface{}{"/root": "0:0:700"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def.Variables["iso_publisher"] != "Forge" || !reflect.DeepEqual(def.Variables["bootmodes"], []string{"uefi-x64.grub.esp"}) ||
+		!reflect.DeepEqual(def.Variables["file_permissions"], map[string]string{"/root": "0:0:700"}) {
+		t.Errorf("SetProfileDef = %+v", def)
+	}
+	content, err := os.ReadFile(filepath.Join(p.projectProfilePath("p1"), "profiledef.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), `airootfs_image_type="erofs"`) || !strings.HasPrefix(string(content), "#!/usr/bin/env bash\n") {
+		t.Errorf("profiledef.sh:\n%s", content)
+	}
+
+	for _, variables := range []map[string]interface{}{
+		{"bad-name": "x"},
+		{"iso_name": 3.0},
+		{"bootmodes": []interface{}{"a", 1.0}},
+	} {
+		_, err := p.SetProfileDef("p1", variables)
+		var perr *plugin.Error
+		if !errors.As(err, &perr) || perr.Code != plugin.ErrInvalidParams {
+			t.Errorf("SetProfileDef(%v) error = %v, want invalid params", variables, err)
+		}
+	}
+}
+
+func TestSetBootloader(t *testing.T) {
+	p, err := NewArchPluginWithDataDir(t.TempDir(), runner.NewFake(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CreateProject("p1", nil); err != nil {
+		t.Fatal(err)
+	}
+	profilePath := p.projectProfilePath("p1")
+	if got, _ := p.GetBootloader("p1"); got.Bootloader != defaultBootloader {
+		t.Errorf("new project boots with %q, want %q", got.Bootloader, defaultBootloader)
+	}
+	for _, dir := range []string{"syslinux/syslinux.cfg", "grub/grub.cfg"} {
+		if _, err := os.Stat(filepath.Join(profilePath, dir)); err != nil {
+			t.Errorf("new project lacks %s: %v", dir, err)
+		}
+	}
+
+	if err := p.SetBootloader("p1", "systemd-boot-ia32+syslinux+systemd-boot"); err != nil {
+		t.Fatal(err)
+	}
+	def, err := p.GetProfileDef("p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"bios.syslinux.mbr", "bios.syslinux.eltorito", "uefi-x64.systemd-boot.esp", "uefi-x64.systemd-boot.eltorito", "uefi-ia32.systemd-boot.esp", "uefi-ia32.systemd-boot.eltorito"}
+	if !reflect.DeepEqual(def.Variables["bootmodes"], want) {
+		t.Errorf("bootmodes = %v, want %v", def.Variables["bootmodes"], want)
+	}
+	if got, _ := p.GetBootloader("p1"); got.Bootloader != "syslinux+systemd-boot+systemd-boot-ia32" {
+		t.Errorf("GetBootloader = %q", got.Bootloader)
+	}
+	if _, err := os.Stat(filepath.Join(profilePath, "efiboot", "loader", "entries", "01-arch.conf")); err != nil {
+		t.Errorf("systemd-boot entry missing: %v", err)
+	}
+	packages, _ := p.GetPackages("p1")
+	if !reflect.DeepEqual(packages.Packages, []string{"base", "linux", "xf86-video-vesa", "syslinux", "grub"}) {
+		t.Errorf("packages = %v", packages.Packages)
+	}
+
+	for _, bootloader := range []string{"", "lilo", "grub+systemd-boot", "syslinux+syslinux"} {
+		err := p.SetBootloader("p1", bootloader)
+		var perr *plugin.Error
+		if !errors.As(err, &perr) || perr.Code != plugin.ErrInvalidBootloader {
+			t.Errorf("SetBootloader(%q) error = %v, want invalid bootloader", bootloader, err)
+		}
+	}
+
+	if _, err := p.SetProfileDef("p1", map[string]interface{}{"bootmodes": []interface{}{"bios.syslinux.mbr"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := p.GetBootloader("p1"); got.Bootloader != customBootloader {
+		t.Errorf("GetBootloader with hand-picked bootmodes = %q, want %q", got.Bootloader, customBootloader)
+	}
+
+	// A bootloader whose packages the repositories don't have changes nothing.
+	p.syncDBDir = t.TempDir()
+	writeSyncDB(t, filepath.Join(p.syncDBDir, "core.db"), true, map[string]string{
+		"base-3-2":        "%NAME%\nbase\n",
+		"syslinux-6.04-1": "%NAME%\nsyslinux\n",
+	})
+	writeSyncDB(t, filepath.Join(p.syncDBDir, "extra.db"), true, nil)
+	if err := p.SetBootloader("p1", "syslinux"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writePackages(profilePath, []string{"base", "linux", "syslinux"}); err != nil {
+		t.Fatal(err)
+	}
+	err = p.SetBootloader("p1", "syslinux+grub")
+	var perr *plugin.Error
+	if !errors.As(err, &perr) || perr.Code != plugin.ErrInvalidPackage {
+		t.Errorf("SetBootloader without grub in the repositories: %v, want invalid package", err)
+	}
+	if got, _ := p.GetBootloader("p1"); got.Bootloader != "syslinux" {
+		t.Errorf("rejected bootloader changed the bootmodes to %q", got.Bootloader)
+	}
+	if packages, _ := p.GetPackages("p1"); containsString(packages.Packages, "grub") {
+		t.Errorf("rejected bootloader added its packages: %v", packages.Packages)
+	}
+}
+
+func TestBootMenu(t *testing.T) {
+	p, err := NewArchPluginWithDataDir(t.TempDir(), runner.NewFake(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CreateProject("p1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetBootloader("p1", "syslinux+systemd-boot"); err != nil {
+		t.Fatal(err)
+	}
+	if menu, err := p.GetBootMenu("p1"); err != nil || !reflect.DeepEqual(menu, defaultBootMenu()) {
+		t.Errorf("GetBootMenu = %+v, %v, want the default menu", menu, err)
+	}
+	profilePath := p.projectProfilePath("p1")
+
+	// Without a preset to model on, other kernels can't be booted.
+	lts := plugin.BootMenu{Entries: []plugin.BootEntry{{ID: "lts", Title: "LTS", Kernel: "linux-lts"}}}
+	_, err = p.SetBootMenu("p1", lts)
+	var perr *plugin.Error
+	if !errors.As(err, &perr) || perr.Code != plugin.ErrInvalidParams {
+		t.Errorf("SetBootMenu without linux.preset: %v, want invalid params", err)
+	}
+	if _, err := os.Stat(filepath.Join(profilePath, bootMenuFile)); !os.IsNotExist(err) {
+		t.Errorf("rejected boot menu was written: %v", err)
+	}
+
+	linuxPreset := "# mkinitcpio preset file for the 'linux' package on archiso\n\nPRESETS=('archiso')\n\nALL_kver='/boot/vmlinuz-linux'\narchiso_config='/etc/mkinitcpio.conf.d/archiso.conf'\n\narchiso_image=\"/boot/initramfs-linux.img\"\n"
+	if err := os.MkdirAll(filepath.Join(profilePath, presetDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(profilePath, presetDir, "linux.preset"), []byte(linuxPreset), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	menu, err := p.SetBootMenu("p1", plugin.BootMenu{
+		Timeout:      5,
+		Default:      "safe",
+		KernelParams: []string{"copytoram"},
+		Entries: []plugin.BootEntry{
+			{ID: "arch", Title: "Arch Linux"},
+			{ID: "safe", Title: "Arch Linux (safe graphics)", KernelParams: []string{"nomodeset"}},
+			{ID: "serial", Title: "Arch Linux's serial console", Kernel: "linux-lts", KernelParams: []string{"console=ttyS0,115200"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if menu.Entries[0].Kernel != "linux" || menu.Entries[0].KernelParams == nil {
+		t.Errorf("defaults not filled in: %+v", menu.Entries[0])
+	}
+	if got, _ := p.GetBootMenu("p1"); !reflect.DeepEqual(got, menu) {
+		t.Errorf("GetBootMenu = %+v, want %+v", got, menu)
+	}
+
+	read := func(path string) string {
+		content, err := os.ReadFile(filepath.Join(profilePath, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+	for path, wants := range map[string][]string{
+		"syslinux/syslinux.cfg": {
+			"TIMEOUT 50\nDEFAULT safe\n",
+			"LABEL serial\nMENU LABEL Arch Linux's serial console\nLINUX /%INSTALL_DIR%/boot/%ARCH%/vmlinuz-linux-lts\n",
+			"APPEND archisobasedir=%INSTALL_DIR% archisosearchuuid=%ARCHISO_UUID% copytoram nomodeset\n",
+		},
+		// The grub directory is left from the default bootloader.
+		"grub/grub.cfg": {
+			"set default='safe'\nset timeout=5\n",
+			`menuentry 'Arch Linux'\''s serial console' --class arch`,
+		},
+		"efiboot/loader/loader.conf": {"timeout 5\ndefault 02-safe.conf\n"},
+		"efiboot/loader/entries/03-serial.conf": {
+			"initrd   /%INSTALL_DIR%/boot/%ARCH%/initramfs-linux-lts.img\n",
+			"options  archisobasedir=%INSTALL_DIR% archisosearchuuid=%ARCHISO_UUID% copytoram console=ttyS0,115200\n",
+		},
+	} {
+		content := read(path)
+		for _, want := range wants {
+			if !strings.Contains(content, want) {
+				t.Errorf("%s lacks %q:\n%s", path, want, content)
+			}
+		}
+	}
+	packages, _ := p.GetPackages("p1")
+	if !containsString(packages.Packages, "linux-lts") {
+		t.Errorf("packages = %v, want linux-lts added", packages.Packages)
+	}
+	if got, want := read(presetDir+"/linux-lts.preset"), strings.NewReplacer("'linux'", "'linux-lts'", "vmlinuz-linux", "vmlinuz-linux-lts", "initramfs-linux", "initramfs-linux-lts").Replace(linuxPreset); got != want {
+		t.Errorf("linux-lts.preset = %q, want %q", got, want)
+	}
+	if got := read(presetDir + "/linux.preset"); got != linuxPreset {
+		t.Errorf("linux.preset changed to %q", got)
+	}
+
+	// Entries that are gone lose their loader entry files.
+	if _, err := p.SetBootMenu("p1", plugin.BootMenu{Timeout: -1, Entries: []plugin.BootEntry{{ID: "only", Title: "Only"}}}); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(profilePath, "efiboot", "loader", "entries"))
+	if len(entries) != 1 || entries[0].Name() != "01-only.conf" {
+		t.Errorf("loader entries = %v", entries)
+	}
+	if content := read("efiboot/loader/loader.conf"); content != "timeout menu-force\ndefault 01-only.conf\n" {
+		t.Errorf("loader.conf = %q", content)
+	}
+	if content := read("syslinux/syslinux.cfg"); !strings.Contains(content, "TIMEOUT 0\n") {
+		t.Errorf("syslinux.cfg doesn't wait:\n%s", content)
+	}
+
+	for _, menu := range []plugin.BootMenu{
+		{},
+		{Timeout: -2, Entries: []plugin.BootEntry{{ID: "a", Title: "A"}}},
+		{Entries: []plugin.BootEntry{{ID: "A", Title: "A"}}},
+		{Entries: []plugin.BootEntry{{ID: "a", Title: "A"}, {ID: "a", Title: "B"}}},
+		{Entries: []plugin.BootEntry{{ID: "a", Title: "A\nB"}}},
+		{Entries: []plugin.BootEntry{{ID: "a", Title: "A", Kernel: "vmlinuz"}}},
+		{Entries: []plugin.BootEntry{{ID: "a", Title: "A", KernelParams: []string{"a b"}}}},
+		{Default: "b", Entries: []plugin.BootEntry{{ID: "a", Title: "A"}}},
+	} {
+		_, err := p.SetBootMenu("p1", menu)
+		var perr *plugin.Error
+		if !errors.As(err, &perr) || perr.Code != plugin.ErrInvalidParams {
+			t.Errorf("SetBootMenu(%+v) error = %v, want invalid params", menu, err)
+		}
+	}
+
+	// A kernel the repositories don't have changes nothing.
+	p.syncDBDir = t.TempDir()
+	writeSyncDB(t, filepath.Join(p.syncDBDir, "core.db"), true, map[string]string{"linux-6.9.1-1": "%NAME%\nlinux\n"})
+	writeSyncDB(t, filepath.Join(p.syncDBDir, "extra.db"), true, nil)
+	menuBefore, syslinuxBefore := read(bootMenuFile), read("syslinux/syslinux.cfg")
+	_, err = p.SetBootMenu("p1", plugin.BootMenu{Entries: []plugin.BootEntry{{ID: "zen", Title: "Zen", Kernel: "linux-zen"}}})
+	if !errors.As(err, &perr) || perr.Code != plugin.ErrInvalidPackage {
+		t.Errorf("SetBootMenu with an unknown kernel: %v, want invalid package", err)
+	}
+	if read(bootMenuFile) != menuBefore || read("syslinux/syslinux.cfg") != syslinuxBefore {
+		t.Errorf("rejected boot menu was written")
+	}
+	if packages, _ := p.GetPackages("p1"); containsString(packages.Packages, "linux-zen") {
+		t.Errorf("rejected boot menu added its kernel: %v", packages.Packages)
+	}
+	if _, err := os.Stat(filepath.Join(profilePath, presetDir, "linux-zen.preset")); !os.IsNotExist(err) {
+		t.Errorf("rejected boot menu wrote a preset: %v", err)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	dataDir := t.TempDir()
+	p, err := NewArchPluginWithDataDir(dataDir, runner.NewFake(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.archisoConfigs = t.TempDir()
+	writeProfile := func(dir string, files map[string]string) {
+		t.Helper()
+		for name, content := range files {
+			path := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	releng := filepath.Join(p.archisoConfigs, "releng")
+	writeProfile(releng, map[string]string{
+		"profiledef.sh":              "#!/usr/bin/env bash\niso_name=\"archlinux\"\niso_label=\"ARCH_$(date +%Y%m)\"\nbootmodes=('bios.syslinux' 'uefi.systemd-boot')\n",
+		"packages.x86_64":            "base\nlinux\nmkinitcpio-archiso\n",
+		"airootfs/etc/hostname":      "archiso\n",
+		"syslinux/syslinux.cfg":      "DEFAULT arch\n",
+		"efiboot/loader/loader.conf": "timeout 15\n",
+	})
+	if err := os.Symlink("/usr/lib/systemd/system/sshd.service", filepath.Join(releng, "airootfs", "sshd.service")); err != nil {
+		t.Fatal(err)
+	}
+	writeProfile(filepath.Join(p.archisoConfigs, "baseline"), map[string]string{"profiledef.sh": "iso_name=\"baseline\"\n"})
+	writeProfile(filepath.Join(p.archisoConfigs, "notaprofile"), map[string]string{"README": "nothing here\n"})
+	writeProfile(filepath.Join(dataDir, "templates", "kiosk"), map[string]string{
+		"profiledef.sh":   "iso_name=\"kiosk\"\nbootmodes=('bios.syslinux.mbr' 'bios.syslinux.eltorito')\n",
+		"packages.x86_64": "base\nlinux\n",
+		"mirrorlist":      "Server = https://kiosk.example/$repo/os/$arch\n",
+		"template.json":   `{"description": "Boots into a browser"}`,
+	})
+	writeProfile(filepath.Join(dataDir, "templates", "releng"), map[string]string{"profiledef.sh": "iso_name=\"mine\"\n"})
+	// profiledef.sh is there, but can't be read as a file.
+	writeProfile(filepath.Join(dataDir, "templates", "broken"), map[string]string{"profiledef.sh/README": "oops\n"})
+
+	templates, err := p.ListTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, tmpl := range templates {
+		got = append(got, tmpl.Source+":"+tmpl.Name+":"+tmpl.Description)
+	}
+	want := []string{
+		"builtin:default:" + templates[0].Description,
+		"archiso:baseline:A minimal live system that boots to a shell",
+		"archiso:releng:The profile of the official Arch Linux installation image",
+		"user:broken:",
+		"user:kiosk:Boots into a browser",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListTemplates = %q, want %q", got, want)
+	}
+
+	if err := p.CreateProject("p1", map[string]interface{}{"template": "releng"}); err != nil {
+		t.Fatal(err)
+	}
+	profilePath := p.projectProfilePath("p1")
+	def, err := p.GetProfileDef("p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def.Variables["iso_name"] != "archlinux-p1" || def.Variables["iso_label"] != "ARCH_P1" {
+		t.Errorf("profiledef.sh names the image %q, %q", def.Variables["iso_name"], def.Variables["iso_label"])
+	}
+	if packages, _ := p.GetPackages("p1"); !reflect.DeepEqual(packages.Packages, []string{"base", "linux", "mkinitcpio-archiso"}) {
+		t.Errorf("packages = %v", packages.Packages)
+	}
+	if target, err := os.Readlink(filepath.Join(profilePath, "airootfs", "sshd.service")); err != nil || target != "/usr/lib/systemd/system/sshd.service" {
+		t.Errorf("symlink not copied: %q, %v", target, err)
+	}
+	if bootloader, _ := p.GetBootloader("p1"); bootloader.Bootloader != customBootloader {
+		t.Errorf("bootloader = %q, want the template's bootmodes left alone", bootloader.Bootloader)
+	}
+	if mirrors, _ := p.getMirrors("p1"); !reflect.DeepEqual(mirrors.Servers, []string{defaultMirror}) {
+		t.Errorf("mirrors = %v, want the default mirror", mirrors.Servers)
+	}
+
+	if err := p.CreateProject("p2", map[string]interface{}{"template": "kiosk"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(p.projectProfilePath("p2"), templateInfo)); !os.IsNotExist(err) {
+		t.Errorf("%s was copied into the project", templateInfo)
+	}
+	if packages, _ := p.GetPackages("p2"); !reflect.DeepEqual(packages.Packages, []string{"base", "linux", "syslinux"}) {
+		t.Errorf("packages = %v, want the bootloader's added", packages.Packages)
+	}
+	if _, err := os.Stat(filepath.Join(p.projectProfilePath("p2"), "syslinux")); err != nil {
+		t.Errorf("no boot configuration for the template's bootloader: %v", err)
+	}
+	if mirrors, _ := p.getMirrors("p2"); !reflect.DeepEqual(mirrors.Servers, []string{"https://kiosk.example/$repo/os/$arch"}) {
+		t.Errorf("mirrors = %v, want the template's", mirrors.Servers)
+	}
+
+	if err := p.CreateProject("p5", map[string]interface{}{"template": "broken"}); err == nil {
+		t.Errorf("CreateProject from a broken template succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, "projects", "p5")); !os.IsNotExist(err) {
+		t.Errorf("the broken project's directory was left behind: %v", err)
+	}
+
+	err = p.CreateProject("p3", map[string]interface{}{"template": "notaprofile"})
+	var perr *plugin.Error
+	if !errors.As(err, &perr) || perr.Code != plugin.ErrInvalidParams {
+		t.Errorf("CreateProject from a missing template: %v, want invalid params", err)
+	}
+
+	// An existing project is neither overwritten nor removed.
+	for _, template := range []string{"", "notaprofile"} {
+		if err := p.CreateProject("p1", map[string]interface{}{"template": template}); err == nil {
+			t.Errorf("CreateProject over an existing project from template %q succeeded", template)
+		}
+		if def, err := p.GetProfileDef("p1"); err != nil || def.Variables["iso_name"] != "archlinux-p1" {
+			t.Errorf("existing project changed by CreateProject from template %q: %v", template, err)
+		}
+	}
+
+	if err := p.CreateProject("p4", map[string]interface{}{"template": "default"}); err != nil {
+		t.Fatal(err)
+	}
+	pacmanConf, err := os.ReadFile(filepath.Join(p.projectProfilePath("p4"), "pacman.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(pacmanConf), "[community]") {
+		t.Errorf("pacman.conf still has the [community] repository:\n%s", pacmanConf)
+	}
+}
+
+func TestSaveAsTemplate(t *testing.T) {
+	p, err := NewArchPluginWithDataDir(t.TempDir(), runner.NewFake(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.archisoConfigs = t.TempDir()
+	if err := p.CreateProject("p1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetHostname("p1", "lobby"); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := p.SaveAsTemplate("p1", "kiosk", "Boots into a browser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Version != 1 || !reflect.DeepEqual(saved.Versions, []int{1}) || saved.Source != "user" {
+		t.Errorf("SaveAsTemplate = %+v, want version 1 of a user template", saved)
+	}
+	dir := filepath.Join(p.templatesRoot, "kiosk", "v1")
+	profiledef, err := os.ReadFile(filepath.Join(dir, "profiledef.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`iso_name="archlinux-{{project_id}}"`, `iso_label="ARCH_{{PROJECT_ID}}"`} {
+		if !strings.Contains(string(profiledef), want) {
+			t.Errorf("profiledef.sh doesn't have %s:\n%s", want, profiledef)
+		}
+	}
+	if hostname, _ := os.ReadFile(filepath.Join(dir, "airootfs", "etc", "hostname")); string(hostname) != "{{hostname}}\n" {
+		t.Errorf("airootfs/etc/hostname = %q, want the placeholder", hostname)
+	}
+	if meta := readTemplateMeta(dir); meta.Description != "Boots into a browser" || meta.Version != 1 || meta.ProjectID != "p1" || meta.SavedAt == nil {
+		t.Errorf("%s = %+v", templateInfo, meta)
+	}
+
+	if err := p.SetPackages("p1", []string{"base", "linux", "chromium"}); err != nil {
+		t.Fatal(err)
+	}
+	saved, err = p.SaveAsTemplate("p1", "kiosk", "Boots into chromium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Version != 2 || !reflect.DeepEqual(saved.Versions, []int{1, 2}) {
+		t.Errorf("SaveAsTemplate again = %+v, want version 2", saved)
+	}
+	templates, err := p.ListTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := templates[len(templates)-1]; last.Name != "kiosk" || last.Version != 2 || last.Description != "Boots into chromium" {
+		t.Errorf("ListTemplates lists %+v", last)
+	}
+
+	if err := p.CreateProject("p2", map[string]interface{}{"template": "kiosk"}); err != nil {
+		t.Fatal(err)
+	}
+	def, err := p.GetProfileDef("p2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def.Variables["iso_name"] != "archlinux-p2" || def.Variables["iso_label"] != "ARCH_P2" {
+		t.Errorf("profiledef.sh names the image %q, %q", def.Variables["iso_name"], def.Variables["iso_label"])
+	}
+	if hostname, _ := p.GetHostname("p2"); hostname.Hostname != "p2" {
+		t.Errorf("hostname = %q, want p2", hostname.Hostname)
+	}
+	if packages, _ := p.GetPackages("p2"); !containsString(packages.Packages, "chromium") {
+		t.Errorf("packages of the latest version = %v", packages.Packages)
+	}
+	if _, err := os.Stat(filepath.Join(p.projectProfilePath("p2"), templateInfo)); !os.IsNotExist(err) {
+		t.Errorf("%s was copied into the project", templateInfo)
+	}
+
+	if err := p.CreateProject("p3", map[string]interface{}{"template": "kiosk", "template_version": float64(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if packages, _ := p.GetPackages("p3"); containsString(packages.Packages, "chromium") {
+		t.Errorf("packages of version 1 = %v", packages.Packages)
+	}
+
+	err = p.CreateProject("p4", map[string]interface{}{"template": "kiosk", "template_version": float64(3)})
+	var perr *plugin.Error
+	if !errors.As(err, &perr) || perr.Code != plugin.ErrInvalidParams {
+		t.Errorf("CreateProject from a missing version: %v, want invalid params", err)
+	}
+	for _, name := range []string{"default", "Kiosk", "../kiosk", ""} {
+		if _, err := p.SaveAsTemplate("p1", name, ""); !errors.As(err, &perr) || perr.Code != plugin.ErrInvalidParams {
+			t.Errorf("SaveAsTemplate(%q): %v, want invalid params", name, err)
+		}
+	}
+
+	// Only the image names CreateProject wrote become placeholders.
+	if _, err := p.SetProfileDef("p1", map[string]interface{}{"iso_label": "ARCH_P10", "iso_application": "p1 for p10"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.SaveAsTemplate("p1", "lobby", ""); err != nil {
+		t.Fatal(err)
+	}
+	profiledef, err = os.ReadFile(filepath.Join(p.templatesRoot, "lobby", "v1", "profiledef.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`iso_name="archlinux-{{project_id}}"`, `iso_label="ARCH_P10"`, `iso_application="p1 for p10"`} {
+		if !strings.Contains(string(profiledef), want) {
+			t.Errorf("profiledef.sh doesn't have %s:\n%s", want, profiledef)
+		}
+	}
+
+	// Saves that run at the same time get versions of their own.
+	var wg sync.WaitGroup
+	versions := make([]int, 4)
+	for i := range versions {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			saved, err := p.SaveAsTemplate("p1", "busy", "")
+			if err != nil {
+				t.Error(err)
+			}
+			versions[i] = saved.Version
+		}(i)
+	}
+	wg.Wait()
+	sort.Ints(versions)
+	if !reflect.DeepEqual(versions, []int{1, 2, 3, 4}) {
+		t.Errorf("concurrent saves got versions %v, want 1 to 4", versions)
+	}
+}
+
+// archPacmanConf is an excerpt of the pacman.conf Arch Linux ships.
+const archPacmanConf = `#
+# /etc/pacman.conf
+#
+
+[options]
+HoldPkg     = pacman glibc
+Architecture = auto
+#IgnorePkg   =
+#NoExtract   =
+ParallelDownloads = 5
+
+# By default, pacman accepts packages signed by keys that its local keyring
+# trusts (see pacman-key and its man page), as well as unsigned packages.
+SigLevel    = Required DatabaseOptional
+LocalFileSigLevel = Optional
+
+#[core-testing]
+#Include = /etc/pacman.d/mirrorlist
+
+[core]
+Include = /etc/pacman.d/mirrorlist
+
+# The extra repository has everything else.
+[extra]
+Include = /etc/pacman.d/mirrorlist # the host's mirrors
+
+# An example of a custom package repository.
+#[custom]
+#SigLevel = Optional TrustAll
+#Server = file:///home/custompkgs
+`
+
+func TestPacmanConf(t *testing.T) {
+	conf := parsePacmanConf([]byte(archPacmanConf))
+	if got := string(conf.bytes()); got != archPacmanConf {
+		t.Errorf("pacman.conf was not written back as read:\n%s", got)
+	}
+	if got := conf.repoNames(); !reflect.DeepEqual(got, []string{"core", "extra"}) {
+		t.Errorf("repos = %q", got)
+	}
+	if got := conf.section("extra").repo(); !reflect.DeepEqual(got.Include, []string{"/etc/pacman.d/mirrorlist"}) {
+		t.Errorf("extra = %+v, want the comment left out", got)
+	}
+
+	p, err := NewArchPluginWithDataDir(t.TempDir(), runner.NewFake(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CreateProject("p1", nil); err != nil {
+		t.Fatal(err)
+	}
+	profilePath := p.projectProfilePath("p1")
+	if err := os.WriteFile(filepath.Join(profilePath, "pacman.conf"), []byte(archPacmanConf), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	options, err := p.SetRepoOptions("p1", plugin.RepoOptions{SigLevel: "Required DatabaseOptional", IgnorePkg: []string{"linux", "linux-headers"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := plugin.RepoOptions{SigLevel: "Required DatabaseOptional", IgnorePkg: []string{"linux", "linux-headers"}, NoExtract: []string{}}
+	if !reflect.DeepEqual(options, want) {
+		t.Errorf("SetRepoOptions = %+v, want %+v", options, want)
+	}
+	if _, err := p.AddRepo("p1", plugin.Repo{Name: "custom", Servers: []string{"file:///srv/repo"}, SigLevel: "Optional TrustAll"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.AddRepo("p1", plugin.Repo{Name: "multilib", Include: []string{"/etc/pacman.d/mirrorlist"}, Usage: []string{"Sync", "Search"}}, -1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.SetRepoOrder("p1", []string{"core", "extra", "custom", "multilib"}); err != nil {
+		t.Fatal(err)
+	}
+	repos, err := p.RemoveRepo("p1", "multilib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRepos := []plugin.Repo{
+		{Name: "core", Servers: []string{}, Include: []string{"/etc/pacman.d/mirrorlist"}},
+		{Name: "extra", Servers: []string{}, Include: []string{"/etc/pacman.d/mirrorlist"}},
+		{Name: "custom", Servers: []string{"file:///srv/repo"}, Include: []string{}, SigLevel: "Optional TrustAll"},
+	}
+	if !reflect.DeepEqual(repos.Repos, wantRepos) {
+		t.Errorf("repos = %+v, want %+v", repos.Repos, wantRepos)
+	}
+
+	content, err := os.ReadFile(filepath.Join(profilePath, "pacman.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantConf := strings.Replace(archPacmanConf, "#IgnorePkg   =\n", "#IgnorePkg   =\nIgnorePkg = linux linux-headers\n", 1)
+	wantConf = strings.Replace(wantConf, "ParallelDownloads = 5\n", "", 1)
+	wantConf += "\n[custom]\nServer = file:///srv/repo\nSigLevel = Optional TrustAll\n"
+	if string(content) != wantConf {
+		t.Errorf("pacman.conf =\n%s\nwant\n%s", content, wantConf)
+	}
+
+	var perr *plugin.Error
+	for _, repo := range []plugin.Repo{
+		{Name: "options", Servers: []string{"https://repo.example"}},
+		{Name: "bad name", Servers: []string{"https://repo.example"}},
+		{Name: "empty"},
+		{Name: "custom", Servers: []string{"https://repo.example # comment"}},
+		{Name: "custom", Include: []string{"mirrorlist"}},
+		{Name: "custom", Servers: []string{"https://repo.example"}, SigLevel: "Sometimes"},
+		{Name: "custom", Servers: []string{"https://repo.example"}, Usage: []string{"Everything"}},
+	} {
+		if _, err := p.AddRepo("p1", repo, -1); !errors.As(err, &perr) || perr.Code != plugin.ErrInvalidParams {
+			t.Errorf("AddRepo(%+v): %v, want invalid params", repo, err)
+		}
+	}
+	if _, err := p.RemoveRepo("p1", "options"); !errors.As(err, &perr) || perr.Code != plugin.ErrInvalidParams {
+		t.Errorf("RemoveRepo(options): %v, want invalid params", err)
+	}
+	if _, err := p.SetRepoOrder("p1", []string{"core", "extra"}); !errors.As(err, &perr) || perr.Code != plugin.ErrInvalidParams {
+		t.Errorf("SetRepoOrder leaving out a repository: %v, want invalid params", err)
+	}
+}
+
+func TestMirrors(t *testing.T) {
+	p, err := NewArchPluginWithDataDir(t.TempDir(), runner.NewFake(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CreateProject("p1", nil); err != nil {
+		t.Fatal(err)
+	}
+	profilePath := p.projectProfilePath("p1")
+	call := func(method, params string) (interface{}, error) {
+		t.Helper()
+		return p.Methods()[method]("p1", json.RawMessage(params))
+	}
+
+	got, err := call("getMirrors", `{"project_id": "p1"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (mirrors{Servers: []string{defaultMirror}, Ship: true}); !reflect.DeepEqual(got, want) {
+		t.Errorf("getMirrors of a new project = %+v, want %+v", got, want)
+	}
+
+	// Comments in the mirrorlist survive setting its servers.
+	path := filepath.Join(profilePath, mirrorlistFile)
+	if err := os.WriteFile(path, []byte("## Local mirror first\nServer = https://old.example/$repo/os/$arch\n#Server = https://commented.example/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	local := t.TempDir()
+	localMirror := "file://" + local + "/$repo/os/$arch"
+	got, err = call("setMirrors", `{"project_id": "p1", "servers": ["`+localMirror+`", "https://mirror.example/$repo/os/$arch"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (mirrors{Servers: []string{localMirror, "https://mirror.example/$repo/os/$arch"}, Ship: true}); !reflect.DeepEqual(got, want) {
+		t.Errorf("setMirrors = %+v, want %+v", got, want)
+	}
+	content, _ := os.ReadFile(path)
+	if want := "## Local mirror first\nServer = " + localMirror + "\nServer = https://mirror.example/$repo/os/$arch\n#Server = https://commented.example/\n"; string(content) != want {
+		t.Errorf("mirrorlist = %q, want %q", content, want)
+	}
+	shipped, _ := os.ReadFile(filepath.Join(profilePath, filepath.FromSlash(shippedMirrorlist)))
+	if strings.Contains(string(shipped), "file://") || !strings.Contains(string(shipped), "Server = https://mirror.example/$repo/os/$arch\n") {
+		t.Errorf("shipped mirrorlist =\n%s\nwant the mirror without the local one", shipped)
+	}
+
+	// Builds use the project's mirrorlist.
+	buildConf, err := p.writeBuildPacmanConf(profilePath, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs, _ := filepath.Abs(path)
+	if content, _ := os.ReadFile(buildConf); strings.Contains(string(content), hostMirrorlist) || !strings.Contains(string(content), "Include = "+abs+"\n") {
+		t.Errorf("pacman.conf of the build =\n%s\nwant the project's mirrorlist included", content)
+	}
+
+	// Preflight warns about repositories missing from the local mirror.
+	if err := os.MkdirAll(filepath.Join(local, "core", "os", "x86_64"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(local, "core", "os", "x86_64", "core.db"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	findings := checkMirrors(profilePath)
+	if len(findings) != 1 || findings[0].Severity != plugin.SeverityWarning || !strings.Contains(findings[0].Message, "no extra repository") {
+		t.Errorf("checkMirrors = %+v, want a warning about extra", findings)
+	}
+
+	got, err = call("setMirrors", `{"project_id": "p1", "servers": ["`+localMirror+`"], "ship": true}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (mirrors{Servers: []string{localMirror}, Ship: false}); !reflect.DeepEqual(got, want) {
+		t.Errorf("setMirrors with only a local mirror = %+v, want %+v", got, want)
+	}
+
+	got, err = call("setMirrors", `{"project_id": "p1", "servers": []}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (mirrors{Servers: []string{}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("setMirrors without servers = %+v, want %+v", got, want)
+	}
+	buildConf, err = p.writeBuildPacmanConf(profilePath, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(buildConf); !strings.Contains(string(content), "Include = "+hostMirrorlist+"\n") {
+		t.Errorf("pacman.conf of the build =\n%s\nwant the host's mirrorlist included", content)
+	}
+
+	var perr *plugin.Error
+	for _, params := range []string{
+		`{"project_id": "p1"}`,
+		`{"project_id": "p1", "servers": "https://mirror.example"}`,
+		`{"project_id": "p1", "servers": ["file://srv/mirror"]}`,
+		`{"project_id": "p1", "servers": ["gopher://mirror.example"]}`,
+		`{"project_id": "p1", "servers": ["https:///$repo"]}`,
+		`{"project_id": "p1", "servers": ["https://mirror.example/a b"]}`,
+	} {
+		if _, err := call("setMirrors", params); !errors.As(err, &perr) || perr.Code != plugin.ErrInvalidParams {
+			t.Errorf("setMirrors(%s): %v, want invalid params", params, err)
+		}
+	}
+}
+
+// writeSyncDB writes a sync database with a desc file for each package,
+// compressed with gzip if compress is set.
+func writeSyncDB(t *testing.T, path string, compress bool, descs map[string]string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(&buf)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	for dir, desc := range descs {
+		if err := tw.WriteHeader(&tar.Header{Name: dir + "/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: dir + "/desc", Mode: 0644, Size: int64(len(desc))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(desc)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidatePackages(t *testing.T) {
+	p, err := NewArchPluginWithDataDir(t.TempDir(), runner.NewFake(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.syncDBDir = t.TempDir()
+	if err := p.CreateProject("p1", nil); err != nil {
+		t.Fatal(err)
+	}
+	core := map[string]string{
+		"base-3-2":        "%NAME%\nbase\n\n%VERSION%\n3-2\n",
+		"linux-6.9.1-1":   "%NAME%\nlinux\n\n%VERSION%\n6.9.1-1\n",
+		"syslinux-6.04-1": "%NAME%\nsyslinux\n",
+		"grub-2.12-1":     "%NAME%\ngrub\n",
+	}
+	extra := map[string]string{
+		"vim-9.1-1":          "%NAME%\nvim\n",
+		"xorg-server-21.1-1": "%NAME%\nxorg-server\n\n%GROUPS%\nxorg\n",
+		"firefox-126.0-1":    "%NAME%\nfirefox\n\n%PROVIDES%\nweb-browser=1.0\n",
+	}
+	writeSyncDB(t, filepath.Join(p.syncDBDir, "core.db"), true, core)
+	writeSyncDB(t, filepath.Join(p.syncDBDir, "extra.db"), false, extra)
+
+	if err := p.SetPackages("p1", []string{"base", "linux>=6", "xorg", "web-browser", "extra/vim"}); err != nil {
+		t.Errorf("SetPackages with packages, a group and a provision: %v", err)
+	}
+
+	// Packages the repositories dropped only get a warning, and don't keep
+	// other changes from being made.
+	profilePath := p.projectProfilePath("p1")
+	if err := writePackages(profilePath, []string{"base", "linux", "dropped"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetPackages("p1", []string{"base", "linux", "dropped", "vim"}); err != nil {
+		t.Errorf("SetPackages keeping a dropped package: %v", err)
+	}
+	if err := p.SetBootloader("p1", "syslinux"); err != nil {
+		t.Errorf("SetBootloader with a dropped package: %v", err)
+	}
+	findings := p.checkPackageNames(profilePath, []string{"base", "dropped"})
+	if len(findings) != 1 || findings[0].Message != "The repositories have no package dropped" {
+		t.Errorf("checkPackageNames = %+v, want a warning about dropped", findings)
+	}
+
+	err = p.SetPackages("p1", []string{"base", "Vim!", "vimm", "fierfox", "core/vim", "vimm"})
+	var perr *plugin.Error
+	if !errors.As(err, &perr) || perr.Code != plugin.ErrInvalidPackage {
+		t.Fatalf("SetPackages with unknown packages: %v, want invalid package", err)
+	}
+	wantData := map[string]interface{}{
+		"unknown": []string{"Vim!", "vimm", "fierfox", "core/vim"},
+		"suggestions": map[string][]string{
+			"Vim!":     {"vim"},
+			"vimm":     {"vim"},
+			"fierfox":  {"firefox"},
+			"core/vim": {"vim"},
+		},
+	}
+	if !reflect.DeepEqual(perr.Data, wantData) {
+		t.Errorf("error data = %v, want %v", perr.Data, wantData)
+	}
+	if packages, _ := p.GetPackages("p1"); containsString(packages.Packages, "vimm") {
+		t.Errorf("unknown packages were written: %v", packages.Packages)
+	}
+
+	// A local mirror's databases are what the build uses.
+	local := t.TempDir()
+	writeSyncDB(t, filepath.Join(local, "core", "os", "x86_64", "core.db"), true, core)
+	writeSyncDB(t, filepath.Join(local, "extra", "os", "x86_64", "extra.db"), true, map[string]string{"vimm-1-1": "%NAME%\nvimm\n"})
+	if _, err := p.setMirrors("p1", []string{"file://" + local + "/$repo/os/$arch"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetPackages("p1", []string{"base", "vimm"}); err != nil {
+		t.Errorf("SetPackages with a package of the local mirror: %v", err)
+	}
+
+	// Without the database of every repository only the names are checked.
+	if _, err := p.AddRepo("p1", plugin.Repo{Name: "custom", Servers: []string{"https://repo.example/$arch"}}, -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetPackages("p1", []string{"base", "my-tool"}); err != nil {
+		t.Errorf("SetPackages without a database for custom: %v", err)
+	}
+	findings = p.checkPackageNames(profilePath, []string{"base", "my-tool"})
+	if len(findings) != 1 || findings[0].Message != "Package names were not checked against the repositories: no sync database for custom" {
+		t.Errorf("checkPackageNames = %+v, want a warning that names weren't checked", findings)
+	}
+	if err := p.SetPackages("p1", []string{"base", "-bad"}); !errors.As(err, &perr) || perr.Code != plugin.ErrInvalidPackage {
+		t.Errorf("SetPackages with an invalid name: %v, want invalid package", err)
+	}
+
+	// Databases Go can't read don't stop projects from setting packages.
+	if err := os.WriteFile(filepath.Join(p.syncDBDir, "custom.db"), []byte{0x28, 0xb5, 0x2f, 0xfd, 0, 0}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetPackages("p1", []string{"base", "my-tool"}); err != nil {
+		t.Errorf("SetPackages with a zstd database: %v", err)
+	}
+}
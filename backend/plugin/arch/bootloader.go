@@ -0,0 +1,225 @@
+package arch
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+// bootTemplates holds the templates of the boot configuration directories,
+// modelled on archiso's releng profile. They are rendered for a boot menu;
+// mkarchiso then fills in the %VARIABLES%.
+//
+//go:embed templates/boot
+var bootTemplates embed.FS
+
+// bootloaderPart is a bootloader for one kind of firmware. A bootloader
+// choice combines at most one part per firmware, e.g. "syslinux+grub".
+type bootloaderPart struct {
+	name      string
+	firmware  string   // "bios", "uefi-x64" or "uefi-ia32"
+	bootmodes []string // archiso boot modes
+	packages  []string // packages the live system needs for them
+	configDir string   // boot configuration directory of the profile
+}
+
+// bootloaderParts are in the order they appear in bootloader choices.
+var bootloaderParts = []bootloaderPart{
+	{"syslinux", "bios", []string{"bios.syslinux.mbr", "bios.syslinux.eltorito"}, []string{"syslinux"}, "syslinux"},
+	{"grub", "uefi-x64", []string{"uefi-x64.grub.esp", "uefi-x64.grub.eltorito"}, []string{"grub"}, "grub"},
+	{"systemd-boot", "uefi-x64", []string{"uefi-x64.systemd-boot.esp", "uefi-x64.systemd-boot.eltorito"}, nil, "efiboot"},
+	{"grub-ia32", "uefi-ia32", []string{"uefi-ia32.grub.esp", "uefi-ia32.grub.eltorito"}, []string{"grub"}, "grub"},
+	{"systemd-boot-ia32", "uefi-ia32", []string{"uefi-ia32.systemd-boot.esp", "uefi-ia32.systemd-boot.eltorito"}, nil, "efiboot"},
+}
+
+// defaultBootloader boots BIOS and 64-bit UEFI machines.
+const defaultBootloader = "syslinux+grub"
+
+// customBootloader is reported for bootmodes that don't match any choice,
+// e.g. after profiledef.sh was edited by hand.
+const customBootloader = "custom"
+
+// parseBootloader splits a bootloader choice into its parts.
+func parseBootloader(bootloader string) ([]bootloaderPart, error) {
+	var names []string
+	for _, part := range bootloaderParts {
+		names = append(names, part.name)
+	}
+	invalid := func(format string, args ...interface{}) error {
+		err := plugin.NewError(plugin.ErrInvalidBootloader, format, args...)
+		err.Data = map[string]interface{}{"supported": names}
+		return err
+	}
+
+	chosen := make(map[string]bool)
+	firmware := make(map[string]string)
+	for _, name := range strings.Split(bootloader, "+") {
+		name = strings.TrimSpace(name)
+		part, found := findBootloaderPart(name)
+		if !found {
+			return nil, invalid("Unsupported bootloader %q, expected one or more of %s joined with +", name, strings.Join(names, ", "))
+		}
+		if other, taken := firmware[part.firmware]; taken {
+			return nil, invalid("Bootloaders %s and %s both boot %s firmware", other, name, part.firmware)
+		}
+		chosen[name] = true
+		firmware[part.firmware] = name
+	}
+	var parts []bootloaderPart
+	for _, part := range bootloaderParts {
+		if chosen[part.name] {
+			parts = append(parts, part)
+		}
+	}
+	return parts, nil
+}
+
+func findBootloaderPart(name string) (bootloaderPart, bool) {
+	for _, part := range bootloaderParts {
+		if part.name == name {
+			return part, true
+		}
+	}
+	return bootloaderPart{}, false
+}
+
+// bootloaderName joins parts into a bootloader choice.
+func bootloaderName(parts []bootloaderPart) string {
+	names := make([]string, len(parts))
+	for i, part := range parts {
+		names[i] = part.name
+	}
+	return strings.Join(names, "+")
+}
+
+// SetBootloader sets the profile's bootmodes for a bootloader choice, adds
+// the packages it needs and creates the boot configuration directories it
+// reads. Existing configuration directories are kept, so hand edits
+// survive switching back and forth; packages are never removed, since the
+// live system may want them for other reasons.
+func (p *ArchPlugin) SetBootloader(projectID string, bootloader string) error {
+	return p.setBootloader(projectID, bootloader, true)
+}
+
+// setBootloader is SetBootloader, checking the packages it adds against
+// the repositories if check is set. Nothing is written unless they pass.
+func (p *ArchPlugin) setBootloader(projectID string, bootloader string, check bool) error {
+	parts, err := parseBootloader(bootloader)
+	if err != nil {
+		return err
+	}
+	profilePath := p.projectProfilePath(projectID)
+
+	def, err := readProfileDef(profilePath)
+	if err != nil {
+		return err
+	}
+	packages, err := p.GetPackages(projectID)
+	if err != nil {
+		return err
+	}
+	var added []string
+	for _, part := range parts {
+		for _, pkg := range part.packages {
+			if !containsString(packages.Packages, pkg) && !containsString(added, pkg) {
+				added = append(added, pkg)
+			}
+		}
+	}
+	if check {
+		if err := p.validatePackages(profilePath, added); err != nil {
+			return err
+		}
+	}
+
+	var bootmodes []string
+	for _, part := range parts {
+		bootmodes = append(bootmodes, part.bootmodes...)
+	}
+	def.set("bootmodes", bootmodes)
+	if err := os.WriteFile(filepath.Join(profilePath, "profiledef.sh"), def.bytes(), 0755); err != nil {
+		return fmt.Errorf("failed to write profiledef.sh: %w", err)
+	}
+	if len(added) > 0 {
+		if err := writePackages(profilePath, append(packages.Packages, added...)); err != nil {
+			return err
+		}
+	}
+
+	for _, part := range parts {
+		if err := writeBootConfig(profilePath, part.configDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBootConfig creates a boot configuration directory for the
+// profile's boot menu, unless the profile already has it.
+func writeBootConfig(profilePath, dir string) error {
+	if _, err := os.Stat(filepath.Join(profilePath, dir)); err == nil {
+		return nil
+	}
+	menu, err := readBootMenu(profilePath)
+	if err != nil {
+		return err
+	}
+	return renderBootConfig(profilePath, dir, menu)
+}
+
+// GetBootloader reports the bootloader choice matching the profile's
+// bootmodes.
+func (p *ArchPlugin) GetBootloader(projectID string) (plugin.BootloaderResponse, error) {
+	return readBootloader(p.projectProfilePath(projectID))
+}
+
+// readBootloader works out the bootloader choice from the bootmodes in a
+// profile's profiledef.sh. Bootmodes that aren't exactly those of a choice
+// are reported as "custom".
+func readBootloader(profilePath string) (plugin.BootloaderResponse, error) {
+	def, err := readProfileDef(profilePath)
+	if err != nil {
+		return plugin.BootloaderResponse{}, err
+	}
+	a := def.lookup("bootmodes")
+	if a == nil || a.expr {
+		return plugin.BootloaderResponse{Bootloader: customBootloader}, nil
+	}
+	bootmodes, ok := a.value.([]string)
+	if !ok || len(bootmodes) == 0 {
+		return plugin.BootloaderResponse{Bootloader: customBootloader}, nil
+	}
+
+	var parts []bootloaderPart
+	var expected []string
+	for _, part := range bootloaderParts {
+		if containsString(bootmodes, part.bootmodes[0]) {
+			parts = append(parts, part)
+			expected = append(expected, part.bootmodes...)
+		}
+	}
+	actual := append([]string(nil), bootmodes...)
+	sort.Strings(actual)
+	sort.Strings(expected)
+	if strings.Join(actual, " ") != strings.Join(expected, " ") {
+		return plugin.BootloaderResponse{Bootloader: customBootloader}, nil
+	}
+	if _, err := parseBootloader(bootloaderName(parts)); err != nil {
+		return plugin.BootloaderResponse{Bootloader: customBootloader}, nil
+	}
+	return plugin.BootloaderResponse{Bootloader: bootloaderName(parts)}, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
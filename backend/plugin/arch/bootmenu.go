@@ -0,0 +1,323 @@
+package arch
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+// bootMenuFile keeps a profile's boot menu; the bootloader configuration
+// is generated from it.
+const bootMenuFile = ".bootmenu.json"
+
+// presetDir holds the profile's mkinitcpio presets, which build the
+// initramfs-<kernel>.img the boot entries load.
+const presetDir = "airootfs/etc/mkinitcpio.d"
+
+var bootConfigTemplates = template.Must(template.New("").Funcs(template.FuncMap{
+	"grubQuote": grubQuote,
+}).ParseFS(bootTemplates, "templates/boot/*/*.cfg", "templates/boot/efiboot/*.conf", "templates/boot/efiboot/loader/*.conf"))
+
+var (
+	bootEntryIDRe   = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+	kernelParamRe   = regexp.MustCompile(`^[A-Za-z0-9_.,:=/+@-]+$`)
+	presetKernelRe  = regexp.MustCompile(`\b(vmlinuz|initramfs)-linux\b|'linux'`)
+	bootConfigFiles = map[string][]string{
+		"syslinux": {"syslinux.cfg"},
+		"grub":     {"grub.cfg"},
+		"efiboot":  {"loader/loader.conf"},
+	}
+)
+
+// defaultBootMenu is the menu of new profiles: a single entry booting the
+// live system.
+func defaultBootMenu() plugin.BootMenu {
+	return plugin.BootMenu{
+		Timeout:      15,
+		Default:      "arch",
+		KernelParams: []string{},
+		Entries: []plugin.BootEntry{
+			{ID: "arch", Title: "Arch Linux (%ARCH%)", Kernel: "linux", KernelParams: []string{}},
+		},
+	}
+}
+
+// readBootMenu returns a profile's boot menu.
+func readBootMenu(profilePath string) (plugin.BootMenu, error) {
+	content, err := os.ReadFile(filepath.Join(profilePath, bootMenuFile))
+	if os.IsNotExist(err) {
+		return defaultBootMenu(), nil
+	}
+	if err != nil {
+		return plugin.BootMenu{}, fmt.Errorf("failed to read boot menu: %w", err)
+	}
+	var menu plugin.BootMenu
+	if err := json.Unmarshal(content, &menu); err != nil {
+		return plugin.BootMenu{}, fmt.Errorf("failed to parse %s: %w", bootMenuFile, err)
+	}
+	return menu, nil
+}
+
+// GetBootMenu implements plugin.BootMenuEditor.
+func (p *ArchPlugin) GetBootMenu(projectID string) (plugin.BootMenu, error) {
+	profilePath := p.projectProfilePath(projectID)
+	if _, err := os.Stat(profilePath); err != nil {
+		return plugin.BootMenu{}, fmt.Errorf("project %s not found: %w", projectID, err)
+	}
+	return readBootMenu(profilePath)
+}
+
+// SetBootMenu implements plugin.BootMenuEditor. It regenerates the
+// configuration of every bootloader the profile has a directory for,
+// replacing hand edits, and adds the kernels the entries boot to the
+// package list and an mkinitcpio preset for them. Nothing is written
+// unless the repositories have the kernels and the profile has a preset
+// for them or a linux.preset to model one on.
+func (p *ArchPlugin) SetBootMenu(projectID string, menu plugin.BootMenu) (plugin.BootMenu, error) {
+	profilePath := p.projectProfilePath(projectID)
+	if _, err := os.Stat(profilePath); err != nil {
+		return plugin.BootMenu{}, fmt.Errorf("project %s not found: %w", projectID, err)
+	}
+	menu, err := normalizeBootMenu(menu)
+	if err != nil {
+		return plugin.BootMenu{}, err
+	}
+	packages, err := p.GetPackages(projectID)
+	if err != nil {
+		return plugin.BootMenu{}, err
+	}
+	var kernels []string
+	for _, entry := range menu.Entries {
+		if !containsString(packages.Packages, entry.Kernel) && !containsString(kernels, entry.Kernel) {
+			kernels = append(kernels, entry.Kernel)
+		}
+	}
+	presets, err := kernelPresets(profilePath, menu)
+	if err != nil {
+		return plugin.BootMenu{}, err
+	}
+	if err := p.validatePackages(profilePath, kernels); err != nil {
+		return plugin.BootMenu{}, err
+	}
+
+	content, err := json.MarshalIndent(menu, "", "  ")
+	if err != nil {
+		return plugin.BootMenu{}, err
+	}
+	if err := os.WriteFile(filepath.Join(profilePath, bootMenuFile), append(content, '\n'), 0644); err != nil {
+		return plugin.BootMenu{}, fmt.Errorf("failed to write boot menu: %w", err)
+	}
+	for dir := range bootConfigFiles {
+		if _, err := os.Stat(filepath.Join(profilePath, dir)); err != nil {
+			continue
+		}
+		if err := renderBootConfig(profilePath, dir, menu); err != nil {
+			return plugin.BootMenu{}, err
+		}
+	}
+
+	for kernel, content := range presets {
+		path := filepath.Join(profilePath, presetDir, kernel+".preset")
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			return plugin.BootMenu{}, fmt.Errorf("failed to write mkinitcpio preset for %s: %w", kernel, err)
+		}
+	}
+	if len(kernels) > 0 {
+		if err := writePackages(profilePath, append(packages.Packages, kernels...)); err != nil {
+			return plugin.BootMenu{}, err
+		}
+	}
+	return menu, nil
+}
+
+// normalizeBootMenu checks a boot menu and fills in its defaults.
+func normalizeBootMenu(menu plugin.BootMenu) (plugin.BootMenu, error) {
+	invalid := func(format string, args ...interface{}) (plugin.BootMenu, error) {
+		return plugin.BootMenu{}, plugin.NewError(plugin.ErrInvalidParams, format, args...)
+	}
+	if menu.Timeout < -1 {
+		return invalid("Invalid boot menu timeout %d, expected seconds, 0 to boot right away or -1 to wait", menu.Timeout)
+	}
+	if len(menu.Entries) == 0 {
+		return invalid("The boot menu needs at least one entry")
+	}
+	if err := checkKernelParams(menu.KernelParams); err != nil {
+		return plugin.BootMenu{}, err
+	}
+	if menu.KernelParams == nil {
+		menu.KernelParams = []string{}
+	}
+
+	seen := make(map[string]bool)
+	entries := make([]plugin.BootEntry, len(menu.Entries))
+	for i, entry := range menu.Entries {
+		if !bootEntryIDRe.MatchString(entry.ID) {
+			return invalid("Invalid boot entry id %q, expected lowercase letters, digits and dashes", entry.ID)
+		}
+		if seen[entry.ID] {
+			return invalid("Duplicate boot entry id %q", entry.ID)
+		}
+		seen[entry.ID] = true
+		if strings.TrimSpace(entry.Title) == "" || strings.IndexFunc(entry.Title, unicode.IsControl) >= 0 {
+			return invalid("Invalid title for boot entry %q, expected a single line of text", entry.ID)
+		}
+		if entry.Kernel == "" {
+			entry.Kernel = "linux"
+		}
+		if !containsString(kernelPackages, entry.Kernel) {
+			return invalid("Unsupported kernel %q for boot entry %q, expected one of %s", entry.Kernel, entry.ID, strings.Join(kernelPackages, ", "))
+		}
+		if err := checkKernelParams(entry.KernelParams); err != nil {
+			return plugin.BootMenu{}, err
+		}
+		if entry.KernelParams == nil {
+			entry.KernelParams = []string{}
+		}
+		entries[i] = entry
+	}
+	menu.Entries = entries
+
+	if menu.Default == "" {
+		menu.Default = entries[0].ID
+	}
+	if !seen[menu.Default] {
+		return invalid("The default boot entry %q is not in the menu", menu.Default)
+	}
+	return menu, nil
+}
+
+// kernelPresets returns the mkinitcpio presets a profile lacks for the
+// kernels of a boot menu, modeled on its linux.preset. The profile's own
+// linux kernel is left to the profile.
+func kernelPresets(profilePath string, menu plugin.BootMenu) (map[string][]byte, error) {
+	presets := make(map[string][]byte)
+	var model []byte
+	for _, entry := range menu.Entries {
+		if entry.Kernel == "linux" || presets[entry.Kernel] != nil {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(profilePath, presetDir, entry.Kernel+".preset")); err == nil {
+			continue
+		}
+		if model == nil {
+			content, err := os.ReadFile(filepath.Join(profilePath, presetDir, "linux.preset"))
+			if os.IsNotExist(err) {
+				return nil, plugin.NewError(plugin.ErrInvalidParams, "The profile has no mkinitcpio preset for kernel %q of boot entry %q, and no %s/linux.preset to model one on", entry.Kernel, entry.ID, presetDir)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read mkinitcpio preset: %w", err)
+			}
+			model = content
+		}
+		kernel := entry.Kernel
+		presets[kernel] = presetKernelRe.ReplaceAllFunc(model, func(m []byte) []byte {
+			if m[0] == '\'' {
+				return []byte("'" + kernel + "'")
+			}
+			return []byte(strings.TrimSuffix(string(m), "linux") + kernel)
+		})
+	}
+	return presets, nil
+}
+
+func checkKernelParams(params []string) error {
+	for _, param := range params {
+		if !kernelParamRe.MatchString(param) {
+			return plugin.NewError(plugin.ErrInvalidParams, "Invalid kernel parameter %q", param)
+		}
+	}
+	return nil
+}
+
+// bootMenuView is what the boot configuration templates see.
+type bootMenuView struct {
+	plugin.BootMenu
+	Entries         []bootEntryView
+	SyslinuxTimeout int    // in tenths of a second, 0 waits
+	LoaderTimeout   string // systemd-boot's loader.conf timeout
+	DefaultFile     string // systemd-boot entry of the default entry
+}
+
+type bootEntryView struct {
+	plugin.BootEntry
+	Params  string // all kernel parameters, each preceded by a space
+	SortKey string
+	File    string // systemd-boot entry file
+}
+
+func newBootMenuView(menu plugin.BootMenu) bootMenuView {
+	view := bootMenuView{BootMenu: menu}
+	switch {
+	case menu.Timeout < 0:
+		view.SyslinuxTimeout, view.LoaderTimeout = 0, "menu-force"
+	case menu.Timeout == 0:
+		view.SyslinuxTimeout, view.LoaderTimeout = 1, "0"
+	default:
+		view.SyslinuxTimeout, view.LoaderTimeout = menu.Timeout*10, fmt.Sprint(menu.Timeout)
+	}
+	for i, entry := range menu.Entries {
+		var params strings.Builder
+		for _, param := range append(append([]string(nil), menu.KernelParams...), entry.KernelParams...) {
+			params.WriteString(" " + param)
+		}
+		e := bootEntryView{
+			BootEntry: entry,
+			Params:    params.String(),
+			SortKey:   fmt.Sprintf("%02d", i+1),
+			File:      fmt.Sprintf("%02d-%s.conf", i+1, entry.ID),
+		}
+		if entry.ID == menu.Default {
+			view.DefaultFile = e.File
+		}
+		view.Entries = append(view.Entries, e)
+	}
+	return view
+}
+
+// renderBootConfig writes a bootloader's configuration directory for a
+// boot menu. For systemd-boot, the loader entries are replaced as a whole.
+func renderBootConfig(profilePath, dir string, menu plugin.BootMenu) error {
+	view := newBootMenuView(menu)
+	render := func(name, path string, data interface{}) error {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		var b strings.Builder
+		if err := bootConfigTemplates.ExecuteTemplate(&b, name, data); err != nil {
+			return err
+		}
+		return os.WriteFile(path, []byte(b.String()), 0644)
+	}
+
+	for _, file := range bootConfigFiles[dir] {
+		if err := render(filepath.Base(file), filepath.Join(profilePath, dir, file), view); err != nil {
+			return fmt.Errorf("failed to write %s/%s: %w", dir, file, err)
+		}
+	}
+	if dir == "efiboot" {
+		entriesDir := filepath.Join(profilePath, dir, "loader", "entries")
+		if err := os.RemoveAll(entriesDir); err != nil {
+			return fmt.Errorf("failed to remove old loader entries: %w", err)
+		}
+		for _, entry := range view.Entries {
+			if err := render("entry.conf", filepath.Join(entriesDir, entry.File), entry); err != nil {
+				return fmt.Errorf("failed to write loader entry %s: %w", entry.File, err)
+			}
+		}
+	}
+	return nil
+}
+
+// grubQuote quotes a string for grub.cfg.
+func grubQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+var _ plugin.BootMenuEditor = (*ArchPlugin)(nil)
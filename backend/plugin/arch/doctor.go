@@ -0,0 +1,98 @@
+package arch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+// Diagnose implements plugin.Diagnoser. Besides the host requirements it
+// checks the plugin's directories and looks for loop and bind mounts left
+// below the work root by builds that were killed. With opts.Fix, missing
+// directories are created and leftover mounts unmounted.
+func (p *ArchPlugin) Diagnose(ctx context.Context, opts plugin.DiagnoseOptions) []plugin.Finding {
+	findings := plugin.CheckHostRequirements(ctx, p.runner, hostRequirements)
+
+	for _, dir := range []string{p.projectsRoot, p.isosRoot, p.workRoot, p.cacheDir} {
+		if _, err := os.Stat(dir); err == nil || !os.IsNotExist(err) {
+			continue
+		}
+		f := plugin.Finding{
+			Check:    "data_dir",
+			Severity: plugin.SeverityError,
+			Message:  fmt.Sprintf("Directory %s is missing", dir),
+			Fix:      fmt.Sprintf("mkdir -p %s", dir),
+			AutoFix:  true,
+		}
+		if opts.Fix {
+			f.Fixed = os.MkdirAll(dir, 0755) == nil
+		}
+		findings = append(findings, f)
+	}
+
+	mounts, err := plugin.MountsBelow(p.mountInfo, p.workRoot)
+	if err != nil {
+		return append(findings, plugin.Finding{
+			Check:    "mounts",
+			Severity: plugin.SeverityWarning,
+			Message:  fmt.Sprintf("Could not check for leftover mounts: %v", err),
+		})
+	}
+	for _, mountPoint := range mounts {
+		if p.inUse(mountPoint, opts.Building) {
+			continue
+		}
+		f := plugin.Finding{
+			Check:    "mounts",
+			Severity: plugin.SeverityError,
+			Message:  fmt.Sprintf("%s is still mounted from an earlier build", mountPoint),
+			Fix:      fmt.Sprintf("sudo umount %s", mountPoint),
+			AutoFix:  true,
+		}
+		if opts.Fix {
+			f.Fixed = p.unmount(mountPoint, io.Discard) == nil
+		}
+		findings = append(findings, f)
+	}
+	return findings
+}
+
+// inUse reports whether mountPoint belongs to the work directory of one of
+// the projects being built.
+func (p *ArchPlugin) inUse(mountPoint string, building []string) bool {
+	for _, projectID := range building {
+		workDir := filepath.Join(p.workRoot, projectID)
+		if mountPoint == workDir || strings.HasPrefix(mountPoint, workDir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ProjectIDs implements plugin.Diagnoser: every directory in the projects
+// root that holds an Arch profile is a project.
+func (p *ArchPlugin) ProjectIDs() ([]string, error) {
+	entries, err := os.ReadDir(p.projectsRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list projects: %w", err)
+	}
+	var ids []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(p.projectProfilePath(entry.Name())); err == nil {
+			ids = append(ids, entry.Name())
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+var _ plugin.Diagnoser = (*ArchPlugin)(nil)
@@ -0,0 +1,54 @@
+package arch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+// readInputs describes the profile a build is made from: the variables of
+// profiledef.sh, the bootloader choice, pacman.conf and the project's
+// mirrorlist as settings, and the airootfs overlay as files.
+func readInputs(profilePath string) (*plugin.Inputs, error) {
+	inputs := &plugin.Inputs{Settings: make(map[string]string), Files: make(map[string]string)}
+
+	def, err := readProfileDef(profilePath)
+	if err != nil {
+		return nil, err
+	}
+	for name, a := range def.variables() {
+		inputs.Settings["profiledef."+name] = a.setting()
+	}
+	bootloader, err := readBootloader(profilePath)
+	if err != nil {
+		return nil, err
+	}
+	inputs.Settings["bootloader"] = bootloader.Bootloader
+	pacmanConf, err := os.ReadFile(filepath.Join(profilePath, "pacman.conf"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pacman.conf: %w", err)
+	}
+	sum := sha256.Sum256(pacmanConf)
+	inputs.Settings["pacman.conf"] = "sha256:" + hex.EncodeToString(sum[:])
+	if path, ok := projectMirrorlist(profilePath); ok {
+		mirrorlist, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mirrorlist: %w", err)
+		}
+		sum := sha256.Sum256(mirrorlist)
+		inputs.Settings["mirrorlist"] = "sha256:" + hex.EncodeToString(sum[:])
+	}
+
+	files, err := plugin.HashFiles(filepath.Join(profilePath, "airootfs"))
+	if err != nil {
+		return nil, err
+	}
+	for path, desc := range files {
+		inputs.Files["airootfs/"+path] = desc
+	}
+	return inputs, nil
+}
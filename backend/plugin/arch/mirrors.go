@@ -0,0 +1,257 @@
+package arch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+// hostMirrorlist is the build host's mirrorlist, which repositories
+// include unless the project has a mirrorlist of its own.
+const hostMirrorlist = "/etc/pacman.d/mirrorlist"
+
+// A project's mirrorlist lives in its profile, where builds use it, and
+// is shipped in the image for the live system's pacman. The shipped copy
+// leaves out file:// mirrors, which only exist on the build host.
+const (
+	mirrorlistFile    = "mirrorlist"
+	shippedMirrorlist = "airootfs/etc/pacman.d/mirrorlist"
+)
+
+// defaultMirror is the mirror new projects start with. It redirects to a
+// mirror near the build host.
+const defaultMirror = "https://geo.mirror.pkgbuild.com/$repo/os/$arch"
+
+var mirrorlistHeader = []string{
+	"# Mirrors of the repositories that include " + hostMirrorlist + ",",
+	"# in order of preference.",
+	"",
+}
+
+// mirrors is the result of arch.getMirrors and arch.setMirrors.
+type mirrors struct {
+	// Servers is empty if builds use the build host's mirrorlist.
+	Servers []string `json:"servers"`
+	// Ship tells whether the image has the mirrorlist.
+	Ship bool `json:"ship"`
+}
+
+// Methods implements plugin.MethodProvider.
+func (p *ArchPlugin) Methods() map[string]plugin.MethodHandler {
+	return map[string]plugin.MethodHandler{
+		"getMirrors": func(projectID string, params json.RawMessage) (interface{}, error) {
+			return p.getMirrors(projectID)
+		},
+		"setMirrors": func(projectID string, params json.RawMessage) (interface{}, error) {
+			var req struct {
+				Servers []string `json:"servers"`
+				Ship    *bool    `json:"ship"`
+			}
+			if err := json.Unmarshal(params, &req); err != nil || req.Servers == nil {
+				return nil, plugin.NewError(plugin.ErrInvalidParams, "Missing or invalid 'servers' in params for setMirrors, expected a list of URLs")
+			}
+			ship := req.Ship == nil || *req.Ship
+			return p.setMirrors(projectID, req.Servers, ship)
+		},
+	}
+}
+
+// parseMirrorlist reads a mirrorlist as a pacman.conf section without a
+// header, so that setting its servers leaves comments where they are.
+func parseMirrorlist(content []byte) *pacmanSection {
+	s := &pacmanSection{header: -1}
+	if text := strings.TrimSuffix(string(content), "\n"); text != "" {
+		s.lines = strings.Split(text, "\n")
+	}
+	return s
+}
+
+// readMirrorlist returns the mirrorlist at path, or nil if there is none.
+func readMirrorlist(path string) (*pacmanSection, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mirrorlist: %w", err)
+	}
+	return parseMirrorlist(content), nil
+}
+
+// writeMirrorlist sets the servers of the mirrorlist at path, creating it
+// if needed.
+func writeMirrorlist(path string, servers []string) error {
+	s, err := readMirrorlist(path)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		s = parseMirrorlist([]byte(strings.Join(mirrorlistHeader, "\n")))
+	}
+	var lines []string
+	for _, server := range servers {
+		lines = append(lines, "Server = "+server)
+	}
+	s.replace([]string{"Server"}, lines)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(s.lines, "\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write mirrorlist: %w", err)
+	}
+	return nil
+}
+
+// projectMirrorlist returns the path of the project's mirrorlist if it has
+// one with servers.
+func projectMirrorlist(profilePath string) (string, bool) {
+	path := filepath.Join(profilePath, mirrorlistFile)
+	s, err := readMirrorlist(path)
+	if err != nil || s == nil || len(s.values("Server")) == 0 {
+		return "", false
+	}
+	return path, true
+}
+
+// validateMirror checks a mirror URL. Local mirrors need an absolute path,
+// e.g. file:///srv/mirror/$repo/os/$arch.
+func validateMirror(server string) error {
+	u, err := url.Parse(server)
+	if err != nil || !validValue(server) || strings.ContainsAny(server, " \t") {
+		return plugin.NewError(plugin.ErrInvalidParams, "Invalid mirror %q, expected an http, https, ftp or file URL", server)
+	}
+	switch u.Scheme {
+	case "http", "https", "ftp":
+		if u.Host == "" {
+			return plugin.NewError(plugin.ErrInvalidParams, "Invalid mirror %q, expected a host", server)
+		}
+	case "file":
+		if u.Host != "" || !strings.HasPrefix(u.Path, "/") {
+			return plugin.NewError(plugin.ErrInvalidParams, "Invalid mirror %q, expected file:// and an absolute path", server)
+		}
+	default:
+		return plugin.NewError(plugin.ErrInvalidParams, "Invalid mirror %q, expected an http, https, ftp or file URL", server)
+	}
+	return nil
+}
+
+func (p *ArchPlugin) getMirrors(projectID string) (mirrors, error) {
+	profilePath := p.projectProfilePath(projectID)
+	if _, err := os.Stat(profilePath); err != nil {
+		return mirrors{}, fmt.Errorf("project %s not found: %w", projectID, err)
+	}
+	result := mirrors{Servers: []string{}}
+	s, err := readMirrorlist(filepath.Join(profilePath, mirrorlistFile))
+	if err != nil {
+		return mirrors{}, err
+	}
+	if s != nil {
+		result.Servers = append(result.Servers, s.values("Server")...)
+	}
+	if _, err := os.Stat(filepath.Join(profilePath, filepath.FromSlash(shippedMirrorlist))); err == nil {
+		result.Ship = true
+	}
+	return result, nil
+}
+
+// setMirrors replaces the project's mirrors. Without servers, builds go
+// back to the build host's mirrorlist and the image gets none.
+func (p *ArchPlugin) setMirrors(projectID string, servers []string, ship bool) (mirrors, error) {
+	profilePath := p.projectProfilePath(projectID)
+	if _, err := os.Stat(profilePath); err != nil {
+		return mirrors{}, fmt.Errorf("project %s not found: %w", projectID, err)
+	}
+	var shipped []string
+	for _, server := range servers {
+		if err := validateMirror(server); err != nil {
+			return mirrors{}, err
+		}
+		if !strings.HasPrefix(server, "file:") {
+			shipped = append(shipped, server)
+		}
+	}
+
+	path := filepath.Join(profilePath, mirrorlistFile)
+	if len(servers) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return mirrors{}, fmt.Errorf("failed to remove mirrorlist: %w", err)
+		}
+	} else if err := writeMirrorlist(path, servers); err != nil {
+		return mirrors{}, err
+	}
+
+	shippedPath := filepath.Join(profilePath, filepath.FromSlash(shippedMirrorlist))
+	if ship && len(shipped) > 0 {
+		if err := writeMirrorlist(shippedPath, shipped); err != nil {
+			return mirrors{}, err
+		}
+	} else if err := os.Remove(shippedPath); err != nil && !os.IsNotExist(err) {
+		return mirrors{}, fmt.Errorf("failed to remove %s: %w", shippedMirrorlist, err)
+	}
+	return p.getMirrors(projectID)
+}
+
+// checkMirrors warns about local mirrors that lack a repository the
+// project's pacman.conf gets from the mirrorlist. Builds on air-gapped
+// hosts would fail on them.
+func checkMirrors(profilePath string) []plugin.Finding {
+	path, ok := projectMirrorlist(profilePath)
+	if !ok {
+		return nil
+	}
+	list, err := readMirrorlist(path)
+	if err != nil {
+		return []plugin.Finding{{Check: "mirrors", Severity: plugin.SeverityError, Message: err.Error()}}
+	}
+	conf, err := readPacmanConf(profilePath)
+	if err != nil {
+		return nil
+	}
+	arch := profileArch(profilePath)
+
+	var findings []plugin.Finding
+	for _, server := range list.values("Server") {
+		for _, repo := range conf.repos() {
+			dir, ok := localMirrorDir(server, repo.name, arch)
+			if !ok || !containsString(repo.values("Include"), hostMirrorlist) {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(dir, repo.name+".db")); err != nil {
+				findings = append(findings, plugin.Finding{
+					Check:    "mirrors",
+					Severity: plugin.SeverityWarning,
+					Message:  fmt.Sprintf("Local mirror %s has no %s repository in %s", server, repo.name, dir),
+					Fix:      "Copy the repository there, or remove the mirror with arch.setMirrors",
+				})
+			}
+		}
+	}
+	return findings
+}
+
+// localMirrorDir returns the directory a file:// mirror has a repository
+// in.
+func localMirrorDir(server, repo, arch string) (string, bool) {
+	u, err := url.Parse(server)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+	return strings.NewReplacer("$repo", repo, "$arch", arch).Replace(u.Path), true
+}
+
+// profileArch returns the architecture a profile is built for.
+func profileArch(profilePath string) string {
+	if def, err := readProfileDef(profilePath); err == nil {
+		if arch, ok := def.literal("arch"); ok && arch != "" {
+			return arch
+		}
+	}
+	return "x86_64"
+}
+
+var _ plugin.MethodProvider = (*ArchPlugin)(nil)
@@ -0,0 +1,541 @@
+package arch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+// pacmanConf is a parsed pacman.conf. Like profileDef, it keeps every line
+// as it was read and only rewrites the directives that change, so comments
+// and settings it knows nothing about survive.
+type pacmanConf struct {
+	preamble []string // lines before the first section
+	sections []*pacmanSection
+}
+
+// pacmanSection is a section of pacman.conf: "[options]" or a repository.
+type pacmanSection struct {
+	name string
+	// lines are the lines of the section without their newlines: the
+	// comments right above its header, the header at lines[header], and
+	// everything up to the next section.
+	lines  []string
+	header int
+}
+
+// optionsSection holds pacman's global options; every other section is a
+// repository.
+const optionsSection = "options"
+
+func parsePacmanConf(content []byte) *pacmanConf {
+	conf := &pacmanConf{}
+	text := strings.TrimSuffix(string(content), "\n")
+	if text == "" {
+		return conf
+	}
+	var current *pacmanSection
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "[") || !strings.HasSuffix(trimmed, "]") {
+			if current == nil {
+				conf.preamble = append(conf.preamble, line)
+			} else {
+				current.lines = append(current.lines, line)
+			}
+			continue
+		}
+		// Comments right above a header describe its section and move
+		// with it.
+		prev := &conf.preamble
+		if current != nil {
+			prev = &current.lines
+		}
+		n := len(*prev)
+		for n > 0 && strings.HasPrefix(strings.TrimSpace((*prev)[n-1]), "#") {
+			n--
+		}
+		lead := append([]string(nil), (*prev)[n:]...)
+		*prev = (*prev)[:n]
+		current = &pacmanSection{
+			name:   strings.TrimSpace(trimmed[1 : len(trimmed)-1]),
+			lines:  append(lead, line),
+			header: len(lead),
+		}
+		conf.sections = append(conf.sections, current)
+	}
+	return conf
+}
+
+// directive splits a line into the key and value of a directive. pacman
+// ignores everything after a #.
+func directive(line string) (key, value string, ok bool) {
+	if i := strings.Index(line, "#"); i >= 0 {
+		line = line[:i]
+	}
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "[") {
+		return "", "", false
+	}
+	key, value, _ = strings.Cut(line, "=")
+	return strings.TrimSpace(key), strings.TrimSpace(value), true
+}
+
+func (c *pacmanConf) section(name string) *pacmanSection {
+	for _, s := range c.sections {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// options returns the [options] section, adding one in front of the
+// repositories if there is none.
+func (c *pacmanConf) options() *pacmanSection {
+	if s := c.section(optionsSection); s != nil {
+		return s
+	}
+	s := &pacmanSection{name: optionsSection, lines: []string{"[" + optionsSection + "]", ""}}
+	c.sections = append([]*pacmanSection{s}, c.sections...)
+	return s
+}
+
+// repos returns the repository sections in the order pacman uses them.
+func (c *pacmanConf) repos() []*pacmanSection {
+	var repos []*pacmanSection
+	for _, s := range c.sections {
+		if s.name != optionsSection {
+			repos = append(repos, s)
+		}
+	}
+	return repos
+}
+
+func (c *pacmanConf) repoNames() []string {
+	names := []string{}
+	for _, s := range c.repos() {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+// setRepos puts repos in the places of the current repository sections,
+// in order. Sections beyond those places go at the end.
+func (c *pacmanConf) setRepos(repos []*pacmanSection) {
+	var sections []*pacmanSection
+	for _, s := range c.sections {
+		if s.name == optionsSection {
+			sections = append(sections, s)
+		} else if len(repos) > 0 {
+			sections = append(sections, repos[0])
+			repos = repos[1:]
+		}
+	}
+	c.sections = append(sections, repos...)
+
+	// Keep a blank line between sections that were moved next to each
+	// other, and none at the end of the file.
+	for i, s := range c.sections {
+		last := i == len(c.sections)-1
+		for last && len(s.lines) > s.header+1 && strings.TrimSpace(s.lines[len(s.lines)-1]) == "" {
+			s.lines = s.lines[:len(s.lines)-1]
+		}
+		if !last && strings.TrimSpace(s.lines[len(s.lines)-1]) != "" {
+			s.lines = append(s.lines, "")
+		}
+	}
+}
+
+func (c *pacmanConf) bytes() []byte {
+	var b strings.Builder
+	for _, line := range c.preamble {
+		b.WriteString(line + "\n")
+	}
+	for _, s := range c.sections {
+		for _, line := range s.lines {
+			b.WriteString(line + "\n")
+		}
+	}
+	return []byte(b.String())
+}
+
+// values returns the values of the directives with the given key, in
+// order.
+func (s *pacmanSection) values(key string) []string {
+	var values []string
+	for _, line := range s.lines[s.header+1:] {
+		if k, v, ok := directive(line); ok && k == key {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// fields returns the space separated values of the directives with the
+// given key, such as IgnorePkg, which may appear more than once.
+func (s *pacmanSection) fields(key string) []string {
+	fields := []string{}
+	for _, v := range s.values(key) {
+		fields = append(fields, strings.Fields(v)...)
+	}
+	return fields
+}
+
+// set replaces the directives with the given key with one of the given
+// value, or removes them if it is empty. A directive that is replaced
+// keeps its alignment.
+func (s *pacmanSection) set(key, value string) {
+	if value == "" {
+		s.replace([]string{key}, nil)
+		return
+	}
+	prefix := key + " = "
+	for _, line := range s.lines[s.header+1:] {
+		if k, _, ok := directive(line); ok && k == key {
+			if i := strings.Index(line, "="); i >= 0 {
+				rest := line[i+1:]
+				prefix = line[:i+1] + rest[:len(rest)-len(strings.TrimLeft(rest, " \t"))]
+			}
+			break
+		}
+	}
+	s.replace([]string{key}, []string{prefix + value})
+}
+
+// replace removes the directives with any of the given keys and puts lines
+// where the first of them was. Without one, lines go below a commented out
+// directive with the first key, as in pacman's own pacman.conf, or else
+// after the last line of the section that isn't blank.
+func (s *pacmanSection) replace(keys []string, lines []string) {
+	at := -1
+	kept := append([]string(nil), s.lines[:s.header+1]...)
+	for _, line := range s.lines[s.header+1:] {
+		if k, _, ok := directive(line); ok && containsString(keys, k) {
+			if at < 0 {
+				at = len(kept)
+			}
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if at < 0 {
+		at = len(kept)
+		for at > s.header+1 && strings.TrimSpace(kept[at-1]) == "" {
+			at--
+		}
+		for i := s.header + 1; i < len(kept); i++ {
+			commented := strings.TrimSpace(kept[i])
+			if !strings.HasPrefix(commented, "#") {
+				continue
+			}
+			if k, _, ok := directive(strings.TrimLeft(commented, "# \t")); ok && k == keys[0] {
+				at = i + 1
+				break
+			}
+		}
+	}
+	s.lines = append(kept[:at], append(append([]string(nil), lines...), kept[at:]...)...)
+}
+
+// prepend puts a line right below the section's header.
+func (s *pacmanSection) prepend(line string) {
+	s.lines = append(s.lines[:s.header+1], append([]string{line}, s.lines[s.header+1:]...)...)
+}
+
+// substitute changes the value of the directives with the given key and
+// value to another.
+func (s *pacmanSection) substitute(key, old, new string) {
+	for i := s.header + 1; i < len(s.lines); i++ {
+		if k, v, ok := directive(s.lines[i]); ok && k == key && v == old {
+			s.lines[i] = key + " = " + new
+		}
+	}
+}
+
+// repo returns the repository a section configures.
+func (s *pacmanSection) repo() plugin.Repo {
+	repo := plugin.Repo{
+		Name:     s.name,
+		Servers:  s.values("Server"),
+		Include:  s.values("Include"),
+		SigLevel: strings.Join(s.fields("SigLevel"), " "),
+		Usage:    s.fields("Usage"),
+	}
+	if repo.Servers == nil {
+		repo.Servers = []string{}
+	}
+	if repo.Include == nil {
+		repo.Include = []string{}
+	}
+	if len(repo.Usage) == 0 {
+		repo.Usage = nil
+	}
+	return repo
+}
+
+// setRepo configures a section for repo. Its servers are only rewritten if
+// they change.
+func (s *pacmanSection) setRepo(repo plugin.Repo) {
+	if strings.Join(s.values("Server"), "\n") != strings.Join(repo.Servers, "\n") || strings.Join(s.values("Include"), "\n") != strings.Join(repo.Include, "\n") {
+		var lines []string
+		for _, server := range repo.Servers {
+			lines = append(lines, "Server = "+server)
+		}
+		for _, include := range repo.Include {
+			lines = append(lines, "Include = "+include)
+		}
+		s.replace([]string{"Server", "Include"}, lines)
+	}
+	if repo.SigLevel != strings.Join(s.fields("SigLevel"), " ") {
+		s.set("SigLevel", repo.SigLevel)
+	}
+	if strings.Join(repo.Usage, " ") != strings.Join(s.fields("Usage"), " ") {
+		s.set("Usage", strings.Join(repo.Usage, " "))
+	}
+}
+
+var (
+	repoNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	sigLevels  = []string{"Never", "Optional", "Required", "TrustedOnly", "TrustAll"}
+	repoUsages = []string{"Sync", "Search", "Install", "Upgrade", "All"}
+)
+
+func validateRepo(repo plugin.Repo) error {
+	if !repoNameRe.MatchString(repo.Name) || repo.Name == optionsSection {
+		return plugin.NewError(plugin.ErrInvalidParams, "Invalid repository name %q", repo.Name)
+	}
+	if len(repo.Servers) == 0 && len(repo.Include) == 0 {
+		return plugin.NewError(plugin.ErrInvalidParams, "Repository %s needs a server or an Include", repo.Name)
+	}
+	for _, server := range repo.Servers {
+		if !validValue(server) || strings.ContainsAny(server, " \t") || !strings.Contains(server, "://") {
+			return plugin.NewError(plugin.ErrInvalidParams, "Invalid server %q for repository %s, expected a URL", server, repo.Name)
+		}
+	}
+	for _, include := range repo.Include {
+		if !validValue(include) || !filepath.IsAbs(include) {
+			return plugin.NewError(plugin.ErrInvalidParams, "Invalid Include %q for repository %s, expected an absolute path", include, repo.Name)
+		}
+	}
+	if err := validateSigLevel(repo.SigLevel); err != nil {
+		return err
+	}
+	for _, usage := range repo.Usage {
+		if !containsString(repoUsages, usage) {
+			return plugin.NewError(plugin.ErrInvalidParams, "Invalid Usage %q for repository %s, expected one of %s", usage, repo.Name, strings.Join(repoUsages, ", "))
+		}
+	}
+	return nil
+}
+
+// validateSigLevel checks a SigLevel such as "Required DatabaseOptional".
+func validateSigLevel(level string) error {
+	for _, field := range strings.Fields(level) {
+		option := strings.TrimPrefix(field, "Package")
+		if option == field {
+			option = strings.TrimPrefix(field, "Database")
+		}
+		if !containsString(sigLevels, option) {
+			return plugin.NewError(plugin.ErrInvalidParams, "Invalid SigLevel %q, expected %s, optionally prefixed with Package or Database", field, strings.Join(sigLevels, ", "))
+		}
+	}
+	return nil
+}
+
+// validValue reports whether a value can be written to pacman.conf as it
+// is.
+func validValue(v string) bool {
+	return strings.TrimSpace(v) == v && v != "" && !strings.ContainsAny(v, "#\n\r")
+}
+
+func readPacmanConf(profilePath string) (*pacmanConf, error) {
+	content, err := os.ReadFile(filepath.Join(profilePath, "pacman.conf"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pacman.conf: %w", err)
+	}
+	return parsePacmanConf(content), nil
+}
+
+func writePacmanConf(profilePath string, conf *pacmanConf) error {
+	if err := os.WriteFile(filepath.Join(profilePath, "pacman.conf"), conf.bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write pacman.conf: %w", err)
+	}
+	return nil
+}
+
+func reposResponse(conf *pacmanConf) plugin.ReposResponse {
+	repos := []plugin.Repo{}
+	for _, s := range conf.repos() {
+		repos = append(repos, s.repo())
+	}
+	return plugin.ReposResponse{Repos: repos}
+}
+
+// ListRepos implements plugin.RepoEditor.
+func (p *ArchPlugin) ListRepos(projectID string) (plugin.ReposResponse, error) {
+	conf, err := readPacmanConf(p.projectProfilePath(projectID))
+	if err != nil {
+		return plugin.ReposResponse{}, err
+	}
+	return reposResponse(conf), nil
+}
+
+// AddRepo implements plugin.RepoEditor.
+func (p *ArchPlugin) AddRepo(projectID string, repo plugin.Repo, position int) (plugin.ReposResponse, error) {
+	if err := validateRepo(repo); err != nil {
+		return plugin.ReposResponse{}, err
+	}
+	profilePath := p.projectProfilePath(projectID)
+	conf, err := readPacmanConf(profilePath)
+	if err != nil {
+		return plugin.ReposResponse{}, err
+	}
+	s := conf.section(repo.Name)
+	if s == nil {
+		s = &pacmanSection{name: repo.Name, lines: []string{"[" + repo.Name + "]"}}
+	}
+	s.setRepo(repo)
+
+	repos := conf.repos()
+	if i := sectionIndex(repos, s); i < 0 || position >= 0 {
+		if i >= 0 {
+			repos = append(repos[:i], repos[i+1:]...)
+		}
+		if position < 0 || position > len(repos) {
+			position = len(repos)
+		}
+		repos = append(repos[:position], append([]*pacmanSection{s}, repos[position:]...)...)
+		conf.setRepos(repos)
+	}
+	if err := writePacmanConf(profilePath, conf); err != nil {
+		return plugin.ReposResponse{}, err
+	}
+	return reposResponse(conf), nil
+}
+
+func sectionIndex(sections []*pacmanSection, s *pacmanSection) int {
+	for i, section := range sections {
+		if section == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// RemoveRepo implements plugin.RepoEditor.
+func (p *ArchPlugin) RemoveRepo(projectID string, name string) (plugin.ReposResponse, error) {
+	profilePath := p.projectProfilePath(projectID)
+	conf, err := readPacmanConf(profilePath)
+	if err != nil {
+		return plugin.ReposResponse{}, err
+	}
+	s := conf.section(name)
+	if s == nil || name == optionsSection {
+		err := plugin.NewError(plugin.ErrInvalidParams, "Repository '%s' not found", name)
+		err.Data = map[string]interface{}{"repos": conf.repoNames()}
+		return plugin.ReposResponse{}, err
+	}
+	i := sectionIndex(conf.sections, s)
+	conf.sections = append(conf.sections[:i], conf.sections[i+1:]...)
+	conf.setRepos(conf.repos())
+	if err := writePacmanConf(profilePath, conf); err != nil {
+		return plugin.ReposResponse{}, err
+	}
+	return reposResponse(conf), nil
+}
+
+// SetRepoOrder implements plugin.RepoEditor.
+func (p *ArchPlugin) SetRepoOrder(projectID string, names []string) (plugin.ReposResponse, error) {
+	profilePath := p.projectProfilePath(projectID)
+	conf, err := readPacmanConf(profilePath)
+	if err != nil {
+		return plugin.ReposResponse{}, err
+	}
+	current := conf.repoNames()
+	var repos []*pacmanSection
+	for _, name := range names {
+		s := conf.section(name)
+		if s == nil || name == optionsSection || sectionIndex(repos, s) >= 0 {
+			repos = nil
+			break
+		}
+		repos = append(repos, s)
+	}
+	if len(repos) != len(current) {
+		err := plugin.NewError(plugin.ErrInvalidParams, "The new order must list each repository once")
+		err.Data = map[string]interface{}{"repos": current}
+		return plugin.ReposResponse{}, err
+	}
+	conf.setRepos(repos)
+	if err := writePacmanConf(profilePath, conf); err != nil {
+		return plugin.ReposResponse{}, err
+	}
+	return reposResponse(conf), nil
+}
+
+// GetRepoOptions implements plugin.RepoEditor.
+func (p *ArchPlugin) GetRepoOptions(projectID string) (plugin.RepoOptions, error) {
+	conf, err := readPacmanConf(p.projectProfilePath(projectID))
+	if err != nil {
+		return plugin.RepoOptions{}, err
+	}
+	options := plugin.RepoOptions{IgnorePkg: []string{}, NoExtract: []string{}}
+	if s := conf.section(optionsSection); s != nil {
+		options.SigLevel = strings.Join(s.fields("SigLevel"), " ")
+		if values := s.values("ParallelDownloads"); len(values) > 0 {
+			options.ParallelDownloads, _ = strconv.Atoi(values[len(values)-1])
+		}
+		options.IgnorePkg = s.fields("IgnorePkg")
+		options.NoExtract = s.fields("NoExtract")
+	}
+	return options, nil
+}
+
+// SetRepoOptions implements plugin.RepoEditor.
+func (p *ArchPlugin) SetRepoOptions(projectID string, options plugin.RepoOptions) (plugin.RepoOptions, error) {
+	if err := validateSigLevel(options.SigLevel); err != nil {
+		return plugin.RepoOptions{}, err
+	}
+	if options.ParallelDownloads < 0 {
+		return plugin.RepoOptions{}, plugin.NewError(plugin.ErrInvalidParams, "Invalid ParallelDownloads %d, expected a positive number or 0 for the default", options.ParallelDownloads)
+	}
+	for _, list := range [][]string{options.IgnorePkg, options.NoExtract} {
+		for _, item := range list {
+			if !validValue(item) || strings.ContainsAny(item, " \t") {
+				return plugin.RepoOptions{}, plugin.NewError(plugin.ErrInvalidParams, "Invalid IgnorePkg or NoExtract entry %q", item)
+			}
+		}
+	}
+	profilePath := p.projectProfilePath(projectID)
+	conf, err := readPacmanConf(profilePath)
+	if err != nil {
+		return plugin.RepoOptions{}, err
+	}
+	s := conf.options()
+	if options.SigLevel != strings.Join(s.fields("SigLevel"), " ") {
+		s.set("SigLevel", options.SigLevel)
+	}
+	parallel := ""
+	if options.ParallelDownloads > 0 {
+		parallel = strconv.Itoa(options.ParallelDownloads)
+	}
+	s.set("ParallelDownloads", parallel)
+	if strings.Join(options.IgnorePkg, " ") != strings.Join(s.fields("IgnorePkg"), " ") {
+		s.set("IgnorePkg", strings.Join(options.IgnorePkg, " "))
+	}
+	if strings.Join(options.NoExtract, " ") != strings.Join(s.fields("NoExtract"), " ") {
+		s.set("NoExtract", strings.Join(options.NoExtract, " "))
+	}
+	if err := writePacmanConf(profilePath, conf); err != nil {
+		return plugin.RepoOptions{}, err
+	}
+	return p.GetRepoOptions(projectID)
+}
+
+var _ plugin.RepoEditor = (*ArchPlugin)(nil)
@@ -0,0 +1,139 @@
+package arch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+// hostRequirements are the commands an mkarchiso build runs on the host.
+var hostRequirements = []plugin.HostRequirement{
+	{Command: "mkarchiso", Package: "archiso", Privileged: true},
+	{Command: "pacman", Package: "pacman"},
+	{Command: "mksquashfs", Package: "squashfs-tools"},
+	{Command: "xorriso", Package: "libisoburn"},
+}
+
+// Rough disk space estimates. The work directory holds the installed
+// airootfs plus its compressed image; the ISO is mostly that image.
+const (
+	workBaseBytes       = 2 << 30
+	workBytesPerPackage = 20 << 20
+	isoBaseBytes        = 1 << 30
+	isoBytesPerPackage  = 5 << 20
+)
+
+// kernelPackages are the kernels mkarchiso can boot the live system with.
+var kernelPackages = []string{"linux", "linux-lts", "linux-zen", "linux-hardened", "linux-rt", "linux-rt-lts"}
+
+// requiredProfileVars are the profiledef.sh variables mkarchiso can't do without.
+var requiredProfileVars = []string{"iso_name", "iso_label", "iso_version", "install_dir", "bootmodes", "arch", "pacman_conf"}
+
+var (
+	installDirRe    = regexp.MustCompile(`^[a-z0-9]{1,8}$`)
+	supportedArches = map[string]bool{"x86_64": true, "i686": true, "aarch64": true}
+)
+
+// HostRequirements implements plugin.Preflighter.
+func (p *ArchPlugin) HostRequirements() []plugin.HostRequirement {
+	return hostRequirements
+}
+
+// Preflight implements plugin.Preflighter. It checks the host tools and
+// privileges, free space in the work and ISO roots, profiledef.sh, the
+// package list and local mirrors.
+func (p *ArchPlugin) Preflight(ctx context.Context, projectID string) ([]plugin.Finding, error) {
+	profilePath := p.projectProfilePath(projectID)
+	if _, err := os.Stat(profilePath); err != nil {
+		return nil, fmt.Errorf("project %s not found: %w", projectID, err)
+	}
+	packages, err := p.GetPackages(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	findings := plugin.CheckHostRequirements(ctx, p.runner, hostRequirements)
+	findings = append(findings, checkProfileDef(profilePath)...)
+	findings = append(findings, checkPackages(packages.Packages)...)
+	findings = append(findings, p.checkPackageNames(profilePath, packages.Packages)...)
+	findings = append(findings, checkMirrors(profilePath)...)
+
+	n := uint64(len(packages.Packages))
+	findings = append(findings, plugin.CheckFreeSpace([]plugin.SpaceNeed{
+		{Dir: p.workRoot, Bytes: workBaseBytes + n*workBytesPerPackage},
+		{Dir: p.isosRoot, Bytes: isoBaseBytes + n*isoBytesPerPackage},
+	})...)
+	return findings, nil
+}
+
+// checkProfileDef looks for the mistakes in profiledef.sh that make
+// mkarchiso fail only after it has started working.
+func checkProfileDef(profilePath string) []plugin.Finding {
+	profileError := func(format string, args ...interface{}) plugin.Finding {
+		return plugin.Finding{Check: "profiledef", Severity: plugin.SeverityError, Message: fmt.Sprintf(format, args...)}
+	}
+
+	content, err := os.ReadFile(filepath.Join(profilePath, "profiledef.sh"))
+	if err != nil {
+		return []plugin.Finding{profileError("Cannot read profiledef.sh: %v", err)}
+	}
+	def := parseProfileDef(content)
+	vars := def.variables()
+
+	var findings []plugin.Finding
+	for _, name := range requiredProfileVars {
+		if _, found := vars[name]; !found {
+			findings = append(findings, profileError("profiledef.sh does not set %s", name))
+		}
+	}
+	// Values computed by the shell can only be checked by mkarchiso itself.
+	if label, ok := def.literal("iso_label"); ok && len(label) > 32 {
+		findings = append(findings, profileError("iso_label %q is longer than 32 characters", label))
+	}
+	if dir, ok := def.literal("install_dir"); ok && !installDirRe.MatchString(dir) {
+		findings = append(findings, profileError("install_dir %q must be 1 to 8 lowercase letters or digits", dir))
+	}
+	if arch, ok := def.literal("arch"); ok && arch != "" && !supportedArches[arch] {
+		findings = append(findings, plugin.Finding{Check: "profiledef", Severity: plugin.SeverityWarning, Message: fmt.Sprintf("arch %q is not an architecture Arch Linux builds for", arch)})
+	}
+	if conf, ok := def.literal("pacman_conf"); ok && conf != "" {
+		if !filepath.IsAbs(conf) {
+			conf = filepath.Join(profilePath, conf)
+		}
+		if _, err := os.Stat(conf); err != nil {
+			findings = append(findings, profileError("pacman_conf %s does not exist", conf))
+		}
+	}
+	return findings
+}
+
+// checkPackages makes sure there is something to install, including a kernel.
+func checkPackages(packages []string) []plugin.Finding {
+	if len(packages) == 0 {
+		return []plugin.Finding{{
+			Check:    "packages",
+			Severity: plugin.SeverityError,
+			Message:  "The package list is empty",
+			Fix:      "Add packages with project.setPackages, at least base and a kernel such as linux",
+		}}
+	}
+	for _, pkg := range packages {
+		for _, kernel := range kernelPackages {
+			if pkg == kernel {
+				return nil
+			}
+		}
+	}
+	return []plugin.Finding{{
+		Check:    "packages",
+		Severity: plugin.SeverityWarning,
+		Message:  "The package list contains no kernel; the ISO won't boot",
+		Fix:      "Add a kernel package such as linux",
+	}}
+}
+
+var _ plugin.Preflighter = (*ArchPlugin)(nil)
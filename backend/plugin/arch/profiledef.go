@@ -0,0 +1,592 @@
+package arch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+// profileDef is a parsed profiledef.sh. The file is kept as a list of
+// chunks: variable assignments, which may span several lines, and
+// everything else, which is written back exactly as it was read. Only the
+// assignments that are changed are rewritten.
+type profileDef struct {
+	chunks []*profileChunk
+}
+
+// profileChunk is a piece of profiledef.sh made of whole lines.
+type profileChunk struct {
+	text   string             // as read, including the final newline
+	assign *profileAssignment // nil for anything but an assignment
+}
+
+// profileAssignment is a variable assignment such as name="value",
+// name=('a' 'b') or name=(["key"]="value").
+type profileAssignment struct {
+	name string
+	// value is a string, a []string or a profileAssoc.
+	value interface{}
+	// expr is set if the value uses shell expansions; value then holds the
+	// text as written, without quotes.
+	expr    bool
+	written string // the value as written
+	indent  string // whitespace before the name
+	trailer string // what follows the value on its last line, e.g. a comment
+}
+
+// profileAssoc is an associative array, in the order it was written.
+type profileAssoc []profilePair
+
+type profilePair struct{ key, value string }
+
+// toMap returns the associative array as a map.
+func (a profileAssoc) toMap() map[string]string {
+	m := make(map[string]string, len(a))
+	for _, p := range a {
+		m[p.key] = p.value
+	}
+	return m
+}
+
+var (
+	assignmentRe = regexp.MustCompile(`^([ \t]*)([A-Za-z_][A-Za-z0-9_]*)=`)
+	trailerRe    = regexp.MustCompile(`^[ \t]*;?[ \t]*(#.*)?$`)
+	errSyntax    = errors.New("unsupported shell syntax")
+)
+
+// parseProfileDef splits profiledef.sh into chunks. Lines that aren't
+// assignments it understands are kept as they are, so the file never loses
+// anything, even when it uses more of bash than variables and arrays.
+func parseProfileDef(content []byte) *profileDef {
+	text := string(content)
+	def := &profileDef{}
+	for pos := 0; pos < len(text); {
+		if a, end, err := parseAssignment(text, pos); err == nil {
+			next := lineEnd(text, end)
+			a.trailer = strings.TrimSuffix(text[end:next], "\n")
+			if trailerRe.MatchString(a.trailer) {
+				def.chunks = append(def.chunks, &profileChunk{text: text[pos:next], assign: a})
+				pos = next
+				continue
+			}
+		}
+		next := lineEnd(text, pos)
+		def.chunks = append(def.chunks, &profileChunk{text: text[pos:next]})
+		pos = next
+	}
+	return def
+}
+
+// lineEnd returns the position after the newline ending the line at pos.
+func lineEnd(text string, pos int) int {
+	if i := strings.IndexByte(text[pos:], '\n'); i >= 0 {
+		return pos + i + 1
+	}
+	return len(text)
+}
+
+// parseAssignment parses the assignment starting at pos and returns the
+// position after its value.
+func parseAssignment(text string, pos int) (*profileAssignment, int, error) {
+	m := assignmentRe.FindStringSubmatch(text[pos:])
+	if m == nil {
+		return nil, 0, errSyntax
+	}
+	a := &profileAssignment{name: m[2], indent: m[1]}
+	start := pos + len(m[0])
+	var end int
+	var err error
+	if start < len(text) && text[start] == '(' {
+		a.value, a.expr, end, err = parseArray(text, start)
+	} else {
+		a.value, a.expr, end, err = parseWord(text, start, false)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	a.written = text[start:end]
+	return a, end, nil
+}
+
+// parseArray parses a bash array, either indexed or associative, starting
+// at the opening parenthesis. Comments inside the array are skipped.
+func parseArray(text string, i int) (interface{}, bool, int, error) {
+	var list []string
+	var assoc profileAssoc
+	expr := false
+	for i++; ; {
+		for i < len(text) && strings.IndexByte(" \t\n", text[i]) >= 0 {
+			i++
+		}
+		if i == len(text) {
+			return nil, false, 0, errSyntax
+		}
+		switch text[i] {
+		case ')':
+			if assoc != nil {
+				return assoc, expr, i + 1, nil
+			}
+			if list == nil {
+				list = []string{}
+			}
+			return list, expr, i + 1, nil
+		case '#':
+			i = lineEnd(text, i)
+			continue
+		}
+
+		if text[i] == '[' {
+			keyEnd := strings.Index(text[i:], "]=")
+			if keyEnd < 0 || list != nil {
+				return nil, false, 0, errSyntax
+			}
+			keyEnd += i
+			key, keyExpr, n, err := parseWord(text[:keyEnd], i+1, false)
+			if err != nil || n != keyEnd || key == "" {
+				return nil, false, 0, errSyntax
+			}
+			value, valueExpr, end, err := parseWord(text, keyEnd+2, true)
+			if err != nil {
+				return nil, false, 0, err
+			}
+			assoc = append(assoc, profilePair{key, value})
+			expr = expr || keyExpr || valueExpr
+			i = end
+			continue
+		}
+		if assoc != nil {
+			return nil, false, 0, errSyntax
+		}
+		value, valueExpr, end, err := parseWord(text, i, true)
+		if err != nil {
+			return nil, false, 0, err
+		}
+		if end == i {
+			return nil, false, 0, errSyntax
+		}
+		list = append(list, value)
+		expr = expr || valueExpr
+		i = end
+	}
+}
+
+// parseWord parses a shell word starting at i: quoted and unquoted parts
+// up to unquoted whitespace. Expansions are kept as written and reported.
+func parseWord(text string, i int, inArray bool) (string, bool, int, error) {
+	var b strings.Builder
+	expr := false
+	for i < len(text) {
+		c := text[i]
+		switch {
+		case strings.IndexByte(" \t\n;&|<>", c) >= 0, c == ')' && inArray:
+			return b.String(), expr, i, nil
+		case c == '(' || c == ')':
+			return "", false, 0, errSyntax
+		case c == '\'':
+			n := strings.IndexByte(text[i+1:], '\'')
+			if n < 0 {
+				return "", false, 0, errSyntax
+			}
+			b.WriteString(text[i+1 : i+1+n])
+			i += n + 2
+		case c == '"':
+			for i++; ; {
+				if i >= len(text) {
+					return "", false, 0, errSyntax
+				}
+				c := text[i]
+				if c == '"' {
+					i++
+					break
+				}
+				switch {
+				case c == '\\' && i+1 < len(text) && strings.IndexByte("$`\"\\\n", text[i+1]) >= 0:
+					if text[i+1] != '\n' {
+						b.WriteByte(text[i+1])
+					}
+					i += 2
+				case c == '$' || c == '`':
+					end, err := skipExpansion(text, i)
+					if err != nil {
+						return "", false, 0, err
+					}
+					expr = expr || end > i+1
+					b.WriteString(text[i:end])
+					i = end
+				default:
+					b.WriteByte(c)
+					i++
+				}
+			}
+		case c == '\\':
+			if i+1 < len(text) && text[i+1] != '\n' {
+				b.WriteByte(text[i+1])
+			}
+			i += 2
+		case c == '$' || c == '`':
+			end, err := skipExpansion(text, i)
+			if err != nil {
+				return "", false, 0, err
+			}
+			expr = expr || end > i+1
+			b.WriteString(text[i:end])
+			i = end
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String(), expr, i, nil
+}
+
+// skipExpansion returns the position after the expansion at i: $name,
+// ${...}, $(...) or `...`. A lone $ is just a dollar sign.
+func skipExpansion(text string, i int) (int, error) {
+	if text[i] == '`' {
+		for j := i + 1; j < len(text); j++ {
+			switch text[j] {
+			case '\\':
+				j++
+			case '`':
+				return j + 1, nil
+			}
+		}
+		return 0, errSyntax
+	}
+	if i+1 == len(text) {
+		return i + 1, nil
+	}
+	switch c := text[i+1]; {
+	case c == '(' || c == '{':
+		return skipGroup(text, i+1)
+	case c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+		j := i + 2
+		for j < len(text) && (text[j] == '_' || isAlnum(text[j])) {
+			j++
+		}
+		return j, nil
+	case strings.IndexByte("0123456789@*#?$!-", c) >= 0:
+		return i + 2, nil
+	}
+	return i + 1, nil
+}
+
+// skipGroup returns the position after the parenthesis or brace group
+// opened at i, skipping quoted text and nested groups.
+func skipGroup(text string, i int) (int, error) {
+	open := text[i]
+	close := byte(')')
+	if open == '{' {
+		close = '}'
+	}
+	depth := 0
+	for j := i; j < len(text); j++ {
+		switch c := text[j]; c {
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				return j + 1, nil
+			}
+		case '\\':
+			j++
+		case '\'':
+			n := strings.IndexByte(text[j+1:], '\'')
+			if n < 0 {
+				return 0, errSyntax
+			}
+			j += n + 1
+		case '"':
+			end, err := skipDoubleQuoted(text, j)
+			if err != nil {
+				return 0, err
+			}
+			j = end - 1
+		case '$', '`':
+			end, err := skipExpansion(text, j)
+			if err != nil {
+				return 0, err
+			}
+			j = end - 1
+		}
+	}
+	return 0, errSyntax
+}
+
+// skipDoubleQuoted returns the position after the double-quoted string
+// starting at i.
+func skipDoubleQuoted(text string, i int) (int, error) {
+	for j := i + 1; j < len(text); j++ {
+		switch text[j] {
+		case '\\':
+			j++
+		case '"':
+			return j + 1, nil
+		case '$', '`':
+			end, err := skipExpansion(text, j)
+			if err != nil {
+				return 0, err
+			}
+			j = end - 1
+		}
+	}
+	return 0, errSyntax
+}
+
+func isAlnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
+
+// lookup returns the assignment that takes effect for a variable, which is
+// the last one, or nil.
+func (d *profileDef) lookup(name string) *profileAssignment {
+	for i := len(d.chunks) - 1; i >= 0; i-- {
+		if a := d.chunks[i].assign; a != nil && a.name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// variables returns the variables the file assigns, with the last
+// assignment of each.
+func (d *profileDef) variables() map[string]*profileAssignment {
+	vars := make(map[string]*profileAssignment)
+	for _, c := range d.chunks {
+		if c.assign != nil {
+			vars[c.assign.name] = c.assign
+		}
+	}
+	return vars
+}
+
+// literal returns the value of a variable assigned a plain string, or
+// false if it isn't assigned one.
+func (d *profileDef) literal(name string) (string, bool) {
+	a := d.lookup(name)
+	if a == nil || a.expr {
+		return "", false
+	}
+	s, ok := a.value.(string)
+	return s, ok
+}
+
+// set assigns value, a string, []string or map[string]string, to a
+// variable; nil removes all its assignments. Assignments whose value
+// doesn't change are left as written.
+func (d *profileDef) set(name string, value interface{}) {
+	if value == nil {
+		var kept []*profileChunk
+		for _, c := range d.chunks {
+			if c.assign == nil || c.assign.name != name {
+				kept = append(kept, c)
+			}
+		}
+		d.chunks = kept
+		return
+	}
+
+	a := d.lookup(name)
+	if m, ok := value.(map[string]string); ok {
+		var old profileAssoc
+		if a != nil {
+			old, _ = a.value.(profileAssoc)
+		}
+		value = orderAssoc(m, old)
+	}
+	if a != nil && reflect.DeepEqual(a.value, value) {
+		return
+	}
+
+	if a == nil {
+		a = &profileAssignment{name: name}
+		// Keep a final newline-less line intact.
+		if n := len(d.chunks); n > 0 && !strings.HasSuffix(d.chunks[n-1].text, "\n") {
+			d.chunks[n-1].text += "\n"
+		}
+		d.chunks = append(d.chunks, &profileChunk{assign: a})
+	}
+	a.value = value
+	a.expr = false
+	a.written = formatProfileValue(value, true)
+	for _, c := range d.chunks {
+		if c.assign == a {
+			c.text = a.indent + a.name + "=" + a.written + a.trailer + "\n"
+		}
+	}
+}
+
+// orderAssoc turns m into an associative array, keeping the keys in the
+// order of old and adding new keys sorted.
+func orderAssoc(m map[string]string, old profileAssoc) profileAssoc {
+	assoc := profileAssoc{}
+	seen := make(map[string]bool)
+	for _, p := range old {
+		if value, found := m[p.key]; found {
+			assoc = append(assoc, profilePair{p.key, value})
+			seen[p.key] = true
+		}
+	}
+	var added []string
+	for key := range m {
+		if !seen[key] {
+			added = append(added, key)
+		}
+	}
+	sort.Strings(added)
+	for _, key := range added {
+		assoc = append(assoc, profilePair{key, m[key]})
+	}
+	return assoc
+}
+
+// bytes returns the file's content.
+func (d *profileDef) bytes() []byte {
+	var b strings.Builder
+	for _, c := range d.chunks {
+		b.WriteString(c.text)
+	}
+	return []byte(b.String())
+}
+
+// formatProfileValue writes a value the way the releng profile does:
+// strings in double quotes, array elements in single quotes and
+// associative arrays one entry per line, unless multiline is false.
+func formatProfileValue(value interface{}, multiline bool) string {
+	switch v := value.(type) {
+	case string:
+		return doubleQuote(v)
+	case []string:
+		quoted := make([]string, len(v))
+		for i, s := range v {
+			quoted[i] = singleQuote(s)
+		}
+		return "(" + strings.Join(quoted, " ") + ")"
+	case profileAssoc:
+		entries := make([]string, len(v))
+		for i, p := range v {
+			entries[i] = fmt.Sprintf("[%s]=%s", doubleQuote(p.key), doubleQuote(p.value))
+		}
+		if !multiline || len(entries) == 0 {
+			return "(" + strings.Join(entries, " ") + ")"
+		}
+		return "(\n  " + strings.Join(entries, "\n  ") + "\n)"
+	}
+	panic(fmt.Sprintf("unexpected profiledef.sh value %T", value))
+}
+
+// setting describes a variable's value on one line, for plugin.Inputs.
+func (a *profileAssignment) setting() string {
+	if a.expr {
+		return strings.Join(strings.Fields(a.written), " ")
+	}
+	return formatProfileValue(a.value, false)
+}
+
+func doubleQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "`", "\\`")
+	return `"` + r.Replace(s) + `"`
+}
+
+func singleQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// readProfileDef parses the profiledef.sh of a profile.
+func readProfileDef(profilePath string) (*profileDef, error) {
+	content, err := os.ReadFile(filepath.Join(profilePath, "profiledef.sh"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profiledef.sh: %w", err)
+	}
+	return parseProfileDef(content), nil
+}
+
+// GetProfileDef implements plugin.ProfileDefEditor.
+func (p *ArchPlugin) GetProfileDef(projectID string) (plugin.ProfileDef, error) {
+	def, err := readProfileDef(p.projectProfilePath(projectID))
+	if err != nil {
+		return plugin.ProfileDef{}, err
+	}
+	result := plugin.ProfileDef{Variables: make(map[string]interface{}), Expressions: []string{}}
+	for name, a := range def.variables() {
+		if assoc, ok := a.value.(profileAssoc); ok {
+			result.Variables[name] = assoc.toMap()
+		} else {
+			result.Variables[name] = a.value
+		}
+		if a.expr {
+			result.Expressions = append(result.Expressions, name)
+		}
+	}
+	sort.Strings(result.Expressions)
+	return result, nil
+}
+
+// SetProfileDef implements plugin.ProfileDefEditor.
+func (p *ArchPlugin) SetProfileDef(projectID string, variables map[string]interface{}) (plugin.ProfileDef, error) {
+	profilePath := p.projectProfilePath(projectID)
+	def, err := readProfileDef(profilePath)
+	if err != nil {
+		return plugin.ProfileDef{}, err
+	}
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !profileNameRe.MatchString(name) {
+			return plugin.ProfileDef{}, plugin.NewError(plugin.ErrInvalidParams, "Invalid variable name %q", name)
+		}
+		value, ok := profileValue(variables[name])
+		if !ok {
+			return plugin.ProfileDef{}, plugin.NewError(plugin.ErrInvalidParams, "Invalid value for %s, expected a string, a list of strings, an object of strings or null", name)
+		}
+		def.set(name, value)
+	}
+	if err := os.WriteFile(filepath.Join(profilePath, "profiledef.sh"), def.bytes(), 0755); err != nil {
+		return plugin.ProfileDef{}, fmt.Errorf("failed to write profiledef.sh: %w", err)
+	}
+	return p.GetProfileDef(projectID)
+}
+
+var profileNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// profileValue converts a JSON value to a value for profileDef.set.
+func profileValue(v interface{}) (interface{}, bool) {
+	switch v := v.(type) {
+	case nil, string:
+		return v, true
+	case []interface{}:
+		list := make([]string, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			list[i] = s
+		}
+		return list, true
+	case map[string]interface{}:
+		m := make(map[string]string, len(v))
+		for key, item := range v {
+			s, ok := item.(string)
+			if !ok || key == "" {
+				return nil, false
+			}
+			m[key] = s
+		}
+		return m, true
+	}
+	return nil, false
+}
+
+var _ plugin.ProfileDefEditor = (*ArchPlugin)(nil)
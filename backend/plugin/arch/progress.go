@@ -0,0 +1,127 @@
+package arch
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+// mkarchisoStage maps a message mkarchiso logs when it enters a build step
+// to a stage name and the share of the overall progress the step covers.
+type mkarchisoStage struct {
+	marker     string
+	stage      string
+	start, end int
+}
+
+// mkarchisoStages lists the steps of an mkarchiso run in order. The
+// percentages are rough: package installation and compression dominate.
+var mkarchisoStages = []mkarchisoStage{
+	{"Validating options", "validating profile", 0, 2},
+	{"Installing packages to", "installing packages", 2, 55},
+	{"Copying custom airootfs files", "customizing airootfs", 55, 58},
+	{"Running customize_airootfs.sh", "customizing airootfs", 55, 58},
+	{"Creating version files", "customizing airootfs", 55, 58},
+	{"Setting up SYSLINUX", "setting up boot loaders", 58, 65},
+	{"Setting up GRUB", "setting up boot loaders", 58, 65},
+	{"Setting up systemd-boot", "setting up boot loaders", 58, 65},
+	{"Preparing kernel and initramfs", "setting up boot loaders", 58, 65},
+	{"Creating SquashFS image", "creating SquashFS image", 65, 90},
+	{"Creating EROFS image", "creating EROFS image", 65, 90},
+	{"Creating ext4 image", "creating ext4 image", 65, 90},
+	{"Creating checksum file", "creating checksums", 90, 91},
+	{"Creating ISO image", "creating ISO image", 91, 99},
+}
+
+var (
+	// pacman lists the transaction as "Packages (345) base-3-2 ...".
+	pacmanTotalRe = regexp.MustCompile(`^Packages \((\d+)\)`)
+	// Without a terminal pacman prints one line per download instead of progress bars.
+	pacmanDownloadRe = regexp.MustCompile(`^\s*downloading \S+`)
+	pacmanInstallRe  = regexp.MustCompile(`^\((\s*\d+)/(\d+)\) installing `)
+)
+
+// progressParser is an io.Writer that follows mkarchiso output and reports
+// the current stage and an overall percentage whenever either changes.
+// Within package installation, pacman's download and install counters move
+// the percentage: downloads cover the first half of the step, installs the second.
+type progressParser struct {
+	report  func(plugin.Progress)
+	partial []byte
+
+	stage      *mkarchisoStage
+	total      int // packages in the pacman transaction
+	downloaded int
+	installed  int
+	last       plugin.Progress
+}
+
+func newProgressParser(report func(plugin.Progress)) *progressParser {
+	return &progressParser{report: report}
+}
+
+func (p *progressParser) Write(b []byte) (int, error) {
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if i < 0 {
+			break
+		}
+		p.line(strings.TrimRight(string(p.partial[:i]), "\r"))
+		p.partial = p.partial[i+1:]
+	}
+	return len(b), nil
+}
+
+func (p *progressParser) line(line string) {
+	for i := range mkarchisoStages {
+		s := &mkarchisoStages[i]
+		// Stages only move forward; later messages repeating an earlier marker are ignored.
+		if strings.Contains(line, s.marker) && (p.stage == nil || s.start >= p.stage.start) {
+			if p.stage == nil || p.stage.stage != s.stage {
+				p.total, p.downloaded, p.installed = 0, 0, 0
+			}
+			p.stage = s
+			p.emit()
+			return
+		}
+	}
+	if p.stage == nil || p.stage.stage != "installing packages" {
+		return
+	}
+
+	if m := pacmanTotalRe.FindStringSubmatch(line); m != nil {
+		p.total, _ = strconv.Atoi(m[1])
+	} else if pacmanDownloadRe.MatchString(line) {
+		p.downloaded++
+	} else if m := pacmanInstallRe.FindStringSubmatch(line); m != nil {
+		p.installed, _ = strconv.Atoi(strings.TrimSpace(m[1]))
+		p.total, _ = strconv.Atoi(m[2])
+	} else {
+		return
+	}
+	p.emit()
+}
+
+// emit reports the current progress if it changed.
+func (p *progressParser) emit() {
+	percent := p.stage.start
+	if p.total > 0 {
+		// Cached packages aren't downloaded, so once pacman installs, the
+		// download half counts as done.
+		downloaded := min(p.downloaded, p.total)
+		if p.installed > 0 {
+			downloaded = p.total
+		}
+		done := float64(downloaded+p.installed) / float64(2*p.total)
+		percent += int(done * float64(p.stage.end-p.stage.start))
+	}
+	progress := plugin.Progress{Stage: p.stage.stage, Percent: min(percent, p.stage.end)}
+	if progress != p.last {
+		p.last = progress
+		p.report(progress)
+	}
+}
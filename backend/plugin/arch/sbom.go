@@ -0,0 +1,140 @@
+package arch
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+// localDBPattern matches pacman's database of the packages installed into
+// the airootfs, below a work directory, e.g. <work>/x86_64/airootfs/var/lib/pacman/local.
+const localDBPattern = "*/airootfs/var/lib/pacman/local"
+
+// readManifest reads the packages installed into the airootfs of a build
+// from pacman's local database in the work directory.
+func readManifest(workDir string) (plugin.Manifest, error) {
+	dbs, _ := filepath.Glob(filepath.Join(workDir, localDBPattern))
+	if len(dbs) != 1 {
+		return plugin.Manifest{}, fmt.Errorf("found %d package databases instead of one in %s", len(dbs), workDir)
+	}
+	entries, err := os.ReadDir(dbs[0])
+	if err != nil {
+		return plugin.Manifest{}, fmt.Errorf("failed to read package database: %w", err)
+	}
+
+	type installed struct {
+		plugin.ManifestPackage
+		depends  []string // as written by the packager, e.g. "glibc>=2.38" or "sh"
+		provides []string
+	}
+	var packages []installed
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue // ALPM_DB_VERSION
+		}
+		fields, err := readDesc(filepath.Join(dbs[0], entry.Name(), "desc"))
+		if err != nil {
+			return plugin.Manifest{}, err
+		}
+		pkg := installed{depends: fields["DEPENDS"], provides: fields["PROVIDES"]}
+		pkg.Name = first(fields["NAME"])
+		pkg.Version = first(fields["VERSION"])
+		pkg.Arch = first(fields["ARCH"])
+		pkg.Description = first(fields["DESC"])
+		pkg.URL = first(fields["URL"])
+		pkg.Licenses = fields["LICENSE"]
+		pkg.PURL = fmt.Sprintf("pkg:alpm/arch/%s@%s?arch=%s", pkg.Name, pkg.Version, pkg.Arch)
+		if pkg.Name == "" || pkg.Version == "" {
+			return plugin.Manifest{}, fmt.Errorf("package database entry %s has no name or version", entry.Name())
+		}
+		packages = append(packages, pkg)
+	}
+
+	// Dependencies may name a package or something packages provide.
+	providers := make(map[string]string)
+	for _, pkg := range packages {
+		for _, p := range pkg.provides {
+			providers[depName(p)] = pkg.Name
+		}
+	}
+	for _, pkg := range packages {
+		providers[pkg.Name] = pkg.Name
+	}
+
+	manifest := plugin.Manifest{Packages: make([]plugin.ManifestPackage, 0, len(packages))}
+	for _, pkg := range packages {
+		seen := make(map[string]bool)
+		for _, dep := range pkg.depends {
+			if name, found := providers[depName(dep)]; found && !seen[name] {
+				seen[name] = true
+				pkg.Depends = append(pkg.Depends, name)
+			}
+		}
+		sort.Strings(pkg.Depends)
+		manifest.Packages = append(manifest.Packages, pkg.ManifestPackage)
+	}
+	sort.Slice(manifest.Packages, func(i, j int) bool { return manifest.Packages[i].Name < manifest.Packages[j].Name })
+	return manifest, nil
+}
+
+// readDesc parses a desc file of pacman's local database: sections headed
+// by %NAME% lines, each holding one value per line up to an empty line.
+func readDesc(path string) (map[string][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package database: %w", err)
+	}
+	defer f.Close()
+	fields := make(map[string][]string)
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			section = ""
+		case section == "" && strings.HasPrefix(line, "%") && strings.HasSuffix(line, "%"):
+			section = strings.Trim(line, "%")
+		case section != "":
+			fields[section] = append(fields[section], line)
+		}
+	}
+	return fields, scanner.Err()
+}
+
+// depName strips the version constraint from a dependency or provision,
+// e.g. "glibc>=2.38" or "libc.so=6-64".
+func depName(dep string) string {
+	if i := strings.IndexAny(dep, "<>="); i >= 0 {
+		return dep[:i]
+	}
+	return dep
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// writeSBOM writes the manifest as a CycloneDX SBOM next to the ISO.
+func writeSBOM(manifest plugin.Manifest, iso plugin.Artifact, projectID, buildID string) (plugin.Artifact, error) {
+	content, err := plugin.CycloneDX(manifest, projectID, buildID)
+	if err != nil {
+		return plugin.Artifact{}, err
+	}
+	sbom := plugin.Artifact{Name: iso.Name + ".cdx.json", Kind: "sbom", Path: iso.Path + ".cdx.json", Size: int64(len(content))}
+	if iso.DownloadURL != "" {
+		sbom.DownloadURL = iso.DownloadURL + ".cdx.json"
+	}
+	if err := os.WriteFile(sbom.Path, content, 0644); err != nil {
+		return plugin.Artifact{}, fmt.Errorf("failed to write SBOM: %w", err)
+	}
+	return sbom, nil
+}
@@ -0,0 +1,372 @@
+package arch
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+// hostSyncDBDir is where pacman keeps the sync databases of the host.
+const hostSyncDBDir = "/var/lib/pacman/sync"
+
+// packageNameRe matches the names pacman allows for packages and groups.
+var packageNameRe = regexp.MustCompile(`^[a-z0-9@_+][a-z0-9@._+-]*$`)
+
+// maxSuggestions is how many similar names an unknown package gets.
+const maxSuggestions = 3
+
+// syncDB holds the names a pacman sync database knows.
+type syncDB struct {
+	modTime time.Time
+	size    int64
+	// names are the packages, what they provide and their groups.
+	names map[string]bool
+}
+
+// readSyncDB reads a sync database: a tar archive with a desc file for
+// each package, compressed with gzip or bzip2 or not at all. repo-add can
+// also use zstd and xz, which Go can't read without further packages.
+func readSyncDB(dbPath string) (*syncDB, error) {
+	f, err := os.Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(6)
+	var r io.Reader = br
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", dbPath, err)
+		}
+		defer gz.Close()
+		r = gz
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		r = bzip2.NewReader(br)
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return nil, fmt.Errorf("%s is compressed with zstd, which isn't supported", dbPath)
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0}):
+		return nil, fmt.Errorf("%s is compressed with xz, which isn't supported", dbPath)
+	}
+
+	db := &syncDB{modTime: info.ModTime(), size: info.Size(), names: make(map[string]bool)}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", dbPath, err)
+		}
+		// Older databases keep what a package provides in a depends file.
+		if base := path.Base(hdr.Name); base != "desc" && base != "depends" {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", dbPath, err)
+		}
+		section := ""
+		for _, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimSpace(line)
+			switch {
+			case line == "":
+				section = ""
+			case strings.HasPrefix(line, "%") && strings.HasSuffix(line, "%"):
+				section = line
+			case section == "%NAME%" || section == "%GROUPS%" || section == "%PROVIDES%":
+				db.names[stripVersion(line)] = true
+			}
+		}
+	}
+	return db, nil
+}
+
+// stripVersion removes a version constraint such as "=1.0" or ">=2" from a
+// package name.
+func stripVersion(name string) string {
+	if i := strings.IndexAny(name, "<>="); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// loadSyncDB returns a sync database, reading it again only when it has
+// changed.
+func (p *ArchPlugin) loadSyncDB(dbPath string) (*syncDB, error) {
+	info, err := os.Stat(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	p.syncDBsMu.Lock()
+	defer p.syncDBsMu.Unlock()
+	if db := p.syncDBs[dbPath]; db != nil && db.modTime.Equal(info.ModTime()) && db.size == info.Size() {
+		return db, nil
+	}
+	db, err := readSyncDB(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	p.syncDBs[dbPath] = db
+	return db, nil
+}
+
+// findSyncDB returns the sync database of a repository of the project.
+// Local mirrors have the database the build will use; otherwise it is
+// looked for in the DBPath of pacman.conf and in the host's.
+func (p *ArchPlugin) findSyncDB(profilePath string, conf *pacmanConf, repo *pacmanSection) (string, bool) {
+	arch := profileArch(profilePath)
+	servers := repo.values("Server")
+	if containsString(repo.values("Include"), hostMirrorlist) {
+		if mirrorlist, ok := projectMirrorlist(profilePath); ok {
+			if list, err := readMirrorlist(mirrorlist); err == nil && list != nil {
+				servers = append(servers, list.values("Server")...)
+			}
+		}
+	}
+	var candidates []string
+	for _, server := range servers {
+		if dir, ok := localMirrorDir(server, repo.name, arch); ok {
+			candidates = append(candidates, filepath.Join(dir, repo.name+".db"))
+		}
+	}
+	if options := conf.section(optionsSection); options != nil {
+		if dbPath := options.values("DBPath"); len(dbPath) > 0 {
+			candidates = append(candidates, filepath.Join(dbPath[len(dbPath)-1], "sync", repo.name+".db"))
+		}
+	}
+	candidates = append(candidates, filepath.Join(p.syncDBDir, repo.name+".db"))
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// projectSyncDBs returns the sync databases of all of the project's
+// repositories, by repository, or an error saying why one of them can't be
+// read. Names can only be checked with all of them, since a name may come
+// from any repository.
+func (p *ArchPlugin) projectSyncDBs(profilePath string) (map[string]*syncDB, error) {
+	conf, err := readPacmanConf(profilePath)
+	if err != nil {
+		return nil, err
+	}
+	dbs := make(map[string]*syncDB)
+	for _, repo := range conf.repos() {
+		dbPath, ok := p.findSyncDB(profilePath, conf, repo)
+		if !ok {
+			return nil, fmt.Errorf("no sync database for %s", repo.name)
+		}
+		db, err := p.loadSyncDB(dbPath)
+		if err != nil {
+			return nil, err
+		}
+		dbs[repo.name] = db
+	}
+	if len(dbs) == 0 {
+		return nil, fmt.Errorf("pacman.conf has no repositories")
+	}
+	return dbs, nil
+}
+
+// validatePackages checks package names: they must be valid names, and
+// packages, groups or provisions of the project's repositories. Names can
+// be given as repo/name, and with a version constraint. The repositories
+// are only checked if the sync databases of all of them can be read;
+// checkPackageNames warns about that before builds.
+func (p *ArchPlugin) validatePackages(profilePath string, packages []string) error {
+	var unknown []string
+	for _, pkg := range packages {
+		name := stripVersion(pkg)
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[i+1:]
+		}
+		if !packageNameRe.MatchString(name) && !containsString(unknown, pkg) {
+			unknown = append(unknown, pkg)
+		}
+	}
+
+	dbs, err := p.projectSyncDBs(profilePath)
+	if err != nil && len(packages) > 0 {
+		log.Printf("Not checking package names against the repositories: %v", err)
+	}
+	unknown = append(unknown, unknownPackages(dbs, packages, unknown)...)
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	suggestions := make(map[string][]string)
+	for _, pkg := range unknown {
+		if similar := similarNames(dbs, pkg); len(similar) > 0 {
+			suggestions[pkg] = similar
+		}
+	}
+	var perr *plugin.Error
+	if len(unknown) == 1 {
+		perr = plugin.NewError(plugin.ErrInvalidPackage, "Unknown package %s", unknown[0])
+	} else {
+		perr = plugin.NewError(plugin.ErrInvalidPackage, "Unknown packages %s", strings.Join(unknown, ", "))
+	}
+	perr.Data = map[string]interface{}{"unknown": unknown, "suggestions": suggestions}
+	return perr
+}
+
+// unknownPackages returns the packages the repositories don't have, except
+// those in skip.
+func unknownPackages(dbs map[string]*syncDB, packages, skip []string) []string {
+	if len(dbs) == 0 {
+		return nil
+	}
+	var unknown []string
+	for _, pkg := range packages {
+		if containsString(skip, pkg) || containsString(unknown, pkg) || knownPackage(dbs, pkg) {
+			continue
+		}
+		unknown = append(unknown, pkg)
+	}
+	return unknown
+}
+
+// checkPackageNames warns about packages of the list the repositories don't
+// have, e.g. after a package was renamed, and about package names that
+// can't be checked since a repository's sync database can't be read.
+func (p *ArchPlugin) checkPackageNames(profilePath string, packages []string) []plugin.Finding {
+	if len(packages) == 0 {
+		return nil
+	}
+	dbs, err := p.projectSyncDBs(profilePath)
+	if err != nil {
+		return []plugin.Finding{{
+			Check:    "packages",
+			Severity: plugin.SeverityWarning,
+			Message:  fmt.Sprintf("Package names were not checked against the repositories: %v", err),
+			Fix:      "Sync the build host's databases with pacman -Sy, or use a local mirror with the repositories' .db files",
+		}}
+	}
+	if unknown := unknownPackages(dbs, packages, nil); len(unknown) > 0 {
+		return []plugin.Finding{{
+			Check:    "packages",
+			Severity: plugin.SeverityWarning,
+			Message:  fmt.Sprintf("The repositories have no package %s", strings.Join(unknown, ", ")),
+			Fix:      "Remove or rename them with project.setPackages",
+		}}
+	}
+	return nil
+}
+
+// knownPackage looks a name up in the repository it names, or in all of
+// them.
+func knownPackage(dbs map[string]*syncDB, pkg string) bool {
+	name := stripVersion(pkg)
+	if i := strings.Index(name, "/"); i >= 0 {
+		db := dbs[name[:i]]
+		return db != nil && db.names[name[i+1:]]
+	}
+	for _, db := range dbs {
+		if db.names[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// similarNames returns the names closest to pkg by edit distance, for "did
+// you mean" suggestions. Names are compared in lowercase, since package
+// names can't have capitals.
+func similarNames(dbs map[string]*syncDB, pkg string) []string {
+	name := strings.ToLower(stripVersion(pkg))
+	if i := strings.Index(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	maxDistance := len(name) / 3
+	if maxDistance < 1 {
+		maxDistance = 1
+	} else if maxDistance > 3 {
+		maxDistance = 3
+	}
+
+	type candidate struct {
+		name     string
+		distance int
+	}
+	var candidates []candidate
+	seen := make(map[string]bool)
+	for _, db := range dbs {
+		for other := range db.names {
+			if seen[other] {
+				continue
+			}
+			seen[other] = true
+			if d := editDistance(name, other, maxDistance); d <= maxDistance {
+				candidates = append(candidates, candidate{other, d})
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].name < candidates[j].name
+	})
+	var names []string
+	for i := 0; i < len(candidates) && i < maxSuggestions; i++ {
+		names = append(names, candidates[i].name)
+	}
+	return names
+}
+
+// editDistance returns the Levenshtein distance of a and b, or max+1 once
+// it is known to be larger than max.
+func editDistance(a, b string, max int) int {
+	if d := len(a) - len(b); d > max || -d > max {
+		return max + 1
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		best := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if cur[j] < best {
+				best = cur[j]
+			}
+		}
+		if best > max {
+			return max + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
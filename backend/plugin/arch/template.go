@@ -0,0 +1,343 @@
+package arch
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+// archisoConfigsDir is where the archiso package installs its profiles.
+const archisoConfigsDir = "/usr/share/archiso/configs"
+
+// defaultTemplate is the profile CreateProject writes itself.
+const defaultTemplate = "default"
+
+// archisoDescriptions describe the profiles archiso ships.
+var archisoDescriptions = map[string]string{
+	"releng":   "The profile of the official Arch Linux installation image",
+	"baseline": "A minimal live system that boots to a shell",
+}
+
+// templateInfo holds what a user template says about itself.
+const templateInfo = "template.json"
+
+type templateMeta struct {
+	Description string     `json:"description"`
+	Version     int        `json:"version,omitempty"`
+	ProjectID   string     `json:"project_id,omitempty"` // the project it was saved from
+	SavedAt     *time.Time `json:"saved_at,omitempty"`
+}
+
+// Placeholders stand in for the values of the project a template was saved
+// from; projects created from the template get their own.
+const (
+	projectIDPlaceholder      = "{{project_id}}"
+	upperProjectIDPlaceholder = "{{PROJECT_ID}}"
+	hostnamePlaceholder       = "{{hostname}}"
+)
+
+// placeholderFiles are the files of a profile that may hold placeholders.
+var placeholderFiles = []string{"profiledef.sh", ".hostname", "airootfs/etc/hostname"}
+
+var (
+	templateNameRe    = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+	templateVersionRe = regexp.MustCompile(`^v([1-9][0-9]*)$`)
+)
+
+// ListTemplates implements plugin.TemplateProvider. Besides the default
+// profile, projects can start from the profiles of the installed archiso
+// package and from user templates below <data dir>/templates. A user
+// template is either an archiso profile in a directory of its own, or a
+// template saved by SaveAsTemplate, which keeps each version in a
+// directory v1, v2 and so on.
+func (p *ArchPlugin) ListTemplates() ([]plugin.Template, error) {
+	templates := []plugin.Template{{
+		Name:        defaultTemplate,
+		DistroID:    "arch",
+		Description: "A small profile with base, linux and the bootloader of your choice",
+		Source:      "builtin",
+	}}
+	seen := map[string]bool{defaultTemplate: true}
+	add := func(t plugin.Template, root string) {
+		if seen[t.Name] {
+			log.Printf("Ignoring template %s in %s: another template has that name", t.Name, root)
+			return
+		}
+		seen[t.Name] = true
+		templates = append(templates, t)
+	}
+
+	names, err := templateDirs(p.archisoConfigs)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if isProfileDir(filepath.Join(p.archisoConfigs, name)) {
+			add(plugin.Template{Name: name, DistroID: "arch", Description: archisoDescriptions[name], Source: "archiso"}, p.archisoConfigs)
+		}
+	}
+
+	names, err = templateDirs(p.templatesRoot)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		dir := filepath.Join(p.templatesRoot, name)
+		t := plugin.Template{Name: name, DistroID: "arch", Source: "user"}
+		if isProfileDir(dir) {
+			t.Description = readTemplateMeta(dir).Description
+			add(t, p.templatesRoot)
+			continue
+		}
+		if t.Versions = templateVersions(dir); len(t.Versions) > 0 {
+			t.Version = t.Versions[len(t.Versions)-1]
+			t.Description = readTemplateMeta(versionDir(dir, t.Version)).Description
+			add(t, p.templatesRoot)
+		}
+	}
+	return templates, nil
+}
+
+// templateDirs returns the sorted names of the directories below root. A
+// missing root has none.
+func templateDirs(root string) ([]string, error) {
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates in %s: %w", root, err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func isProfileDir(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "profiledef.sh"))
+	return err == nil
+}
+
+// templateVersions returns the versions of a saved template in ascending
+// order.
+func templateVersions(dir string) []int {
+	entries, _ := os.ReadDir(dir)
+	var versions []int
+	for _, entry := range entries {
+		m := templateVersionRe.FindStringSubmatch(entry.Name())
+		if m == nil || !entry.IsDir() || !isProfileDir(filepath.Join(dir, entry.Name())) {
+			continue
+		}
+		v, _ := strconv.Atoi(m[1])
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+func versionDir(dir string, version int) string {
+	return filepath.Join(dir, fmt.Sprintf("v%d", version))
+}
+
+func readTemplateMeta(dir string) templateMeta {
+	var meta templateMeta
+	if content, err := os.ReadFile(filepath.Join(dir, templateInfo)); err == nil {
+		if err := json.Unmarshal(content, &meta); err != nil {
+			log.Printf("Ignoring invalid %s: %v", filepath.Join(dir, templateInfo), err)
+		}
+	}
+	return meta
+}
+
+// templateDir returns the profile directory of a template other than the
+// default. Version 0 means the latest version of a saved template.
+func (p *ArchPlugin) templateDir(name string, version int) (string, error) {
+	templates, err := p.ListTemplates()
+	if err != nil {
+		return "", err
+	}
+	for _, t := range templates {
+		if t.Name != name || t.Name == defaultTemplate {
+			continue
+		}
+		switch {
+		case t.Source == "archiso" && version == 0:
+			return filepath.Join(p.archisoConfigs, name), nil
+		case t.Versions == nil && version == 0:
+			return filepath.Join(p.templatesRoot, name), nil
+		case version == 0:
+			return versionDir(filepath.Join(p.templatesRoot, name), t.Version), nil
+		case containsInt(t.Versions, version):
+			return versionDir(filepath.Join(p.templatesRoot, name), version), nil
+		}
+		return "", plugin.NewError(plugin.ErrInvalidParams, "Template '%s' has no version %d", name, version)
+	}
+	return "", plugin.NewError(plugin.ErrInvalidParams, "Template '%s' not found", name)
+}
+
+// createFromTemplate copies a template's profile into a new project. The
+// placeholders of saved templates are filled in for the project; the
+// images of other templates are named after the project, as CreateProject
+// does for the default profile.
+func (p *ArchPlugin) createFromTemplate(projectID, name string, version int) error {
+	dir, err := p.templateDir(name, version)
+	if err != nil {
+		return err
+	}
+	profilePath := p.projectProfilePath(projectID)
+	if err := plugin.CopyDir(dir, profilePath); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(profilePath, templateInfo))
+
+	content, err := os.ReadFile(filepath.Join(profilePath, "profiledef.sh"))
+	if err != nil {
+		return fmt.Errorf("failed to read profiledef.sh: %w", err)
+	}
+	if !strings.Contains(string(content), projectIDPlaceholder) && !strings.Contains(string(content), upperProjectIDPlaceholder) {
+		def := parseProfileDef(content)
+		def.set("iso_name", "archlinux-"+projectID)
+		def.set("iso_label", "ARCH_"+strings.ToUpper(projectID))
+		if err := os.WriteFile(filepath.Join(profilePath, "profiledef.sh"), def.bytes(), 0755); err != nil {
+			return fmt.Errorf("failed to write profiledef.sh: %w", err)
+		}
+	}
+	fill := strings.NewReplacer(projectIDPlaceholder, projectID, upperProjectIDPlaceholder, strings.ToUpper(projectID), hostnamePlaceholder, projectID)
+	return rewriteFiles(profilePath, placeholderFiles, fill.Replace)
+}
+
+// SaveAsTemplate implements plugin.TemplateSaver. It copies the project's
+// profile to a new version of the user template, replacing the image names
+// CreateProject derives from the project ID, and the hostname, with
+// placeholders.
+func (p *ArchPlugin) SaveAsTemplate(projectID, name, description string) (plugin.Template, error) {
+	if !templateNameRe.MatchString(name) || name == defaultTemplate {
+		return plugin.Template{}, plugin.NewError(plugin.ErrInvalidParams, "Invalid template name %q, expected lowercase letters, digits, dots, dashes and underscores", name)
+	}
+	p.templatesMu.Lock()
+	defer p.templatesMu.Unlock()
+	templates, err := p.ListTemplates()
+	if err != nil {
+		return plugin.Template{}, err
+	}
+	version := 1
+	for _, t := range templates {
+		if t.Name != name {
+			continue
+		}
+		if t.Versions == nil {
+			return plugin.Template{}, plugin.NewError(plugin.ErrInvalidParams, "Template '%s' is not a saved template and can't get new versions", name)
+		}
+		version = t.Version + 1
+	}
+	profilePath := p.projectProfilePath(projectID)
+	if _, err := os.Stat(profilePath); err != nil {
+		return plugin.Template{}, fmt.Errorf("project %s not found: %w", projectID, err)
+	}
+	hostname, err := p.GetHostname(projectID)
+	if err != nil {
+		return plugin.Template{}, err
+	}
+
+	dir := filepath.Join(p.templatesRoot, name)
+	tmp := filepath.Join(dir, fmt.Sprintf(".v%d.tmp", version))
+	os.RemoveAll(tmp)
+	if err := plugin.CopyDir(profilePath, tmp); err != nil {
+		return plugin.Template{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	// The ID appears in profiledef.sh as written by CreateProject, e.g.
+	// "archlinux-project-1" and "ARCH_PROJECT-1". Names the user chose are
+	// kept as they are.
+	def, err := readProfileDef(tmp)
+	if err != nil {
+		return plugin.Template{}, err
+	}
+	if s, ok := def.literal("iso_name"); ok && s == "archlinux-"+projectID {
+		def.set("iso_name", "archlinux-"+projectIDPlaceholder)
+	}
+	if s, ok := def.literal("iso_label"); ok && s == "ARCH_"+strings.ToUpper(projectID) {
+		def.set("iso_label", "ARCH_"+upperProjectIDPlaceholder)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "profiledef.sh"), def.bytes(), 0755); err != nil {
+		return plugin.Template{}, fmt.Errorf("failed to write profiledef.sh: %w", err)
+	}
+	if hostname.Hostname != "" {
+		err := rewriteFiles(tmp, []string{".hostname", "airootfs/etc/hostname"}, func(s string) string {
+			if strings.TrimSpace(s) != hostname.Hostname {
+				return s
+			}
+			return strings.Replace(s, hostname.Hostname, hostnamePlaceholder, 1)
+		})
+		if err != nil {
+			return plugin.Template{}, err
+		}
+	}
+
+	now := time.Now().UTC()
+	meta, err := json.MarshalIndent(templateMeta{Description: description, Version: version, ProjectID: projectID, SavedAt: &now}, "", "  ")
+	if err != nil {
+		return plugin.Template{}, err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, templateInfo), append(meta, '\n'), 0644); err != nil {
+		return plugin.Template{}, fmt.Errorf("failed to write %s: %w", templateInfo, err)
+	}
+	if err := os.Rename(tmp, versionDir(dir, version)); err != nil {
+		return plugin.Template{}, fmt.Errorf("failed to save template %s: %w", name, err)
+	}
+
+	versions := templateVersions(dir)
+	return plugin.Template{Name: name, DistroID: "arch", Description: description, Source: "user", Version: version, Versions: versions}, nil
+}
+
+// rewriteFiles passes the content of those of the given files the profile
+// has through edit.
+func rewriteFiles(profilePath string, files []string, edit func(string) string) error {
+	for _, file := range files {
+		path := filepath.Join(profilePath, filepath.FromSlash(file))
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) || err == nil && !info.Mode().IsRegular() {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if edited := edit(string(content)); edited != string(content) {
+			if err := os.WriteFile(path, []byte(edited), info.Mode().Perm()); err != nil {
+				return fmt.Errorf("failed to write %s: %w", file, err)
+			}
+		}
+	}
+	return nil
+}
+
+func containsInt(list []int, n int) bool {
+	for _, item := range list {
+		if item == n {
+			return true
+		}
+	}
+	return false
+}
+
+var _ plugin.TemplateProvider = (*ArchPlugin)(nil)
+var _ plugin.TemplateSaver = (*ArchPlugin)(nil)
@@ -0,0 +1,192 @@
+package arch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"example.com/jsonrpcengine/plugin"
+)
+
+// configStamp is kept in a work directory while a build of it hasn't
+// completed and holds the hash of the profile it was started with.
+const configStamp = ".distroforge-config-hash"
+
+// prepareWorkdir decides whether mkarchiso may reuse a project's work
+// directory. mkarchiso skips every step it has finished before in a work
+// directory, which only gives the right result when an earlier, unfinished
+// build of the same configuration is resumed. Anything else is removed.
+func (p *ArchPlugin) prepareWorkdir(ctx context.Context, workDir, configHash string, output io.Writer) error {
+	stamp, err := os.ReadFile(filepath.Join(workDir, configStamp))
+	switch {
+	case err == nil && string(stamp) == configHash:
+		fmt.Fprintf(output, "Resuming the unfinished build in %s\n", workDir)
+	case err == nil:
+		fmt.Fprintln(output, "The configuration changed since the last build")
+		fallthrough
+	default:
+		if _, err := p.removeWorkdir(ctx, workDir, output); err != nil {
+			return fmt.Errorf("failed to remove work directory: %w", err)
+		}
+	}
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", workDir, err)
+	}
+	return os.WriteFile(filepath.Join(workDir, configStamp), []byte(configHash), 0644)
+}
+
+// finishWorkdir marks a work directory as belonging to a completed build,
+// so that the next build starts from scratch.
+func finishWorkdir(workDir string) error {
+	if err := os.Remove(filepath.Join(workDir, configStamp)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// removeWorkdir unmounts whatever a killed build left mounted in workDir and
+// removes it. The files belong to root, so rm runs through the runner;
+// --one-file-system keeps it out of mounts that could not be unmounted.
+func (p *ArchPlugin) removeWorkdir(ctx context.Context, workDir string, output io.Writer) (int64, error) {
+	if _, err := os.Lstat(workDir); os.IsNotExist(err) {
+		return 0, nil
+	}
+	fmt.Fprintf(output, "Removing work directory %s\n", workDir)
+	p.unmountLeftovers(workDir, output)
+	size := plugin.DiskUsage(workDir)
+	if err := p.run(ctx, output, "rm", "-rf", "--one-file-system", "--", workDir); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// CleanWorkdir implements plugin.WorkdirCleaner.
+func (p *ArchPlugin) CleanWorkdir(ctx context.Context, projectID string) (int64, error) {
+	return p.removeWorkdir(ctx, filepath.Join(p.workRoot, projectID), io.Discard)
+}
+
+// CleanCache implements plugin.WorkdirCleaner. Like paccache -rk1, it keeps
+// only the most recent download of every package in the shared package cache.
+func (p *ArchPlugin) CleanCache(ctx context.Context) (int64, error) {
+	entries, err := os.ReadDir(p.cacheDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read package cache: %w", err)
+	}
+
+	type cached struct {
+		path string
+		mod  int64
+		size int64
+	}
+	newest := make(map[string]cached) // keyed by package name and architecture
+	var stale []cached
+	for _, entry := range entries {
+		key, ok := cachedPackageKey(entry.Name())
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		c := cached{path: filepath.Join(p.cacheDir, entry.Name()), mod: info.ModTime().UnixNano(), size: info.Size()}
+		if prev, found := newest[key]; found {
+			if prev.mod > c.mod {
+				prev, c = c, prev
+			}
+			stale = append(stale, prev)
+		}
+		newest[key] = c
+	}
+	if len(stale) == 0 {
+		return 0, nil
+	}
+
+	args := []string{"-f", "--"}
+	var reclaimed int64
+	for _, c := range stale {
+		args = append(args, c.path)
+		reclaimed += c.size
+		if info, err := os.Stat(c.path + ".sig"); err == nil {
+			args = append(args, c.path+".sig")
+			reclaimed += info.Size()
+		}
+	}
+	if err := p.run(ctx, io.Discard, "rm", args...); err != nil {
+		return 0, fmt.Errorf("failed to remove cached packages: %w", err)
+	}
+	return reclaimed, nil
+}
+
+// cachedPackageRe splits a package file name into name, version, release
+// and architecture, e.g. "linux-6.9.1.arch1-1-x86_64.pkg.tar.zst".
+var cachedPackageRe = regexp.MustCompile(`^(.+)-([^-]+)-([^-]+)-([^-]+)\.pkg\.tar(\.[a-z0-9]+)?$`)
+
+// cachedPackageKey returns the name and architecture of a cached package file.
+func cachedPackageKey(fileName string) (string, bool) {
+	m := cachedPackageRe.FindStringSubmatch(fileName)
+	if m == nil {
+		return "", false
+	}
+	return m[1] + " " + m[4], true
+}
+
+// archiveURL is the Arch Linux Archive's repository snapshot of a day.
+const archiveURL = "https://archive.archlinux.org/repos/%s/$repo/os/$arch"
+
+// archivedRepos are the official repositories the Arch Linux Archive has
+// snapshots of.
+var archivedRepos = []string{"core", "extra", "multilib", "core-testing", "extra-testing", "multilib-testing"}
+
+// writeBuildPacmanConf writes the pacman.conf a build uses to workDir: the
+// profile's own, with the shared package cache added unless the profile
+// sets a CacheDir itself. Packages downloaded for one project are then
+// reused by all others. Repositories that include the build host's
+// mirrorlist use the project's instead, if it has one. Reproducible builds
+// pinned to a snapshot get the official repositories, and any others that
+// use the mirrorlist, from the Arch Linux Archive; custom repositories keep
+// their servers, as the archive doesn't have them.
+func (p *ArchPlugin) writeBuildPacmanConf(profilePath, workDir string, r *plugin.Reproducible) (string, error) {
+	conf, err := readPacmanConf(profilePath)
+	if err != nil {
+		return "", err
+	}
+	options := conf.options()
+	if options.values("CacheDir") == nil {
+		options.prepend(fmt.Sprintf("CacheDir = %s/", p.cacheDir))
+	}
+	mirrorlist, hasMirrorlist := projectMirrorlist(profilePath)
+	if hasMirrorlist {
+		if abs, err := filepath.Abs(mirrorlist); err == nil {
+			mirrorlist = abs
+		}
+	}
+	if day, ok := r.SnapshotDate(); ok {
+		server := "Server = " + fmt.Sprintf(archiveURL, day.Format("2006/01/02"))
+		for _, s := range conf.repos() {
+			if containsString(archivedRepos, s.name) || containsString(s.values("Include"), hostMirrorlist) {
+				s.replace([]string{"Server", "Include"}, nil)
+				s.prepend(server)
+			}
+		}
+	}
+	if hasMirrorlist {
+		for _, s := range conf.repos() {
+			s.substitute("Include", hostMirrorlist, mirrorlist)
+		}
+	}
+
+	path := filepath.Join(workDir, "pacman.conf")
+	if err := os.WriteFile(path, conf.bytes(), 0644); err != nil {
+		return "", fmt.Errorf("failed to write pacman.conf for the build: %w", err)
+	}
+	return path, nil
+}
+
+var _ plugin.WorkdirCleaner = (*ArchPlugin)(nil)
@@ -0,0 +1,38 @@
+package plugin
+
+// BootMenuEditor is an optional interface for plugins that can customise
+// the boot menu of the images they build, independently of the bootloader.
+type BootMenuEditor interface {
+	GetBootMenu(projectID string) (BootMenu, error)
+	// SetBootMenu replaces the boot menu and regenerates the bootloader
+	// configuration from it. It returns the menu as stored, with defaults
+	// filled in.
+	SetBootMenu(projectID string, menu BootMenu) (BootMenu, error)
+}
+
+// BootMenu is the menu the image's bootloader shows.
+type BootMenu struct {
+	// Timeout is the number of seconds before the default entry boots. 0
+	// boots it right away, -1 waits for a choice.
+	Timeout int `json:"timeout"`
+	// Default is the ID of the entry booted when the timeout expires. It
+	// defaults to the first entry.
+	Default string `json:"default"`
+	// KernelParams are passed to the kernel by every entry.
+	KernelParams []string    `json:"kernel_params"`
+	Entries      []BootEntry `json:"entries"`
+}
+
+// BootEntry is an entry of a boot menu.
+type BootEntry struct {
+	// ID names the entry in the bootloader configuration: lowercase
+	// letters, digits and dashes.
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	// Kernel is the kernel package the entry boots; plugins pick their
+	// default kernel if it is empty.
+	Kernel string `json:"kernel,omitempty"`
+	// KernelParams are passed to the kernel after the menu's KernelParams,
+	// e.g. "nomodeset" for a safe graphics entry.
+	KernelParams []string `json:"kernel_params"`
+}
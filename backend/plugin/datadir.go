@@ -0,0 +1,31 @@
+package plugin
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// DefaultDataDir returns the directory DistroForge keeps its state in,
+// ~/.distroforge. If the home directory can't be determined it falls back
+// to a directory below the current working directory.
+func DefaultDataDir() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		log.Printf("Warning: Could not get user home directory (%v), using current directory for .distroforge_data", err)
+		// Get current working directory as fallback base
+		currentDir, cwdErr := os.Getwd()
+		if cwdErr != nil {
+			// This is a more serious fallback, unlikely to happen but possible
+			log.Printf("Critical: Could not get current working directory (%v), using \".\" as homeDir fallback", cwdErr)
+			homeDir = "."
+		} else {
+			homeDir = currentDir
+		}
+		// To avoid cluttering the current directory directly if it's a fallback,
+		// still use a subdirectory.
+		homeDir = filepath.Join(homeDir, ".distroforge_data_fallback")
+		log.Printf("Fallback data directory will be: %s", homeDir)
+	}
+	return filepath.Join(homeDir, ".distroforge")
+}
@@ -0,0 +1,24 @@
+package plugin
+
+import "context"
+
+// DiagnoseOptions controls a Diagnose run.
+type DiagnoseOptions struct {
+	// Fix asks the plugin to apply the fixes it can apply safely on its own.
+	Fix bool
+	// Building lists the projects with a running build. Their work
+	// directories are in use and must be left alone.
+	Building []string
+}
+
+// Diagnoser is an optional interface for plugins that take part in
+// engine.doctor, which checks the host and the engine's data directory.
+type Diagnoser interface {
+	// Diagnose checks the host requirements and the plugin's own state,
+	// e.g. for mounts a killed build left behind. Findings whose fix was
+	// applied are marked Fixed.
+	Diagnose(ctx context.Context, opts DiagnoseOptions) []Finding
+	// ProjectIDs lists the projects the plugin keeps data for, so that the
+	// engine can spot projects missing from its registry.
+	ProjectIDs() ([]string, error)
+}
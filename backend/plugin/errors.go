@@ -0,0 +1,32 @@
+package plugin
+
+import "fmt"
+
+// ErrorCode identifies the kind of failure a plugin reports back to the engine.
+// The engine maps each code onto a JSON-RPC error code; anything that isn't an
+// *Error is reported as an internal error.
+type ErrorCode int
+
+const (
+	ErrInternal ErrorCode = iota
+	ErrInvalidParams
+	ErrInvalidBootloader
+	ErrInvalidPackage
+)
+
+// Error is an application-level error returned by plugins.
+// Data, if set, is passed through to the JSON-RPC error object unchanged.
+type Error struct {
+	Code    ErrorCode
+	Message string
+	Data    interface{}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// NewError creates an *Error with a formatted message.
+func NewError(code ErrorCode, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,104 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// HashDir returns a SHA-256 over the relative paths, permissions and contents
+// of everything below dir, prefixed with "sha256:". Plugins use it as the
+// config snapshot hash of a project directory.
+func HashDir(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00", filepath.ToSlash(rel), info.Mode())
+
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			io.WriteString(h, target)
+		case d.Type().IsRegular():
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(h, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+		h.Write([]byte{0})
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", dir, err)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// HashFiles returns the files and symlinks below dir, keyed by their
+// slash-separated relative path, with their permissions and a SHA-256 of
+// their content, e.g. "-rw-r--r-- sha256:…". Symlinks map to
+// "Lrwxrwxrwx -> target".
+func HashFiles(dir string) (map[string]string, error) {
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			files[filepath.ToSlash(rel)] = fmt.Sprintf("%s -> %s", info.Mode(), target)
+		case d.Type().IsRegular():
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			h := sha256.New()
+			_, err = io.Copy(h, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			files[filepath.ToSlash(rel)] = fmt.Sprintf("%s sha256:%s", info.Mode(), hex.EncodeToString(h.Sum(nil)))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash %s: %w", dir, err)
+	}
+	return files, nil
+}
@@ -0,0 +1,146 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+)
+
+// Manifest lists the packages a build installed into the image, as found
+// in the image's package database after the build.
+type Manifest struct {
+	Packages []ManifestPackage `json:"packages"` // sorted by name
+}
+
+// ManifestPackage is an installed package.
+type ManifestPackage struct {
+	Name        string   `json:"name"`
+	Version     string   `json:"version"`
+	Arch        string   `json:"arch,omitempty"`
+	Description string   `json:"description,omitempty"`
+	URL         string   `json:"url,omitempty"`
+	Licenses    []string `json:"licenses,omitempty"`
+	// Depends names the installed packages this one depends on, with
+	// virtual dependencies resolved to the packages providing them.
+	Depends []string `json:"depends,omitempty"`
+	// PURL is the package URL identifying the package, e.g.
+	// "pkg:alpm/arch/linux@6.9.1.arch1-1?arch=x86_64".
+	PURL string `json:"purl,omitempty"`
+}
+
+// cycloneDX types, limited to the fields CycloneDX writes.
+type (
+	cdxBOM struct {
+		BOMFormat    string          `json:"bomFormat"`
+		SpecVersion  string          `json:"specVersion"`
+		SerialNumber string          `json:"serialNumber"`
+		Version      int             `json:"version"`
+		Metadata     cdxMetadata     `json:"metadata"`
+		Components   []cdxComponent  `json:"components"`
+		Dependencies []cdxDependency `json:"dependencies"`
+	}
+	cdxMetadata struct {
+		Tools     cdxTools     `json:"tools"`
+		Component cdxComponent `json:"component"`
+	}
+	cdxTools struct {
+		Components []cdxComponent `json:"components"`
+	}
+	cdxComponent struct {
+		Type               string        `json:"type"`
+		BOMRef             string        `json:"bom-ref,omitempty"`
+		Name               string        `json:"name"`
+		Version            string        `json:"version,omitempty"`
+		Description        string        `json:"description,omitempty"`
+		Licenses           []cdxLicense  `json:"licenses,omitempty"`
+		PURL               string        `json:"purl,omitempty"`
+		ExternalReferences []cdxExternal `json:"externalReferences,omitempty"`
+	}
+	cdxLicense struct {
+		License struct {
+			Name string `json:"name"`
+		} `json:"license"`
+	}
+	cdxExternal struct {
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	}
+	cdxDependency struct {
+		Ref       string   `json:"ref"`
+		DependsOn []string `json:"dependsOn"`
+	}
+)
+
+// CycloneDX renders a manifest as a CycloneDX 1.5 JSON SBOM describing the
+// image name in the given version, e.g. a project and a build ID. The
+// output only depends on its inputs, so rebuilding an image yields the
+// same SBOM.
+func CycloneDX(m Manifest, name, version string) ([]byte, error) {
+	image := cdxComponent{Type: "operating-system", BOMRef: name + "@" + version, Name: name, Version: version}
+	// The serial number is a name-based UUID (version 8, RFC 9562) of the image.
+	sum := sha256.Sum256([]byte(image.BOMRef))
+	u := sum[:16]
+	u[6] = u[6]&0x0f | 0x80
+	u[8] = u[8]&0x3f | 0x80
+	bom := cdxBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16]),
+		Version:      1,
+		Metadata: cdxMetadata{
+			Tools:     cdxTools{Components: []cdxComponent{{Type: "application", Name: "distroforge"}}},
+			Component: image,
+		},
+		Components:   []cdxComponent{},
+		Dependencies: []cdxDependency{},
+	}
+
+	refs := make(map[string]string, len(m.Packages))
+	for _, pkg := range m.Packages {
+		refs[pkg.Name] = pkg.PURL
+		if refs[pkg.Name] == "" {
+			refs[pkg.Name] = pkg.Name + "@" + pkg.Version
+		}
+	}
+	root := cdxDependency{Ref: image.BOMRef, DependsOn: []string{}}
+	for _, pkg := range m.Packages {
+		c := cdxComponent{
+			Type:        "library",
+			BOMRef:      refs[pkg.Name],
+			Name:        pkg.Name,
+			Version:     pkg.Version,
+			Description: pkg.Description,
+			PURL:        pkg.PURL,
+		}
+		for _, license := range pkg.Licenses {
+			var l cdxLicense
+			l.License.Name = license
+			c.Licenses = append(c.Licenses, l)
+		}
+		if pkg.URL != "" {
+			c.ExternalReferences = []cdxExternal{{Type: "website", URL: pkg.URL}}
+		}
+		bom.Components = append(bom.Components, c)
+
+		dep := cdxDependency{Ref: c.BOMRef, DependsOn: []string{}}
+		for _, name := range pkg.Depends {
+			if ref, found := refs[name]; found {
+				dep.DependsOn = append(dep.DependsOn, ref)
+			}
+		}
+		bom.Dependencies = append(bom.Dependencies, dep)
+		root.DependsOn = append(root.DependsOn, c.BOMRef)
+	}
+	bom.Dependencies = append([]cdxDependency{root}, bom.Dependencies...)
+	return json.MarshalIndent(bom, "", "  ")
+}
+
+// Inputs describes the configuration a build was made from.
+type Inputs struct {
+	// Settings maps the project's settings to their values, e.g.
+	// "bootloader" or "profiledef.iso_name". Plugins choose the keys.
+	Settings map[string]string `json:"settings"`
+	// Files maps the files the build copied into the image, keyed by their
+	// path in the project, to their HashFiles description.
+	Files map[string]string `json:"files"`
+}
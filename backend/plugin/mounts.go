@@ -0,0 +1,67 @@
+package plugin
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MountInfoPath lists the mounts visible to the engine process.
+const MountInfoPath = "/proc/self/mountinfo"
+
+// MountsBelow returns the mount points at or below dir listed in the
+// mountinfo file at mountInfo, deepest first so that they can be unmounted
+// in order. Build tools that are killed halfway leave such mounts behind.
+func MountsBelow(mountInfo string, dir string) ([]string, error) {
+	f, err := os.Open(mountInfo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mount table: %w", err)
+	}
+	defer f.Close()
+
+	dir = filepath.Clean(dir)
+	var mounts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Fields: mount ID, parent ID, major:minor, root, mount point, ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mountPoint := unescapeMountPath(fields[4])
+		if mountPoint == dir || strings.HasPrefix(mountPoint, dir+"/") {
+			mounts = append(mounts, mountPoint)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read mount table: %w", err)
+	}
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return strings.Count(mounts[i], "/") > strings.Count(mounts[j], "/")
+	})
+	return mounts, nil
+}
+
+// unescapeMountPath decodes the octal escapes (\040 for space, ...) the
+// kernel uses in mountinfo paths.
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -1,14 +1,22 @@
 package plugin
 
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+)
+
 // DetailsResponse represents the data returned by GetDetails.
 // This will be expanded based on API.md.
 type DetailsResponse struct {
-	ProjectID   string   `json:"project_id"`
-	DistroID    string   `json:"distro_id"`
-	Packages    []string `json:"packages"`
-	Bootloader  string   `json:"bootloader"`
-	Hostname    string   `json:"hostname"`
-	BuildStatus string   `json:"build_status"`
+	ProjectID  string   `json:"project_id"`
+	DistroID   string   `json:"distro_id"`
+	Packages   []string `json:"packages"`
+	Bootloader string   `json:"bootloader"`
+	Hostname   string   `json:"hostname"`
+	// BuildStatus is filled in by the engine from the project's latest build.
+	BuildStatus string `json:"build_status"`
 }
 
 // PackagesResponse represents the data returned by GetPackages.
@@ -26,11 +34,13 @@ type HostnameResponse struct {
 	Hostname string `json:"hostname"`
 }
 
-// BuildResponse represents the data returned by BuildISO.
+// BuildResponse represents the data returned by project.buildIso.
 // This will be expanded based on API.md.
 type BuildResponse struct {
 	BuildID string `json:"build_id"`
 	Status  string `json:"status"`
+	// Warnings are the preflight warnings the build was started with.
+	Warnings []Finding `json:"warnings,omitempty"`
 }
 
 // DistroPlugin defines the interface for distribution-specific operations.
@@ -50,9 +60,94 @@ type DistroPlugin interface {
 	GetBootloader(projectID string) (BootloaderResponse, error)
 	SetHostname(projectID string, hostname string) error
 	GetHostname(projectID string) (HostnameResponse, error)
-	BuildISO(projectID string) (BuildResponse, error)
-	StreamBuildOutput(projectID string, buildID string) (<-chan []byte, error) // Returns a channel for streaming output
-	GetBuildStatus(projectID string, buildID string) (BuildStatusResponse, error)
+	// BuildISO runs a build and blocks until it has finished. The engine calls
+	// it from its build queue, so a plugin never sees two concurrent builds of
+	// the same project, and keeps the build's status, log and history itself.
+	// The result should be filled in as far as the build got, even when an
+	// error is returned. Cancelling ctx must stop the build.
+	BuildISO(ctx context.Context, req BuildRequest) (BuildResult, error)
+}
+
+// BuildRequest describes a build the engine hands to a plugin.
+type BuildRequest struct {
+	ProjectID string
+	BuildID   string
+	// Output receives everything the build tools print; the engine stores it
+	// as the build log.
+	Output io.Writer
+	// Progress, if set, receives progress updates parsed from the build output.
+	Progress func(Progress)
+	// Reproducible, if set, asks for a build that can be repeated bit for bit.
+	Reproducible *Reproducible
+	// SaveConfigTo, if set, is a directory to save a copy of the configuration
+	// the build uses to, so that the build can be repeated later.
+	SaveConfigTo string
+	// ConfigFrom, if set, is the SaveConfigTo directory of an earlier build.
+	// The plugin builds the configuration saved there instead of the
+	// project's current one.
+	ConfigFrom string
+}
+
+// ReportProgress passes p to the request's Progress callback, if any.
+func (r BuildRequest) ReportProgress(p Progress) {
+	if r.Progress != nil {
+		r.Progress(p)
+	}
+}
+
+// Progress describes how far a running build has got.
+type Progress struct {
+	Stage   string `json:"stage"`
+	Percent int    `json:"progress"` // overall completion, 0-100
+}
+
+// BuildResult describes what a build ran with and what it produced.
+type BuildResult struct {
+	// ExitCode is the exit status of the build tool, if it was started.
+	ExitCode int
+	// ToolVersions maps the build tools involved to their installed versions.
+	ToolVersions map[string]string
+	// ConfigHash identifies the project configuration the build used, so that
+	// two builds can be compared without diffing their inputs.
+	ConfigHash string
+	Artifacts  []Artifact
+	// Manifest lists the packages installed into the image, if the plugin
+	// can tell. The engine keeps it with the build history.
+	Manifest *Manifest
+	// Inputs describes the configuration the build was made from, so that
+	// builds can be compared setting by setting. The engine keeps it with
+	// the build history.
+	Inputs *Inputs
+	// CommandLine and Environment record exactly how the build tool was invoked.
+	CommandLine []string
+	Environment []string
+}
+
+// Artifact is a file produced by a build. Plugins write the artifacts of a
+// build to a directory of their own named after the build ID; once the build
+// has finished, the engine owns them and deletes them according to its
+// retention rules, removing the directory when it is empty.
+type Artifact struct {
+	Name        string `json:"name"`
+	Kind        string `json:"kind"` // e.g. "iso"
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	DownloadURL string `json:"download_url,omitempty"`
+	// Checksums maps algorithms ("sha256", "sha512") to hex digests. The
+	// engine fills them in for ISOs.
+	Checksums map[string]string `json:"checksums,omitempty"`
+}
+
+// MethodHandler handles a plugin-specific RPC method for a single project.
+// params holds the full, raw params object of the request (including project_id).
+type MethodHandler func(projectID string, params json.RawMessage) (interface{}, error)
+
+// MethodProvider is an optional interface for plugins that expose extra RPC
+// methods which don't belong in DistroPlugin. The methods are served under the
+// plugin's registered ID as namespace, e.g. "arch.setMirrors".
+type MethodProvider interface {
+	// Methods returns the handlers keyed by method name (without namespace).
+	Methods() map[string]MethodHandler
 }
 
 // DistroDetails contains information about a distribution plugin.
@@ -62,13 +157,17 @@ type DistroDetails struct {
 	Description string `json:"description"`
 }
 
-// BuildStatusResponse represents the data returned by GetBuildStatus.
+// BuildStatusResponse represents the data returned by project.getBuildStatus.
 type BuildStatusResponse struct {
 	BuildID      string `json:"build_id"`
 	Status       string `json:"status"`
 	Progress     int    `json:"progress,omitempty"`
+	Stage        string `json:"stage,omitempty"` // the build step reached, e.g. "installing packages"
 	ErrorMessage string `json:"error_message,omitempty"`
 	DownloadURL  string `json:"download_url,omitempty"`
+	// CommandLine and Environment record exactly how the build tool was invoked.
+	CommandLine []string `json:"command_line,omitempty"`
+	Environment []string `json:"environment,omitempty"`
 }
 
 // PluginManager manages available distribution plugins.
@@ -95,7 +194,21 @@ func (pm *PluginManager) GetPlugin(id string) (DistroPlugin, bool) {
 	return plugin, found
 }
 
-// GetAvailablePlugins returns a list of details for all registered plugins.
+// GetMethod looks up a plugin-specific RPC method. namespace is the plugin ID.
+func (pm *PluginManager) GetMethod(namespace, method string) (MethodHandler, bool) {
+	p, found := pm.plugins[namespace]
+	if !found {
+		return nil, false
+	}
+	provider, ok := p.(MethodProvider)
+	if !ok {
+		return nil, false
+	}
+	handler, found := provider.Methods()[method]
+	return handler, found
+}
+
+// GetAvailablePlugins returns a list of details for all registered plugins, sorted by ID.
 func (pm *PluginManager) GetAvailablePlugins() []DistroDetails {
 	var details []DistroDetails
 	for _, p := range pm.plugins {
@@ -104,6 +217,7 @@ func (pm *PluginManager) GetAvailablePlugins() []DistroDetails {
 			details = append(details, d)
 		}
 	}
+	sort.Slice(details, func(i, j int) bool { return details[i].ID < details[j].ID })
 	return details
 }
 // TODO: Implement methods for loading plugins (e.g., from disk or compiled in).
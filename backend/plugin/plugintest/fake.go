@@ -0,0 +1,660 @@
+package plugintest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"example.com/jsonrpcengine/plugin"
+	"example.com/jsonrpcengine/plugin/runner"
+)
+
+// FakeBuildTool is the command FakePlugin runs for every build.
+const FakeBuildTool = "fake-build"
+
+// Disk space FakePlugin pretends each build uses.
+const (
+	FakeWorkdirSize = 1 << 20
+	FakeCacheSize   = 1 << 10
+	FakeISOSize     = 1 << 20
+)
+
+// FakePackageVersion is the version of every package FakePlugin installs.
+const FakePackageVersion = "1.0-1"
+
+var fakePackageNameRe = regexp.MustCompile(`^[a-z0-9@_+][a-z0-9@._+-]*$`)
+
+// FakePlugin is an in-memory plugin.DistroPlugin for engine tests. It also
+// implements plugin.MethodProvider with two methods, "echo" and "fail", and
+// plugin.Preflighter, which requires a non-empty package list and warns
+// about a missing hostname, plugin.Diagnoser, plugin.WorkdirCleaner and
+// plugin.TemplateProvider with one template, "desktop", whose projects
+// start out with xorg-server in addition to base, plugin.TemplateSaver,
+// whose templates keep each version's package list in memory, and
+// plugin.RepoEditor, with the repositories core and extra to start with.
+// SetPackages rejects added names pacman wouldn't allow with
+// plugin.ErrInvalidPackage, without suggestions.
+//
+// Every build leaves FakeWorkdirSize bytes of work directory behind for its
+// project and adds FakeCacheSize bytes to a shared cache, so that cleaning
+// up reports predictable numbers. Every build writes an ISO of FakeISOSize
+// zero bytes to <isoDir>/<project>/<build>/, with a manifest and SBOM
+// listing the project's packages in FakePackageVersion.
+//
+// Builds run FakeBuildTool through the runner, with SOURCE_DATE_EPOCH set
+// for reproducible builds. The project's settings are saved to and read
+// from the request's config directories as config.json.
+//
+// Failures can be injected per project: creating a project with the param
+// "fake_fail": ["getPackages", ...] makes those methods return an error.
+// Listing "createProject" makes project creation itself fail.
+type FakePlugin struct {
+	id     string
+	isoDir string
+	runner runner.Runner
+
+	mu        sync.Mutex
+	projects  map[string]*fakeProject
+	templates map[string]*fakeTemplate // saved templates
+	cache     int64                    // bytes in the shared cache
+}
+
+type fakeTemplate struct {
+	description string
+	versions    [][]string // the package list of each version
+}
+
+type fakeProject struct {
+	packages   []string
+	bootloader string
+	hostname   string
+	repos      []plugin.Repo
+	options    plugin.RepoOptions
+	fail       map[string]bool
+	workdir    int64 // bytes in the work directory
+}
+
+// fakeConfig is what a build of a fake project depends on. Builds save it
+// to plugin.BuildRequest.SaveConfigTo as config.json.
+type fakeConfig struct {
+	Packages   []string `json:"packages"`
+	Bootloader string   `json:"bootloader"`
+	Hostname   string   `json:"hostname"`
+}
+
+// NewFakePlugin creates a FakePlugin registered as distro id that writes
+// the ISOs of its builds below isoDir.
+func NewFakePlugin(id, isoDir string, r runner.Runner) *FakePlugin {
+	return &FakePlugin{id: id, isoDir: isoDir, runner: r, projects: make(map[string]*fakeProject), templates: make(map[string]*fakeTemplate)}
+}
+
+func (f *FakePlugin) GetDistroDetails() (plugin.DistroDetails, error) {
+	return plugin.DistroDetails{
+		ID:          f.id,
+		Name:        "Fake " + f.id,
+		Description: "In-memory plugin for tests.",
+	}, nil
+}
+
+func (f *FakePlugin) CreateProject(projectID string, params map[string]interface{}) error {
+	fail := make(map[string]bool)
+	if list, ok := params["fake_fail"].([]interface{}); ok {
+		for _, m := range list {
+			if name, ok := m.(string); ok {
+				fail[name] = true
+			}
+		}
+	}
+	if fail["createProject"] {
+		return fmt.Errorf("injected failure in createProject")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	packages := []string{"base"}
+	switch name, _ := params["template"].(string); name {
+	case "":
+	case "desktop":
+		packages = append(packages, "xorg-server")
+	default:
+		t, found := f.templates[name]
+		if !found {
+			return plugin.NewError(plugin.ErrInvalidParams, "Template '%v' not found", params["template"])
+		}
+		version := len(t.versions)
+		if v, ok := params["template_version"].(float64); ok && v != 0 {
+			version = int(v)
+		}
+		if version < 1 || version > len(t.versions) {
+			return plugin.NewError(plugin.ErrInvalidParams, "Template '%s' has no version %d", name, version)
+		}
+		packages = append([]string{}, t.versions[version-1]...)
+	}
+	f.projects[projectID] = &fakeProject{
+		packages:   packages,
+		bootloader: "fakeboot",
+		repos: []plugin.Repo{
+			{Name: "core", Servers: []string{}, Include: []string{"/etc/pacman.d/mirrorlist"}},
+			{Name: "extra", Servers: []string{}, Include: []string{"/etc/pacman.d/mirrorlist"}},
+		},
+		options: plugin.RepoOptions{SigLevel: "Required DatabaseOptional", IgnorePkg: []string{}, NoExtract: []string{}},
+		fail:    fail,
+	}
+	return nil
+}
+
+// project returns the project, or an error if it doesn't exist or if method
+// has been configured to fail for it. Callers must hold f.mu.
+func (f *FakePlugin) project(projectID, method string) (*fakeProject, error) {
+	proj, found := f.projects[projectID]
+	if !found {
+		return nil, fmt.Errorf("project %s not found", projectID)
+	}
+	if proj.fail[method] {
+		return nil, fmt.Errorf("injected failure in %s", method)
+	}
+	return proj, nil
+}
+
+// ListTemplates implements plugin.TemplateProvider.
+func (f *FakePlugin) ListTemplates() ([]plugin.Template, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	templates := []plugin.Template{{Name: "desktop", DistroID: f.id, Description: "base and xorg-server", Source: "builtin"}}
+	names := make([]string, 0, len(f.templates))
+	for name := range f.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		templates = append(templates, f.savedTemplate(name))
+	}
+	return templates, nil
+}
+
+// SaveAsTemplate implements plugin.TemplateSaver.
+func (f *FakePlugin) SaveAsTemplate(projectID, name, description string) (plugin.Template, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	proj, err := f.project(projectID, "saveAsTemplate")
+	if err != nil {
+		return plugin.Template{}, err
+	}
+	if name == "desktop" {
+		return plugin.Template{}, plugin.NewError(plugin.ErrInvalidParams, "Template '%s' is not a saved template and can't get new versions", name)
+	}
+	t, found := f.templates[name]
+	if !found {
+		t = &fakeTemplate{}
+		f.templates[name] = t
+	}
+	t.description = description
+	t.versions = append(t.versions, append([]string{}, proj.packages...))
+	return f.savedTemplate(name), nil
+}
+
+// savedTemplate describes a saved template. Callers must hold f.mu.
+func (f *FakePlugin) savedTemplate(name string) plugin.Template {
+	t := f.templates[name]
+	versions := make([]int, len(t.versions))
+	for i := range versions {
+		versions[i] = i + 1
+	}
+	return plugin.Template{Name: name, DistroID: f.id, Description: t.description, Source: "user", Version: len(versions), Versions: versions}
+}
+
+func (f *FakePlugin) GetDetails(projectID string) (plugin.DetailsResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	proj, err := f.project(projectID, "getDetails")
+	if err != nil {
+		return plugin.DetailsResponse{}, err
+	}
+	return plugin.DetailsResponse{
+		ProjectID:  projectID,
+		DistroID:   f.id,
+		Packages:   append([]string(nil), proj.packages...),
+		Bootloader: proj.bootloader,
+		Hostname:   proj.hostname,
+	}, nil
+}
+
+func (f *FakePlugin) SetPackages(projectID string, packages []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	proj, err := f.project(projectID, "setPackages")
+	if err != nil {
+		return err
+	}
+	current := make(map[string]bool)
+	for _, pkg := range proj.packages {
+		current[pkg] = true
+	}
+	var unknown []string
+	for _, pkg := range packages {
+		if !fakePackageNameRe.MatchString(pkg) && !current[pkg] {
+			unknown = append(unknown, pkg)
+		}
+	}
+	if len(unknown) > 0 {
+		perr := plugin.NewError(plugin.ErrInvalidPackage, "Unknown package %s", unknown[0])
+		if len(unknown) > 1 {
+			perr = plugin.NewError(plugin.ErrInvalidPackage, "Unknown packages %s", strings.Join(unknown, ", "))
+		}
+		perr.Data = map[string]interface{}{"unknown": unknown, "suggestions": map[string][]string{}}
+		return perr
+	}
+	proj.packages = append([]string(nil), packages...)
+	return nil
+}
+
+func (f *FakePlugin) GetPackages(projectID string) (plugin.PackagesResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	proj, err := f.project(projectID, "getPackages")
+	if err != nil {
+		return plugin.PackagesResponse{}, err
+	}
+	return plugin.PackagesResponse{Packages: append([]string{}, proj.packages...)}, nil
+}
+
+func (f *FakePlugin) SetBootloader(projectID string, bootloader string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	proj, err := f.project(projectID, "setBootloader")
+	if err != nil {
+		return err
+	}
+	proj.bootloader = bootloader
+	return nil
+}
+
+func (f *FakePlugin) GetBootloader(projectID string) (plugin.BootloaderResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	proj, err := f.project(projectID, "getBootloader")
+	if err != nil {
+		return plugin.BootloaderResponse{}, err
+	}
+	return plugin.BootloaderResponse{Bootloader: proj.bootloader}, nil
+}
+
+func (f *FakePlugin) SetHostname(projectID string, hostname string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	proj, err := f.project(projectID, "setHostname")
+	if err != nil {
+		return err
+	}
+	proj.hostname = hostname
+	return nil
+}
+
+func (f *FakePlugin) GetHostname(projectID string) (plugin.HostnameResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	proj, err := f.project(projectID, "getHostname")
+	if err != nil {
+		return plugin.HostnameResponse{}, err
+	}
+	return plugin.HostnameResponse{Hostname: proj.hostname}, nil
+}
+
+// ListRepos implements plugin.RepoEditor.
+func (f *FakePlugin) ListRepos(projectID string) (plugin.ReposResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	proj, err := f.project(projectID, "listRepos")
+	if err != nil {
+		return plugin.ReposResponse{}, err
+	}
+	return plugin.ReposResponse{Repos: append([]plugin.Repo{}, proj.repos...)}, nil
+}
+
+// AddRepo implements plugin.RepoEditor.
+func (f *FakePlugin) AddRepo(projectID string, repo plugin.Repo, position int) (plugin.ReposResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	proj, err := f.project(projectID, "addRepo")
+	if err != nil {
+		return plugin.ReposResponse{}, err
+	}
+	if repo.Name == "" || len(repo.Servers) == 0 && len(repo.Include) == 0 {
+		return plugin.ReposResponse{}, plugin.NewError(plugin.ErrInvalidParams, "Repository %q needs a name and a server or an Include", repo.Name)
+	}
+	if repo.Servers == nil {
+		repo.Servers = []string{}
+	}
+	if repo.Include == nil {
+		repo.Include = []string{}
+	}
+	i := fakeRepoIndex(proj.repos, repo.Name)
+	if i >= 0 && position < 0 {
+		proj.repos[i] = repo
+		return plugin.ReposResponse{Repos: append([]plugin.Repo{}, proj.repos...)}, nil
+	}
+	if i >= 0 {
+		proj.repos = append(proj.repos[:i], proj.repos[i+1:]...)
+	}
+	if position < 0 || position > len(proj.repos) {
+		position = len(proj.repos)
+	}
+	proj.repos = append(proj.repos[:position], append([]plugin.Repo{repo}, proj.repos[position:]...)...)
+	return plugin.ReposResponse{Repos: append([]plugin.Repo{}, proj.repos...)}, nil
+}
+
+// RemoveRepo implements plugin.RepoEditor.
+func (f *FakePlugin) RemoveRepo(projectID string, name string) (plugin.ReposResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	proj, err := f.project(projectID, "removeRepo")
+	if err != nil {
+		return plugin.ReposResponse{}, err
+	}
+	i := fakeRepoIndex(proj.repos, name)
+	if i < 0 {
+		err := plugin.NewError(plugin.ErrInvalidParams, "Repository '%s' not found", name)
+		err.Data = map[string]interface{}{"repos": fakeRepoNames(proj.repos)}
+		return plugin.ReposResponse{}, err
+	}
+	proj.repos = append(proj.repos[:i], proj.repos[i+1:]...)
+	return plugin.ReposResponse{Repos: append([]plugin.Repo{}, proj.repos...)}, nil
+}
+
+// SetRepoOrder implements plugin.RepoEditor.
+func (f *FakePlugin) SetRepoOrder(projectID string, names []string) (plugin.ReposResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	proj, err := f.project(projectID, "setRepoOrder")
+	if err != nil {
+		return plugin.ReposResponse{}, err
+	}
+	var repos []plugin.Repo
+	for _, name := range names {
+		i := fakeRepoIndex(proj.repos, name)
+		if i < 0 || fakeRepoIndex(repos, name) >= 0 {
+			break
+		}
+		repos = append(repos, proj.repos[i])
+	}
+	if len(repos) != len(names) || len(repos) != len(proj.repos) {
+		err := plugin.NewError(plugin.ErrInvalidParams, "The new order must list each repository once")
+		err.Data = map[string]interface{}{"repos": fakeRepoNames(proj.repos)}
+		return plugin.ReposResponse{}, err
+	}
+	proj.repos = repos
+	return plugin.ReposResponse{Repos: append([]plugin.Repo{}, proj.repos...)}, nil
+}
+
+// GetRepoOptions implements plugin.RepoEditor.
+func (f *FakePlugin) GetRepoOptions(projectID string) (plugin.RepoOptions, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	proj, err := f.project(projectID, "getRepoOptions")
+	if err != nil {
+		return plugin.RepoOptions{}, err
+	}
+	return proj.options, nil
+}
+
+// SetRepoOptions implements plugin.RepoEditor.
+func (f *FakePlugin) SetRepoOptions(projectID string, options plugin.RepoOptions) (plugin.RepoOptions, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	proj, err := f.project(projectID, "setRepoOptions")
+	if err != nil {
+		return plugin.RepoOptions{}, err
+	}
+	if options.ParallelDownloads < 0 {
+		return plugin.RepoOptions{}, plugin.NewError(plugin.ErrInvalidParams, "Invalid ParallelDownloads %d", options.ParallelDownloads)
+	}
+	if options.IgnorePkg == nil {
+		options.IgnorePkg = []string{}
+	}
+	if options.NoExtract == nil {
+		options.NoExtract = []string{}
+	}
+	proj.options = options
+	return proj.options, nil
+}
+
+func fakeRepoIndex(repos []plugin.Repo, name string) int {
+	for i, repo := range repos {
+		if repo.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func fakeRepoNames(repos []plugin.Repo) []string {
+	names := []string{}
+	for _, repo := range repos {
+		names = append(names, repo.Name)
+	}
+	return names
+}
+
+func (f *FakePlugin) BuildISO(ctx context.Context, req plugin.BuildRequest) (plugin.BuildResult, error) {
+	f.mu.Lock()
+	proj, err := f.project(req.ProjectID, "buildIso")
+	var result plugin.BuildResult
+	var cfg fakeConfig
+	if err == nil {
+		cfg = fakeConfig{Packages: append([]string(nil), proj.packages...), Bootloader: proj.bootloader, Hostname: proj.hostname}
+		proj.workdir = FakeWorkdirSize
+		f.cache += FakeCacheSize
+	}
+	f.mu.Unlock()
+	if err != nil {
+		return result, err
+	}
+	if req.ConfigFrom != "" {
+		data, err := os.ReadFile(filepath.Join(req.ConfigFrom, "config.json"))
+		if err != nil {
+			return result, err
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return result, err
+		}
+	}
+	if req.SaveConfigTo != "" {
+		data, err := json.Marshal(cfg)
+		if err != nil {
+			return result, err
+		}
+		if err := os.MkdirAll(req.SaveConfigTo, 0755); err != nil {
+			return result, err
+		}
+		if err := os.WriteFile(filepath.Join(req.SaveConfigTo, "config.json"), data, 0644); err != nil {
+			return result, err
+		}
+	}
+	packages := cfg.Packages
+	result.ConfigHash = cfg.hash()
+	result.Inputs = cfg.inputs()
+	result.ToolVersions = map[string]string{FakeBuildTool: "1.0"}
+	req.ReportProgress(plugin.Progress{Stage: "building", Percent: 50})
+
+	proc, err := f.runner.Start(ctx, runner.Command{
+		Name:   FakeBuildTool,
+		Args:   []string{req.ProjectID},
+		Env:    req.Reproducible.Env(),
+		Stdout: req.Output,
+		Stderr: req.Output,
+	})
+	if err != nil {
+		return result, fmt.Errorf("%s failed to start: %w", FakeBuildTool, err)
+	}
+	err = proc.Wait()
+
+	inv := proc.Invocation()
+	result.CommandLine = inv.Args
+	result.Environment = inv.Env
+	result.ExitCode = runner.ExitCode(err)
+	if err != nil {
+		return result, err
+	}
+	name := req.BuildID + ".iso"
+	path := filepath.Join(f.isoDir, req.ProjectID, req.BuildID, name)
+	if err := writeFakeISO(path); err != nil {
+		return result, err
+	}
+	iso := plugin.Artifact{
+		Name:        name,
+		Kind:        "iso",
+		Path:        path,
+		Size:        FakeISOSize,
+		DownloadURL: fmt.Sprintf("/isos/%s/%s/%s", req.ProjectID, req.BuildID, name),
+	}
+	manifest := plugin.Manifest{Packages: []plugin.ManifestPackage{}}
+	for _, pkg := range packages {
+		manifest.Packages = append(manifest.Packages, plugin.ManifestPackage{Name: pkg, Version: FakePackageVersion})
+	}
+	sort.Slice(manifest.Packages, func(i, j int) bool { return manifest.Packages[i].Name < manifest.Packages[j].Name })
+	sbom, err := plugin.CycloneDX(manifest, req.ProjectID, req.BuildID)
+	if err != nil {
+		return result, err
+	}
+	if err := os.WriteFile(path+".cdx.json", sbom, 0644); err != nil {
+		return result, err
+	}
+	result.Manifest = &manifest
+	result.Artifacts = []plugin.Artifact{iso, {
+		Name:        name + ".cdx.json",
+		Kind:        "sbom",
+		Path:        path + ".cdx.json",
+		Size:        int64(len(sbom)),
+		DownloadURL: iso.DownloadURL + ".cdx.json",
+	}}
+	return result, nil
+}
+
+// writeFakeISO creates a sparse file of FakeISOSize bytes at path.
+func writeFakeISO(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(FakeISOSize); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// HostRequirements implements plugin.Preflighter.
+func (f *FakePlugin) HostRequirements() []plugin.HostRequirement {
+	return []plugin.HostRequirement{{Command: FakeBuildTool, Package: "fake-tools"}}
+}
+
+// Preflight implements plugin.Preflighter.
+func (f *FakePlugin) Preflight(ctx context.Context, projectID string) ([]plugin.Finding, error) {
+	f.mu.Lock()
+	proj, err := f.project(projectID, "preflight")
+	var packages []string
+	var hostname string
+	if err == nil {
+		packages, hostname = proj.packages, proj.hostname
+	}
+	f.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	findings := plugin.CheckHostRequirements(ctx, f.runner, f.HostRequirements())
+	if len(packages) == 0 {
+		findings = append(findings, plugin.Finding{Check: "packages", Severity: plugin.SeverityError, Message: "The package list is empty"})
+	}
+	if hostname == "" {
+		findings = append(findings, plugin.Finding{Check: "hostname", Severity: plugin.SeverityWarning, Message: "No hostname set"})
+	}
+	return findings, nil
+}
+
+// Diagnose implements plugin.Diagnoser. Only the host requirements are checked.
+func (f *FakePlugin) Diagnose(ctx context.Context, opts plugin.DiagnoseOptions) []plugin.Finding {
+	return plugin.CheckHostRequirements(ctx, f.runner, f.HostRequirements())
+}
+
+// ProjectIDs implements plugin.Diagnoser.
+func (f *FakePlugin) ProjectIDs() ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := make([]string, 0, len(f.projects))
+	for id := range f.projects {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// CleanWorkdir implements plugin.WorkdirCleaner.
+func (f *FakePlugin) CleanWorkdir(ctx context.Context, projectID string) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	proj, err := f.project(projectID, "cleanWorkdir")
+	if err != nil {
+		return 0, err
+	}
+	reclaimed := proj.workdir
+	proj.workdir = 0
+	return reclaimed, nil
+}
+
+// CleanCache implements plugin.WorkdirCleaner.
+func (f *FakePlugin) CleanCache(ctx context.Context) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reclaimed := f.cache
+	f.cache = 0
+	return reclaimed, nil
+}
+
+// hash hashes the project settings a build depends on.
+func (c fakeConfig) hash() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%q %q %q", c.Packages, c.Bootloader, c.Hostname)))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// inputs describes the project settings like a plugin with an overlay
+// would: the hostname ends up in a file in the image.
+func (c fakeConfig) inputs() *plugin.Inputs {
+	hostname := sha256.Sum256([]byte(c.Hostname + "\n"))
+	return &plugin.Inputs{
+		Settings: map[string]string{"bootloader": c.Bootloader},
+		Files:    map[string]string{"airootfs/etc/hostname": "-rw-r--r-- sha256:" + hex.EncodeToString(hostname[:])},
+	}
+}
+
+// Methods implements plugin.MethodProvider.
+func (f *FakePlugin) Methods() map[string]plugin.MethodHandler {
+	return map[string]plugin.MethodHandler{
+		"echo": func(projectID string, params json.RawMessage) (interface{}, error) {
+			return map[string]interface{}{"project_id": projectID, "params": params}, nil
+		},
+		"fail": func(projectID string, params json.RawMessage) (interface{}, error) {
+			return nil, &plugin.Error{Code: plugin.ErrInvalidParams, Message: "fake rejected params", Data: params}
+		},
+	}
+}
+
+var _ plugin.DistroPlugin = (*FakePlugin)(nil)
+var _ plugin.MethodProvider = (*FakePlugin)(nil)
+var _ plugin.Preflighter = (*FakePlugin)(nil)
+var _ plugin.Diagnoser = (*FakePlugin)(nil)
+var _ plugin.WorkdirCleaner = (*FakePlugin)(nil)
+var _ plugin.TemplateProvider = (*FakePlugin)(nil)
+var _ plugin.TemplateSaver = (*FakePlugin)(nil)
+var _ plugin.RepoEditor = (*FakePlugin)(nil)
@@ -0,0 +1,28 @@
+package plugintest
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"example.com/jsonrpcengine/plugin"
+	"example.com/jsonrpcengine/plugin/runner"
+)
+
+// The fake plugin has to pass the same suite as real plugins, otherwise engine
+// tests built on it would prove nothing.
+func TestFakePluginConformance(t *testing.T) {
+	Run(t, Config{
+		New: func(t *testing.T, dataDir string, r runner.Runner) plugin.DistroPlugin {
+			return NewFakePlugin("fake", filepath.Join(dataDir, "isos"), r)
+		},
+		FakeTools: map[string]runner.FakeFunc{
+			FakeBuildTool: func(ctx context.Context, cmd runner.Command) error {
+				fmt.Fprintf(cmd.Stdout, "building %s\n", cmd.Args[0])
+				return nil
+			},
+		},
+		Bootloaders: []string{"fakeboot", "otherboot"},
+	})
+}
@@ -0,0 +1,281 @@
+// Package plugintest provides a conformance test suite for plugin.DistroPlugin
+// implementations. A plugin's own tests call Run with a constructor that builds
+// the plugin on top of a temporary data directory:
+//
+//	func TestConformance(t *testing.T) {
+//		plugintest.Run(t, plugintest.Config{
+//			New: func(t *testing.T, dataDir string, r runner.Runner) plugin.DistroPlugin { ... },
+//			FakeTools: map[string]runner.FakeFunc{"mkarchiso": fakeMkarchiso},
+//		})
+//	}
+//
+// The suite never runs the real build tool: plugins are handed a runner.Fake
+// that dispatches every command to the scripts in Config.FakeTools.
+package plugintest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"example.com/jsonrpcengine/plugin"
+	"example.com/jsonrpcengine/plugin/runner"
+)
+
+// Config describes the plugin under test.
+type Config struct {
+	// New returns a fresh plugin instance that stores all of its state below
+	// dataDir and runs every external command through r.
+	New func(t *testing.T, dataDir string, r runner.Runner) plugin.DistroPlugin
+
+	// FakeTools scripts the external commands the plugin runs. A build tool
+	// should write some output and produce whatever artifact the plugin expects.
+	FakeTools map[string]runner.FakeFunc
+
+	// Bootloaders lists bootloader values the plugin accepts. The first one is
+	// used for the round-trip test. If empty, the bootloader test is skipped.
+	Bootloaders []string
+}
+
+// Run executes the conformance suite against the plugin described by cfg.
+func Run(t *testing.T, cfg Config) {
+	if cfg.New == nil {
+		t.Fatal("plugintest: Config.New is required")
+	}
+
+	t.Run("DistroDetails", func(t *testing.T) { testDistroDetails(t, cfg) })
+	t.Run("CreateProject", func(t *testing.T) { testCreateProject(t, cfg) })
+	t.Run("Packages", func(t *testing.T) { testPackages(t, cfg) })
+	t.Run("Hostname", func(t *testing.T) { testHostname(t, cfg) })
+	t.Run("Bootloader", func(t *testing.T) { testBootloader(t, cfg) })
+	t.Run("ProjectIsolation", func(t *testing.T) { testProjectIsolation(t, cfg) })
+	t.Run("Build", func(t *testing.T) { testBuild(t, cfg) })
+	t.Run("ConfigHash", func(t *testing.T) { testConfigHash(t, cfg) })
+}
+
+// newPlugin creates a fresh plugin on an empty data dir, wired to a fake runner.
+func newPlugin(t *testing.T, cfg Config) (plugin.DistroPlugin, *runner.Fake) {
+	t.Helper()
+	fake := runner.NewFake(cfg.FakeTools)
+	p := cfg.New(t, t.TempDir(), fake)
+	if p == nil {
+		t.Fatal("Config.New returned a nil plugin")
+	}
+	return p, fake
+}
+
+// projectID derives a project ID from the test name so that plugins keeping
+// process-wide state never see the same ID twice.
+func projectID(t *testing.T, suffix string) string {
+	name := strings.NewReplacer("/", "-", " ", "-").Replace(t.Name())
+	return strings.ToLower(name + "-" + suffix)
+}
+
+// createProject creates a project and fails the test on error.
+func createProject(t *testing.T, p plugin.DistroPlugin, id string) {
+	t.Helper()
+	if err := p.CreateProject(id, map[string]interface{}{}); err != nil {
+		t.Fatalf("CreateProject(%q) failed: %v", id, err)
+	}
+}
+
+func testDistroDetails(t *testing.T, cfg Config) {
+	p, _ := newPlugin(t, cfg)
+	details, err := p.GetDistroDetails()
+	if err != nil {
+		t.Fatalf("GetDistroDetails failed: %v", err)
+	}
+	if details.ID == "" || details.Name == "" {
+		t.Errorf("GetDistroDetails returned incomplete details: %+v", details)
+	}
+}
+
+func testCreateProject(t *testing.T, cfg Config) {
+	p, _ := newPlugin(t, cfg)
+	id := projectID(t, "p")
+	createProject(t, p, id)
+
+	details, err := p.GetDetails(id)
+	if err != nil {
+		t.Fatalf("GetDetails failed: %v", err)
+	}
+	distro, _ := p.GetDistroDetails()
+	if details.ProjectID != id {
+		t.Errorf("GetDetails project_id = %q, want %q", details.ProjectID, id)
+	}
+	if details.DistroID != distro.ID {
+		t.Errorf("GetDetails distro_id = %q, want %q", details.DistroID, distro.ID)
+	}
+	if len(details.Packages) == 0 {
+		t.Errorf("a new project should come with a default package list")
+	}
+
+	if _, err := p.GetDetails(projectID(t, "missing")); err == nil {
+		t.Errorf("GetDetails for a project that was never created should fail")
+	}
+}
+
+func testPackages(t *testing.T, cfg Config) {
+	p, _ := newPlugin(t, cfg)
+	id := projectID(t, "p")
+	createProject(t, p, id)
+
+	want := []string{"base", "linux", "vim", "openssh"}
+	if err := p.SetPackages(id, want); err != nil {
+		t.Fatalf("SetPackages failed: %v", err)
+	}
+	got, err := p.GetPackages(id)
+	if err != nil {
+		t.Fatalf("GetPackages failed: %v", err)
+	}
+	if !reflect.DeepEqual(got.Packages, want) {
+		t.Errorf("GetPackages = %v, want %v", got.Packages, want)
+	}
+
+	details, err := p.GetDetails(id)
+	if err != nil {
+		t.Fatalf("GetDetails failed: %v", err)
+	}
+	if !reflect.DeepEqual(details.Packages, want) {
+		t.Errorf("GetDetails packages = %v, want %v", details.Packages, want)
+	}
+}
+
+func testHostname(t *testing.T, cfg Config) {
+	p, _ := newPlugin(t, cfg)
+	id := projectID(t, "p")
+	createProject(t, p, id)
+
+	for _, want := range []string{"forge-box", "other-name"} {
+		if err := p.SetHostname(id, want); err != nil {
+			t.Fatalf("SetHostname(%q) failed: %v", want, err)
+		}
+		got, err := p.GetHostname(id)
+		if err != nil {
+			t.Fatalf("GetHostname failed: %v", err)
+		}
+		if got.Hostname != want {
+			t.Errorf("GetHostname = %q, want %q", got.Hostname, want)
+		}
+	}
+}
+
+func testBootloader(t *testing.T, cfg Config) {
+	if len(cfg.Bootloaders) == 0 {
+		t.Skip("no bootloaders configured")
+	}
+	p, _ := newPlugin(t, cfg)
+	id := projectID(t, "p")
+	createProject(t, p, id)
+
+	for _, want := range cfg.Bootloaders {
+		if err := p.SetBootloader(id, want); err != nil {
+			t.Fatalf("SetBootloader(%q) failed: %v", want, err)
+		}
+		got, err := p.GetBootloader(id)
+		if err != nil {
+			t.Fatalf("GetBootloader failed: %v", err)
+		}
+		if got.Bootloader != want {
+			t.Errorf("GetBootloader = %q, want %q", got.Bootloader, want)
+		}
+	}
+}
+
+func testProjectIsolation(t *testing.T, cfg Config) {
+	p, _ := newPlugin(t, cfg)
+	a, b := projectID(t, "a"), projectID(t, "b")
+	createProject(t, p, a)
+	createProject(t, p, b)
+
+	if err := p.SetPackages(a, []string{"only-in-a"}); err != nil {
+		t.Fatalf("SetPackages failed: %v", err)
+	}
+	if err := p.SetHostname(a, "host-a"); err != nil {
+		t.Fatalf("SetHostname failed: %v", err)
+	}
+
+	pkgs, err := p.GetPackages(b)
+	if err != nil {
+		t.Fatalf("GetPackages failed: %v", err)
+	}
+	for _, pkg := range pkgs.Packages {
+		if pkg == "only-in-a" {
+			t.Errorf("packages of project %s leaked into project %s", a, b)
+		}
+	}
+	hostname, err := p.GetHostname(b)
+	if err != nil {
+		t.Fatalf("GetHostname failed: %v", err)
+	}
+	if hostname.Hostname == "host-a" {
+		t.Errorf("hostname of project %s leaked into project %s", a, b)
+	}
+}
+
+func testBuild(t *testing.T, cfg Config) {
+	p, fake := newPlugin(t, cfg)
+	id := projectID(t, "p")
+	createProject(t, p, id)
+
+	var output bytes.Buffer
+	result, err := p.BuildISO(context.Background(), plugin.BuildRequest{ProjectID: id, BuildID: id + "-1", Output: &output})
+	if err != nil {
+		t.Fatalf("BuildISO failed: %v", err)
+	}
+
+	if result.ExitCode != 0 {
+		t.Errorf("successful build reports exit code %d", result.ExitCode)
+	}
+	if result.ConfigHash == "" {
+		t.Errorf("build result has no config hash")
+	}
+	if len(result.Artifacts) == 0 {
+		t.Errorf("successful build produced no artifacts")
+	}
+	for _, a := range result.Artifacts {
+		if a.Name == "" || a.Kind == "" || a.Path == "" {
+			t.Errorf("incomplete artifact: %+v", a)
+		}
+	}
+	if len(fake.Calls()) == 0 {
+		t.Errorf("build finished without running any command")
+	}
+	if len(result.CommandLine) == 0 {
+		t.Errorf("build result does not record the command line it used")
+	}
+	if output.Len() == 0 {
+		t.Errorf("build wrote no output")
+	}
+}
+
+func testConfigHash(t *testing.T, cfg Config) {
+	p, _ := newPlugin(t, cfg)
+	id := projectID(t, "p")
+	createProject(t, p, id)
+
+	build := func(n int) string {
+		t.Helper()
+		req := plugin.BuildRequest{ProjectID: id, BuildID: fmt.Sprintf("%s-%d", id, n), Output: io.Discard}
+		result, err := p.BuildISO(context.Background(), req)
+		if err != nil {
+			t.Fatalf("BuildISO failed: %v", err)
+		}
+		return result.ConfigHash
+	}
+
+	first, second := build(1), build(2)
+	if first != second {
+		t.Errorf("config hash changed between builds of an unchanged project: %s, then %s", first, second)
+	}
+	if err := p.SetPackages(id, []string{"base", "changed"}); err != nil {
+		t.Fatalf("SetPackages failed: %v", err)
+	}
+	if third := build(3); third == first {
+		t.Errorf("config hash did not change after the package list changed")
+	}
+}
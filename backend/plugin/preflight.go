@@ -0,0 +1,168 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"syscall"
+
+	"example.com/jsonrpcengine/plugin/runner"
+)
+
+// Severities of a Finding.
+const (
+	SeverityError   = "error"   // the build would fail
+	SeverityWarning = "warning" // the build may fail or produce something unexpected
+)
+
+// Finding is a problem found by a preflight check.
+type Finding struct {
+	Check    string `json:"check"` // what was checked, e.g. "host_tools" or "disk_space"
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Fix      string `json:"fix,omitempty"`      // suggested fix, if there is an obvious one
+	AutoFix  bool   `json:"auto_fix,omitempty"` // engine.doctor can apply the fix itself
+	Fixed    bool   `json:"fixed,omitempty"`    // set by engine.doctor once the fix has been applied
+}
+
+// PreflightReport is the result of project.preflight.
+type PreflightReport struct {
+	Passed   bool      `json:"passed"` // false if there are any errors
+	Errors   []Finding `json:"errors"`
+	Warnings []Finding `json:"warnings"`
+}
+
+// NewPreflightReport sorts findings into errors and warnings.
+func NewPreflightReport(findings []Finding) PreflightReport {
+	report := PreflightReport{Errors: []Finding{}, Warnings: []Finding{}}
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			report.Errors = append(report.Errors, f)
+		} else {
+			report.Warnings = append(report.Warnings, f)
+		}
+	}
+	report.Passed = len(report.Errors) == 0
+	return report
+}
+
+// HostRequirement is a command a plugin needs on the build host.
+type HostRequirement struct {
+	Command string `json:"command"`
+	Package string `json:"package"` // the host package that provides Command
+	// Privileged commands are run as root through the plugin's runner.
+	Privileged bool `json:"privileged"`
+}
+
+// Preflighter is an optional interface for plugins that can check a project
+// and the host before a build. The engine runs Preflight before every build
+// and refuses to start it if any errors are found.
+type Preflighter interface {
+	// HostRequirements lists the commands builds need on the host.
+	HostRequirements() []HostRequirement
+	// Preflight checks that the project can be built on this host.
+	Preflight(ctx context.Context, projectID string) ([]Finding, error)
+}
+
+// CheckHostRequirements reports missing commands as errors and, if any of
+// them needs root, checks that r can get it without a prompt.
+func CheckHostRequirements(ctx context.Context, r runner.Runner, reqs []HostRequirement) []Finding {
+	var findings []Finding
+	privileged := false
+	for _, req := range reqs {
+		privileged = privileged || req.Privileged
+		if err := runner.LookPath(r, req.Command); err != nil {
+			findings = append(findings, Finding{
+				Check:    "host_tools",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("%s is not installed", req.Command),
+				Fix:      fmt.Sprintf("Install the %s package", req.Package),
+			})
+		}
+	}
+	if privileged {
+		if err := runner.CheckPrivileges(ctx, r); err != nil {
+			findings = append(findings, Finding{
+				Check:    "privileges",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("Build tools can't be run as root: %v", err),
+				Fix:      "Run the engine as root, or allow the build tools in sudoers with NOPASSWD and use -runner sudo",
+			})
+		}
+	}
+	return findings
+}
+
+// SpaceNeed is an estimate of the disk space a build needs below Dir.
+type SpaceNeed struct {
+	Dir   string
+	Bytes uint64
+}
+
+// CheckFreeSpace compares the free space in each directory with what is
+// needed there. Needs of directories on the same filesystem are added up.
+// Less than the estimate is an error; less than 1.5 times the estimate, a warning.
+func CheckFreeSpace(needs []SpaceNeed) []Finding {
+	type filesystem struct {
+		dirs  []string
+		need  uint64
+		avail uint64
+	}
+	var order []uint64
+	filesystems := make(map[uint64]*filesystem)
+	var findings []Finding
+	for _, n := range needs {
+		var st syscall.Statfs_t
+		var info syscall.Stat_t
+		if err := syscall.Statfs(n.Dir, &st); err != nil {
+			findings = append(findings, Finding{Check: "disk_space", Severity: SeverityWarning, Message: fmt.Sprintf("Could not check free space in %s: %v", n.Dir, err)})
+			continue
+		}
+		if err := syscall.Stat(n.Dir, &info); err != nil {
+			findings = append(findings, Finding{Check: "disk_space", Severity: SeverityWarning, Message: fmt.Sprintf("Could not check free space in %s: %v", n.Dir, err)})
+			continue
+		}
+		fs, found := filesystems[uint64(info.Dev)]
+		if !found {
+			fs = &filesystem{avail: st.Bavail * uint64(st.Bsize)}
+			filesystems[uint64(info.Dev)] = fs
+			order = append(order, uint64(info.Dev))
+		}
+		fs.dirs = append(fs.dirs, n.Dir)
+		fs.need += n.Bytes
+	}
+	for _, dev := range order {
+		fs := filesystems[dev]
+		where := strings.Join(fs.dirs, " and ")
+		switch {
+		case fs.avail < fs.need:
+			findings = append(findings, Finding{
+				Check:    "disk_space",
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("Only %s free for %s, the build needs about %s", FormatBytes(fs.avail), where, FormatBytes(fs.need)),
+				Fix:      "Free up disk space, e.g. by deleting old work directories and ISOs",
+			})
+		case fs.avail < fs.need/2*3:
+			findings = append(findings, Finding{
+				Check:    "disk_space",
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("Only %s free for %s, the build needs about %s", FormatBytes(fs.avail), where, FormatBytes(fs.need)),
+			})
+		}
+	}
+	return findings
+}
+
+// FormatBytes renders a byte count in binary units, e.g. "1.5 GiB".
+func FormatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,25 @@
+package plugin
+
+// ProfileDefEditor is an optional interface for plugins whose projects are
+// archiso profiles. It gives structured access to the variables of the
+// profile's profiledef.sh; everything else in the file is left as it is.
+type ProfileDefEditor interface {
+	GetProfileDef(projectID string) (ProfileDef, error)
+	// SetProfileDef changes the given variables and returns the result.
+	// Values are strings, lists of strings (bash arrays) or objects
+	// mapping strings to strings (associative arrays); nil removes the
+	// variable. Variables that aren't mentioned stay untouched.
+	SetProfileDef(projectID string, variables map[string]interface{}) (ProfileDef, error)
+}
+
+// ProfileDef holds the variables profiledef.sh assigns.
+type ProfileDef struct {
+	// Variables maps variable names to a string, a []string or a
+	// map[string]string.
+	Variables map[string]interface{} `json:"variables"`
+	// Expressions lists the variables whose values use shell expansions,
+	// e.g. iso_version="$(date +%Y.%m.%d)". Their values are shown as
+	// written; setting them to that text again leaves them as they are,
+	// anything else replaces them with a literal value.
+	Expressions []string `json:"expressions"`
+}
@@ -0,0 +1,52 @@
+package plugin
+
+// RepoEditor is an optional interface for plugins whose projects configure
+// the package repositories their images are built from, such as the
+// pacman.conf of an archiso profile. Changes leave the rest of the
+// configuration, comments included, as it was.
+type RepoEditor interface {
+	ListRepos(projectID string) (ReposResponse, error)
+	// AddRepo adds a repository at position, counting from 0; a negative
+	// position adds it after the others. A repository with the same name
+	// is replaced, and keeps its place unless position is given.
+	AddRepo(projectID string, repo Repo, position int) (ReposResponse, error)
+	RemoveRepo(projectID string, name string) (ReposResponse, error)
+	// SetRepoOrder reorders the repositories; names must list each of them
+	// once. Package managers prefer packages from earlier repositories.
+	SetRepoOrder(projectID string, names []string) (ReposResponse, error)
+	GetRepoOptions(projectID string) (RepoOptions, error)
+	// SetRepoOptions replaces the global options. Zero values remove an
+	// option, leaving the package manager's default.
+	SetRepoOptions(projectID string, options RepoOptions) (RepoOptions, error)
+}
+
+// ReposResponse lists a project's repositories in the order they are used.
+type ReposResponse struct {
+	Repos []Repo `json:"repos"`
+}
+
+// Repo is a package repository.
+type Repo struct {
+	Name string `json:"name"`
+	// Servers are URLs packages are downloaded from, and Include files
+	// listing more servers, e.g. a mirrorlist. A repository needs at least
+	// one of either.
+	Servers []string `json:"servers"`
+	Include []string `json:"include"`
+	// SigLevel is the signature checking of the repository, e.g.
+	// "Required DatabaseOptional"; empty means the global default.
+	SigLevel string `json:"sig_level,omitempty"`
+	// Usage limits what the repository is used for, e.g. ["Sync",
+	// "Search"]; empty means all of it.
+	Usage []string `json:"usage,omitempty"`
+}
+
+// RepoOptions are the global options of the package manager that affect
+// all repositories.
+type RepoOptions struct {
+	// SigLevel is the default signature checking of repositories.
+	SigLevel          string   `json:"sig_level,omitempty"`
+	ParallelDownloads int      `json:"parallel_downloads,omitempty"`
+	IgnorePkg         []string `json:"ignore_pkg"`
+	NoExtract         []string `json:"no_extract"`
+}
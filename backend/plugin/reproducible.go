@@ -0,0 +1,103 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SnapshotLayout is the format of Reproducible.Snapshot.
+const SnapshotLayout = "2006-01-02"
+
+// Reproducible holds what makes a build repeatable besides its
+// configuration.
+type Reproducible struct {
+	// SourceDateEpoch is passed to the build tools as SOURCE_DATE_EPOCH, so
+	// that timestamps in the image are the same every time.
+	SourceDateEpoch int64 `json:"source_date_epoch"`
+	// Snapshot, if set, is the day of the package repository snapshot the
+	// build installs packages from instead of the current mirrors, e.g.
+	// "2024-06-01".
+	Snapshot string `json:"snapshot,omitempty"`
+}
+
+// Env returns the environment variables the build tools need to honour r.
+func (r *Reproducible) Env() []string {
+	if r == nil {
+		return nil
+	}
+	return []string{fmt.Sprintf("SOURCE_DATE_EPOCH=%d", r.SourceDateEpoch)}
+}
+
+// SnapshotDate returns the day of the snapshot, or false if there is none.
+func (r *Reproducible) SnapshotDate() (time.Time, bool) {
+	if r == nil || r.Snapshot == "" {
+		return time.Time{}, false
+	}
+	day, err := time.Parse(SnapshotLayout, r.Snapshot)
+	return day, err == nil
+}
+
+// CopyDir copies the files, symlinks and directories below src to dst,
+// keeping their permissions, so that HashDir gives the same result for both.
+func CopyDir(src, dst string) error {
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			return os.Chmod(target, info.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", src, dst, err)
+	}
+	return nil
+}
+
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// The umask may have taken bits away.
+	return os.Chmod(dst, perm)
+}
@@ -0,0 +1,77 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// FakeFunc scripts the behaviour of a fake command. It runs in its own
+// goroutine when the command is started, may write to cmd.Stdout/cmd.Stderr
+// and should honour ctx. Returning an *ExitError simulates a non-zero exit.
+type FakeFunc func(ctx context.Context, cmd Command) error
+
+// Fake is a Runner for tests. Commands are dispatched by name to the scripted
+// Tools; starting a command without a script fails like a missing binary.
+// Every started command is recorded in Calls.
+type Fake struct {
+	Tools map[string]FakeFunc
+
+	mu    sync.Mutex
+	calls []Invocation
+}
+
+// NewFake returns a Fake runner with the given scripted tools.
+func NewFake(tools map[string]FakeFunc) *Fake {
+	return &Fake{Tools: tools}
+}
+
+func (f *Fake) Start(ctx context.Context, cmd Command) (Process, error) {
+	if err := f.LookPath(cmd.Name); err != nil {
+		return nil, err
+	}
+	script := f.Tools[cmd.Name]
+	inv := Invocation{Args: append([]string{cmd.Name}, cmd.Args...), Env: cmd.Env}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, inv)
+	f.mu.Unlock()
+
+	p := &fakeProcess{inv: inv, done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		p.err = script(ctx, cmd)
+	}()
+	return p, nil
+}
+
+// LookPath implements PathLooker: only the scripted tools can be started.
+func (f *Fake) LookPath(name string) error {
+	if _, found := f.Tools[name]; !found {
+		return fmt.Errorf("exec: %q: executable file not found in $PATH", name)
+	}
+	return nil
+}
+
+// Calls returns the invocations started so far, in order.
+func (f *Fake) Calls() []Invocation {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Invocation(nil), f.calls...)
+}
+
+type fakeProcess struct {
+	inv  Invocation
+	done chan struct{}
+	err  error
+}
+
+func (p *fakeProcess) Wait() error {
+	<-p.done
+	return p.err
+}
+
+func (p *fakeProcess) Pid() int               { return 0 }
+func (p *fakeProcess) Invocation() Invocation { return p.inv }
+
+var _ PathLooker = (*Fake)(nil)
@@ -0,0 +1,177 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// KillGrace is how long a cancelled command's process tree gets to exit
+// after SIGTERM before the remaining processes are sent SIGKILL.
+var KillGrace = 10 * time.Second
+
+// procRoot is where process information is read from.
+var procRoot = "/proc"
+
+// processTree returns the given processes and all of their descendants.
+// Processes whose parent has exited are re-parented to init or a subreaper
+// and no longer found; the process group catches most of those.
+func processTree(roots []int) []int {
+	children := make(map[int][]int)
+	entries, _ := os.ReadDir(procRoot)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if st, ok := readStat(pid); ok {
+			children[st.ppid] = append(children[st.ppid], pid)
+		}
+	}
+
+	seen := make(map[int]bool)
+	var tree []int
+	queue := append([]int(nil), roots...)
+	for len(queue) > 0 {
+		pid := queue[0]
+		queue = queue[1:]
+		if seen[pid] {
+			continue
+		}
+		seen[pid] = true
+		tree = append(tree, pid)
+		queue = append(queue, children[pid]...)
+	}
+	return tree
+}
+
+// procStat is what the process tree needs from /proc/<pid>/stat.
+type procStat struct {
+	state byte
+	ppid  int
+	// start is when the process started, in clock ticks since boot. A pid
+	// that is reused after its process exited gets a different one.
+	start uint64
+}
+
+// readStat reads /proc/<pid>/stat.
+func readStat(pid int) (procStat, bool) {
+	data, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return procStat{}, false
+	}
+	// The command name is in parentheses and may itself contain spaces or
+	// parentheses, so the fields are counted from the last ')': state is
+	// field 3 of proc(5), the parent field 4 and the start time field 22.
+	s := string(data)
+	end := strings.LastIndexByte(s, ')')
+	if end < 0 {
+		return procStat{}, false
+	}
+	fields := strings.Fields(s[end+1:])
+	if len(fields) < 20 || len(fields[0]) != 1 {
+		return procStat{}, false
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return procStat{}, false
+	}
+	start, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return procStat{}, false
+	}
+	return procStat{state: fields[0][0], ppid: ppid, start: start}, true
+}
+
+// startTimes records when each of pids started, leaving out the ones that
+// are already gone.
+func startTimes(pids []int) map[int]uint64 {
+	starts := make(map[int]uint64)
+	for _, pid := range pids {
+		if st, ok := readStat(pid); ok && st.state != 'Z' {
+			starts[pid] = st.start
+		}
+	}
+	return starts
+}
+
+// running reports whether pid still runs the process that started at start.
+func running(pid int, start uint64) bool {
+	st, ok := readStat(pid)
+	return ok && st.start == start && st.state != 'Z'
+}
+
+// signalFunc delivers sig to the given processes.
+type signalFunc func(sig syscall.Signal, pids []int) error
+
+// directSignal signals processes owned by the engine's own user.
+func directSignal(sig syscall.Signal, pids []int) error {
+	var firstErr error
+	for _, pid := range pids {
+		if err := syscall.Kill(pid, sig); err != nil && err != syscall.ESRCH && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// elevatedSignalTimeout bounds an elevated kill, which may wait for a
+// password or a polkit dialog.
+var elevatedSignalTimeout = 5 * time.Second
+
+// elevatedSignal signals processes through kill(1) run with the same
+// privilege escalation the command was started with, so that processes
+// running as root can be stopped too.
+func elevatedSignal(prefix []string) signalFunc {
+	return func(sig syscall.Signal, pids []int) error {
+		args := append(append([]string{}, prefix...), "kill", "-s", strconv.Itoa(int(sig)), "--")
+		for _, pid := range pids {
+			args = append(args, strconv.Itoa(pid))
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), elevatedSignalTimeout)
+		defer cancel()
+		return exec.CommandContext(ctx, args[0], args[1:]...).Run()
+	}
+}
+
+// terminateTree stops the process tree below pid: SIGTERM first, then SIGKILL
+// for whatever is left after KillGrace. The returned timer sends SIGKILL; it
+// must be stopped once the leader has been reaped.
+func terminateTree(pid int, signal signalFunc) (*time.Timer, error) {
+	tree := processTree([]int{pid})
+	starts := startTimes(tree)
+	err := signal(syscall.SIGTERM, tree)
+	if err != nil {
+		// The escalated kill may not be allowed; the leader at least belongs to us.
+		err = directSignal(syscall.SIGTERM, []int{pid})
+	}
+	timer := time.AfterFunc(KillGrace, func() { killRemaining(pid, starts, signal) })
+	return timer, err
+}
+
+// killRemaining sends SIGKILL to the processes of a tree that are still
+// running, and to the children they have started since. Nothing is sent once
+// the leader has exited: the pids may then belong to other processes, and
+// signal may run as root.
+func killRemaining(leader int, starts map[int]uint64, signal signalFunc) {
+	if start, ok := starts[leader]; !ok || !running(leader, start) {
+		return
+	}
+	var roots []int
+	for pid, start := range starts {
+		if running(pid, start) {
+			roots = append(roots, pid)
+		}
+	}
+	sort.Ints(roots)
+	alive := processTree(roots)
+	if err := signal(syscall.SIGKILL, alive); err != nil {
+		directSignal(syscall.SIGKILL, []int{leader})
+	}
+}
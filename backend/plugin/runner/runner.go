@@ -0,0 +1,249 @@
+// Package runner abstracts how plugins execute host tools such as mkarchiso.
+// Build tools usually need root, and whether that comes from sudo, pkexec or
+// from the engine already running as root (e.g. on CI) is a deployment
+// decision rather than a plugin one. Tests use Fake to script tool behaviour.
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Command describes a process a plugin wants to run.
+type Command struct {
+	Name string
+	Args []string
+	// Env holds extra KEY=value pairs for the process, on top of the engine's
+	// own environment. Privileged runners pass them through explicitly.
+	Env    []string
+	Dir    string
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Invocation is the exact command line and extra environment a runner used
+// to start a Command. Plugins record it with each build.
+type Invocation struct {
+	Args []string `json:"args"`
+	Env  []string `json:"env,omitempty"`
+}
+
+// String renders the invocation as a shell-like command line.
+func (inv Invocation) String() string {
+	parts := append(append([]string{}, inv.Env...), inv.Args...)
+	return strings.Join(parts, " ")
+}
+
+// Process is a started command.
+type Process interface {
+	// Wait blocks until the process exits. A non-zero exit is reported as an
+	// error from which ExitCode can recover the status.
+	Wait() error
+	// Pid returns the process ID, or 0 if there is no real process.
+	Pid() int
+	// Invocation returns what was actually executed.
+	Invocation() Invocation
+}
+
+// Runner starts commands.
+type Runner interface {
+	Start(ctx context.Context, cmd Command) (Process, error)
+}
+
+// New returns the runner registered under name: "direct", "sudo" or "pkexec".
+func New(name string) (Runner, error) {
+	switch name {
+	case "direct":
+		return Direct{}, nil
+	case "sudo":
+		return Sudo{}, nil
+	case "pkexec":
+		return Pkexec{}, nil
+	default:
+		return nil, fmt.Errorf("unknown command runner %q (expected direct, sudo or pkexec)", name)
+	}
+}
+
+// Unprivileged returns a runner for commands that don't need root, such as
+// querying installed package versions. Sudo and Pkexec fall back to Direct;
+// any other runner (notably Fake) is returned unchanged.
+func Unprivileged(r Runner) Runner {
+	switch r.(type) {
+	case Sudo, Pkexec:
+		return Direct{}
+	default:
+		return r
+	}
+}
+
+// PathLooker is implemented by runners that find commands somewhere other
+// than the engine's $PATH, such as Fake.
+type PathLooker interface {
+	// LookPath reports an error if the runner can't start the named command.
+	LookPath(name string) error
+}
+
+// LookPath reports an error if r has no way to start the named command.
+// Runners that don't implement PathLooker start commands from $PATH.
+func LookPath(r Runner, name string) error {
+	if l, ok := r.(PathLooker); ok {
+		return l.LookPath(name)
+	}
+	_, err := exec.LookPath(name)
+	return err
+}
+
+// CheckPrivileges reports an error if r can't run commands as root without
+// asking for a password nobody could type in. Direct needs the engine itself
+// to run as root; Sudo must be allowed without a password. Pkexec relies on
+// the desktop's authentication agent and is only checked for being installed.
+func CheckPrivileges(ctx context.Context, r Runner) error {
+	switch r.(type) {
+	case Direct:
+		if os.Geteuid() != 0 {
+			return errors.New("the engine doesn't run as root")
+		}
+	case Sudo:
+		var stderr strings.Builder
+		c := exec.CommandContext(ctx, "sudo", "-n", "true")
+		c.Stderr = &stderr
+		if err := c.Run(); err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return fmt.Errorf("sudo -n: %s", msg)
+			}
+			return fmt.Errorf("sudo -n: %w", err)
+		}
+	case Pkexec:
+		if _, err := exec.LookPath("pkexec"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExitError is returned by Wait when a fake process exits with a non-zero code.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// ExitCode extracts the exit code from an error returned by Process.Wait.
+// It returns 0 for a nil error and -1 if the error carries no exit status.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	var fakeErr *ExitError
+	if errors.As(err, &fakeErr) {
+		return fakeErr.Code
+	}
+	return -1
+}
+
+// Direct runs commands as the engine's own user.
+type Direct struct{}
+
+func (Direct) Start(ctx context.Context, cmd Command) (Process, error) {
+	return startExec(ctx, cmd, Invocation{Args: append([]string{cmd.Name}, cmd.Args...), Env: cmd.Env}, directSignal)
+}
+
+// Sudo runs commands through non-interactive sudo. The engine talks JSON-RPC
+// over stdin, so a password prompt could never be answered; sudo has to be
+// configured to allow the build tool without one.
+type Sudo struct{}
+
+func (Sudo) Start(ctx context.Context, cmd Command) (Process, error) {
+	return startExec(ctx, cmd, Invocation{Args: elevatedArgs([]string{"sudo", "-n"}, cmd)}, elevatedSignal([]string{"sudo", "-n"}))
+}
+
+// Pkexec runs commands through polkit's pkexec, for desktop sessions where a
+// graphical authentication agent can prompt the user.
+type Pkexec struct{}
+
+func (Pkexec) Start(ctx context.Context, cmd Command) (Process, error) {
+	return startExec(ctx, cmd, Invocation{Args: elevatedArgs([]string{"pkexec"}, cmd)}, elevatedSignal([]string{"pkexec"}))
+}
+
+// elevatedArgs builds the argv for a privilege-escalation wrapper. Both sudo
+// and pkexec scrub the environment, so extra variables are passed via env(1).
+func elevatedArgs(prefix []string, cmd Command) []string {
+	args := append([]string{}, prefix...)
+	if len(cmd.Env) > 0 {
+		args = append(args, "env")
+		args = append(args, cmd.Env...)
+	}
+	args = append(args, cmd.Name)
+	return append(args, cmd.Args...)
+}
+
+// execProcess is a Process backed by os/exec.
+type execProcess struct {
+	cmd *exec.Cmd
+	inv Invocation
+
+	mu        sync.Mutex
+	reaped    bool
+	killTimer *time.Timer // pending SIGKILL of a cancelled process tree
+}
+
+// startExec starts the command in its own process group. When ctx is
+// cancelled, the whole process tree is terminated through signal, which
+// matters for build tools that fork helpers (pacstrap, mksquashfs, ...) and
+// for wrappers like sudo that don't pass SIGKILL on.
+func startExec(ctx context.Context, cmd Command, inv Invocation, signal signalFunc) (Process, error) {
+	c := exec.CommandContext(ctx, inv.Args[0], inv.Args[1:]...)
+	c.Env = append(os.Environ(), inv.Env...)
+	c.Dir = cmd.Dir
+	c.Stdout = cmd.Stdout
+	c.Stderr = cmd.Stderr
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	p := &execProcess{cmd: c, inv: inv}
+	c.Cancel = func() error { return p.terminate(signal) }
+	// Backstop in case the tree ignores both signals: Wait gives up and kills the leader.
+	c.WaitDelay = 2 * KillGrace
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// terminate stops the process tree, unless the leader has been reaped.
+func (p *execProcess) terminate(signal signalFunc) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.reaped {
+		return nil
+	}
+	timer, err := terminateTree(p.cmd.Process.Pid, signal)
+	p.killTimer = timer
+	return err
+}
+
+func (p *execProcess) Wait() error {
+	err := p.cmd.Wait()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reaped = true
+	if p.killTimer != nil {
+		p.killTimer.Stop()
+	}
+	return err
+}
+
+func (p *execProcess) Pid() int               { return p.cmd.Process.Pid }
+func (p *execProcess) Invocation() Invocation { return p.inv }
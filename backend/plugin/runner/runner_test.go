@@ -0,0 +1,157 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestElevatedArgsPassesEnvThroughEnv(t *testing.T) {
+	cmd := Command{Name: "mkarchiso", Args: []string{"-v", "profile"}, Env: []string{"LC_ALL=C"}}
+	got := elevatedArgs([]string{"sudo", "-n"}, cmd)
+	want := []string{"sudo", "-n", "env", "LC_ALL=C", "mkarchiso", "-v", "profile"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("elevatedArgs = %v, want %v", got, want)
+	}
+}
+
+func TestDirectRecordsInvocationAndExitCode(t *testing.T) {
+	proc, err := Direct{}.Start(context.Background(), Command{Name: "sh", Args: []string{"-c", "exit 3"}, Env: []string{"FOO=bar"}})
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if code := ExitCode(proc.Wait()); code != 3 {
+		t.Errorf("ExitCode = %d, want 3", code)
+	}
+	want := Invocation{Args: []string{"sh", "-c", "exit 3"}, Env: []string{"FOO=bar"}}
+	if !reflect.DeepEqual(proc.Invocation(), want) {
+		t.Errorf("Invocation = %+v, want %+v", proc.Invocation(), want)
+	}
+}
+
+func TestFakeDispatchesByName(t *testing.T) {
+	fake := NewFake(map[string]FakeFunc{
+		"tool": func(ctx context.Context, cmd Command) error { return &ExitError{Code: 2} },
+	})
+	proc, err := fake.Start(context.Background(), Command{Name: "tool", Args: []string{"a"}})
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if code := ExitCode(proc.Wait()); code != 2 {
+		t.Errorf("ExitCode = %d, want 2", code)
+	}
+	if _, err := fake.Start(context.Background(), Command{Name: "missing"}); err == nil {
+		t.Errorf("starting an unscripted command should fail")
+	}
+	if calls := fake.Calls(); len(calls) != 1 || calls[0].Args[0] != "tool" {
+		t.Errorf("Calls = %+v, want a single call to tool", calls)
+	}
+}
+
+func TestDirectCancelTerminatesProcessTree(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	proc, err := Direct{}.Start(ctx, Command{Name: "sh", Args: []string{"-c", "sleep 60 & sleep 60 & wait"}})
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	var tree []int
+	for deadline := time.Now().Add(5 * time.Second); len(tree) < 3 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		tree = processTree([]int{proc.Pid()})
+	}
+	if len(tree) < 3 {
+		t.Fatalf("expected the shell and two children, found %v", tree)
+	}
+
+	cancel()
+	done := make(chan error, 1)
+	go func() { done <- proc.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("process did not exit after cancellation")
+	}
+	for _, pid := range tree[1:] {
+		if alive(pid) {
+			t.Errorf("child %d survived cancellation", pid)
+		}
+	}
+}
+
+// alive reports whether pid still runs; zombies waiting for init count as gone.
+func alive(pid int) bool {
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil || strings.Contains(string(data), ") Z ") {
+			return false
+		}
+	}
+	return true
+}
+
+// writeStat writes a /proc/<pid>/stat below procRoot.
+func writeStat(t *testing.T, pid, ppid int, state string, start int) {
+	t.Helper()
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	stat := fmt.Sprintf("%d (mk (archiso)) %s %d %d 0 0 -1 0 0 0 0 0 0 0 0 0 20 0 1 0 %d 0 0\n", pid, state, ppid, pid, start)
+	if err := os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKillRemainingChecksStartTimes(t *testing.T) {
+	defer func(root string) { procRoot = root }(procRoot)
+	procRoot = t.TempDir()
+	writeStat(t, 100, 1, "S", 5000)
+	writeStat(t, 101, 100, "S", 5001)
+	writeStat(t, 102, 100, "S", 5002)
+	starts := startTimes(processTree([]int{100}))
+
+	var killed [][]int
+	signal := func(sig syscall.Signal, pids []int) error {
+		killed = append(killed, pids)
+		return nil
+	}
+
+	// 102 exited and its pid went to an unrelated process; 101 forked 103.
+	writeStat(t, 102, 1, "S", 9000)
+	writeStat(t, 103, 101, "S", 6000)
+	killRemaining(100, starts, signal)
+	if want := [][]int{{100, 101, 103}}; !reflect.DeepEqual(killed, want) {
+		t.Errorf("killed %v, want %v", killed, want)
+	}
+
+	// Once the leader is gone nothing is killed.
+	killed = nil
+	writeStat(t, 100, 1, "Z", 5000)
+	killRemaining(100, starts, signal)
+	writeStat(t, 100, 1, "S", 9100)
+	killRemaining(100, starts, signal)
+	if len(killed) != 0 {
+		t.Errorf("killed %v after the leader exited, want nothing", killed)
+	}
+}
+
+func TestElevatedSignalGivesUp(t *testing.T) {
+	defer func(timeout time.Duration) { elevatedSignalTimeout = timeout }(elevatedSignalTimeout)
+	elevatedSignalTimeout = 50 * time.Millisecond
+	// A wrapper that never returns, like pkexec waiting for its dialog.
+	signal := elevatedSignal([]string{"sh", "-c", "sleep 10"})
+	start := time.Now()
+	if err := signal(syscall.SIGTERM, []int{os.Getpid()}); err == nil {
+		t.Error("elevated kill that timed out reported success")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("elevated kill took %v, want it to give up after %v", elapsed, elevatedSignalTimeout)
+	}
+}
@@ -0,0 +1,33 @@
+package plugin
+
+// TemplateProvider is an optional interface for plugins that can seed new
+// projects from templates. engine.createProject checks the template a client
+// asks for against ListTemplates and passes its name to CreateProject as
+// params["template"], and a version, if one was asked for, as
+// params["template_version"]; without a template, plugins create their
+// default project.
+type TemplateProvider interface {
+	ListTemplates() ([]Template, error)
+}
+
+// TemplateSaver is an optional interface for plugins that can turn a
+// project into a template. Saving under the name of an earlier saved
+// template adds a new version of it.
+type TemplateSaver interface {
+	SaveAsTemplate(projectID, name, description string) (Template, error)
+}
+
+// Template describes a template new projects can start from.
+type Template struct {
+	Name        string `json:"name"`
+	DistroID    string `json:"distro_id"`
+	Description string `json:"description"`
+	// Source tells where the template comes from: "builtin" for templates
+	// that ship with the plugin, "user" for templates in the data
+	// directory, or a plugin-specific source such as "archiso".
+	Source string `json:"source"`
+	// Versions lists the versions of saved templates, oldest first. Projects
+	// are created from the latest one, Version, unless they ask for another.
+	Version  int   `json:"version,omitempty"`
+	Versions []int `json:"versions,omitempty"`
+}
@@ -0,0 +1,55 @@
+package plugin
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"syscall"
+)
+
+// WorkdirCleaner is an optional interface for plugins that keep state on
+// disk between builds: per-project work directories and shared caches.
+// The engine removes work directories according to its work directory
+// policy and on request, never while the project is being built.
+type WorkdirCleaner interface {
+	// CleanWorkdir removes the project's work directory and returns how many
+	// bytes that freed. A missing work directory is not an error.
+	CleanWorkdir(ctx context.Context, projectID string) (int64, error)
+	// CleanCache trims the caches shared by all projects, e.g. downloaded
+	// packages that have been superseded, and returns how many bytes that freed.
+	// It is only called while no builds are running.
+	CleanCache(ctx context.Context) (int64, error)
+}
+
+// DiskUsage returns the disk space taken by the files below path, like du.
+// Files with several hard links are counted once; entries that can't be
+// read are skipped, so the result may be too low for directories owned by root.
+func DiskUsage(path string) int64 {
+	type inode struct{ dev, ino uint64 }
+	seen := make(map[inode]bool)
+	var total int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			total += info.Size()
+			return nil
+		}
+		key := inode{uint64(st.Dev), st.Ino}
+		if st.Nlink > 1 {
+			if seen[key] {
+				return nil
+			}
+			seen[key] = true
+		}
+		total += st.Blocks * 512
+		return nil
+	})
+	return total
+}
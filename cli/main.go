@@ -55,6 +55,39 @@ func main() {
 	}
 
 	var params interface{}
+	// render, if set, presents the response instead of printing the raw JSON.
+	var render func(resp JSONRPCResponse)
+	exitCode := 0
+	if method == "doctor" {
+		fix := false
+		for _, arg := range os.Args[2:] {
+			if arg != "--fix" {
+				log.Fatalf("Unknown argument for doctor: %s", arg)
+			}
+			fix = true
+		}
+		method = "engine.doctor"
+		params = map[string]bool{"fix": fix}
+		paramsStr = ""
+		render = func(resp JSONRPCResponse) {
+			if !renderDoctorReport(resp) {
+				exitCode = 1
+			}
+		}
+	}
+	if method == "diff" {
+		if len(os.Args) != 5 {
+			log.Fatalf("Usage: distroforge-cli diff <project_id> <build_a> <build_b>")
+		}
+		method = "project.diffBuilds"
+		params = map[string]string{"project_id": os.Args[2], "build_a": os.Args[3], "build_b": os.Args[4]}
+		paramsStr = ""
+		render = func(resp JSONRPCResponse) {
+			if !renderChangelog(resp) {
+				exitCode = 1
+			}
+		}
+	}
 	if paramsStr != "" {
 		// Attempt to unmarshal paramsStr as a JSON object or array
 		var jsonObj map[string]interface{}
@@ -171,7 +204,7 @@ func main() {
 	if !isStreamingMethod {
 		done := make(chan bool)
 		go func() {
-			processBackendOutput(stdout, req.ID, isStreamingMethod)
+			processBackendOutput(stdout, req.ID, isStreamingMethod, render)
 			done <- true
 		}()
 		select {
@@ -182,7 +215,7 @@ func main() {
 		}
 	} else {
 		// For streaming methods, process output until stdout is closed
-		processBackendOutput(stdout, req.ID, isStreamingMethod)
+		processBackendOutput(stdout, req.ID, isStreamingMethod, render)
 	}
 
 
@@ -197,9 +230,10 @@ func main() {
 		}
 	}
 	wg.Wait() // Wait for stderr goroutine to finish
+	os.Exit(exitCode)
 }
 
-func processBackendOutput(stdout io.ReadCloser, requestID int, isStreaming bool) {
+func processBackendOutput(stdout io.ReadCloser, requestID int, isStreaming bool, render func(JSONRPCResponse)) {
 	reader := bufio.NewReader(stdout)
 	for {
 		line, err := reader.ReadBytes('\n')
@@ -223,6 +257,14 @@ func processBackendOutput(stdout io.ReadCloser, requestID int, isStreaming bool)
 		// The current backend implementation for streaming sends full JSONRPCResponse structures
 		// as separate JSON lines.
 
+		if render != nil && resp.ID == requestID {
+			render(resp)
+			if !isStreaming {
+				break
+			}
+			continue
+		}
+
 		// Print the formatted JSON response
 		var prettyOutput bytes.Buffer
 		if err := json.Indent(&prettyOutput, line, "", "  "); err != nil {
@@ -240,15 +282,176 @@ func processBackendOutput(stdout io.ReadCloser, requestID int, isStreaming bool)
 }
 
 
+// doctorFinding mirrors a finding in the engine.doctor result.
+type doctorFinding struct {
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Fix      string `json:"fix"`
+	AutoFix  bool   `json:"auto_fix"`
+	Fixed    bool   `json:"fixed"`
+}
+
+// renderDoctorReport prints the engine.doctor result as a list of findings
+// and reports whether the host is healthy.
+func renderDoctorReport(resp JSONRPCResponse) bool {
+	if len(resp.Error) > 0 {
+		fmt.Printf("engine.doctor failed: %s\n", resp.Error)
+		return false
+	}
+	var report struct {
+		Healthy  bool            `json:"healthy"`
+		Findings []doctorFinding `json:"findings"`
+	}
+	if err := json.Unmarshal(resp.Result, &report); err != nil {
+		fmt.Printf("Unexpected engine.doctor result: %v\n", err)
+		return false
+	}
+
+	autoFixable := 0
+	for _, f := range report.Findings {
+		status := f.Severity
+		if f.Fixed {
+			status = "fixed"
+		} else if f.AutoFix {
+			autoFixable++
+		}
+		fmt.Printf("[%s] %s (%s)\n", status, f.Message, f.Check)
+		if f.Fix != "" && !f.Fixed {
+			fmt.Printf("    fix: %s\n", f.Fix)
+		}
+	}
+	switch {
+	case len(report.Findings) == 0:
+		fmt.Println("No problems found.")
+	case report.Healthy:
+		fmt.Println("No errors found.")
+	default:
+		fmt.Println("Errors found; the engine won't be able to build until they are fixed.")
+	}
+	if autoFixable > 0 {
+		fmt.Println("Run 'distroforge-cli doctor --fix' to apply the fixes that are safe to do automatically.")
+	}
+	return report.Healthy
+}
+
+// buildDiff mirrors the project.diffBuilds result.
+type buildDiff struct {
+	BuildA   string `json:"build_a"`
+	BuildB   string `json:"build_b"`
+	Packages struct {
+		Added      []packageChange `json:"added"`
+		Removed    []packageChange `json:"removed"`
+		Upgraded   []packageChange `json:"upgraded"`
+		Downgraded []packageChange `json:"downgraded"`
+	} `json:"packages"`
+	Files struct {
+		Added    []string `json:"added"`
+		Removed  []string `json:"removed"`
+		Modified []string `json:"modified"`
+	} `json:"files"`
+	Settings   []packageChange `json:"settings"`
+	Incomplete []string        `json:"incomplete"`
+}
+
+// packageChange is a package or setting that changed from From to To.
+type packageChange struct {
+	Name string `json:"name"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// renderChangelog prints the project.diffBuilds result as a Markdown
+// changelog that can go into release notes as it is.
+func renderChangelog(resp JSONRPCResponse) bool {
+	if len(resp.Error) > 0 {
+		fmt.Printf("project.diffBuilds failed: %s\n", resp.Error)
+		return false
+	}
+	var diff buildDiff
+	if err := json.Unmarshal(resp.Result, &diff); err != nil {
+		fmt.Printf("Unexpected project.diffBuilds result: %v\n", err)
+		return false
+	}
+
+	fmt.Printf("## Changes from %s to %s\n", diff.BuildA, diff.BuildB)
+	var lines []string
+	for _, p := range diff.Packages.Added {
+		lines = append(lines, fmt.Sprintf("Added %s %s", p.Name, p.To))
+	}
+	for _, p := range diff.Packages.Removed {
+		lines = append(lines, fmt.Sprintf("Removed %s %s", p.Name, p.From))
+	}
+	for _, p := range diff.Packages.Upgraded {
+		lines = append(lines, fmt.Sprintf("Upgraded %s from %s to %s", p.Name, p.From, p.To))
+	}
+	for _, p := range diff.Packages.Downgraded {
+		lines = append(lines, fmt.Sprintf("Downgraded %s from %s to %s", p.Name, p.From, p.To))
+	}
+	printSection("Packages", lines)
+
+	lines = nil
+	for _, f := range diff.Files.Added {
+		lines = append(lines, "Added "+f)
+	}
+	for _, f := range diff.Files.Removed {
+		lines = append(lines, "Removed "+f)
+	}
+	for _, f := range diff.Files.Modified {
+		lines = append(lines, "Changed "+f)
+	}
+	printSection("Files", lines)
+
+	lines = nil
+	for _, s := range diff.Settings {
+		switch {
+		case s.From == "":
+			lines = append(lines, fmt.Sprintf("Set %s to %s", s.Name, s.To))
+		case s.To == "":
+			lines = append(lines, fmt.Sprintf("Unset %s (was %s)", s.Name, s.From))
+		default:
+			lines = append(lines, fmt.Sprintf("Changed %s from %s to %s", s.Name, s.From, s.To))
+		}
+	}
+	printSection("Settings", lines)
+
+	if len(diff.Packages.Added)+len(diff.Packages.Removed)+len(diff.Packages.Upgraded)+len(diff.Packages.Downgraded)+
+		len(diff.Files.Added)+len(diff.Files.Removed)+len(diff.Files.Modified)+len(diff.Settings) == 0 {
+		fmt.Println("\nNo changes.")
+	}
+	for _, note := range diff.Incomplete {
+		fmt.Printf("\nNote: %s, so the changelog is incomplete.\n", note)
+	}
+	return true
+}
+
+// printSection prints a changelog section, unless it is empty.
+func printSection(title string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	fmt.Printf("\n### %s\n\n", title)
+	for _, line := range lines {
+		fmt.Printf("- %s\n", line)
+	}
+}
+
 func printUsage() {
 	fmt.Println("Usage: ./distroforge-cli <method> [params_json_string]")
 	fmt.Println("\nExamples:")
 	fmt.Println("  ./distroforge-cli engine.getDistroPlugins")
+	fmt.Println("  ./distroforge-cli doctor [--fix]")
 	fmt.Println("  ./distroforge-cli engine.createProject '{\"distro_id\": \"arch\"}'")
+	fmt.Println("  ./distroforge-cli engine.listTemplates '{\"distro_id\": \"arch\"}'")
+	fmt.Println("  ./distroforge-cli engine.createProject '{\"distro_id\": \"arch\", \"template\": \"releng\"}'")
 	fmt.Println("  ./distroforge-cli project.getDetails '{\"project_id\": \"your_project_id\"}'")
+	fmt.Println("  ./distroforge-cli project.saveAsTemplate '{\"project_id\": \"your_project_id\", \"name\": \"kiosk\", \"description\": \"Boots into a browser\"}'")
 	fmt.Println("  ./distroforge-cli project.setPackages '{\"project_id\": \"your_project_id\", \"packages\": [\"nginx\", \"git\"]}'")
 	fmt.Println("  ./distroforge-cli project.getPackages '{\"project_id\": \"your_project_id\"}'")
+	fmt.Println("  ./distroforge-cli project.addRepo '{\"project_id\": \"your_project_id\", \"repo\": {\"name\": \"custom\", \"servers\": [\"file:///srv/repo\"]}, \"position\": 0}'")
+	fmt.Println("  ./distroforge-cli arch.setMirrors '{\"project_id\": \"your_project_id\", \"servers\": [\"file:///srv/mirror/$repo/os/$arch\"]}'")
 	fmt.Println("  ./distroforge-cli project.buildIso '{\"project_id\": \"your_project_id\"}'")
-	fmt.Println("  ./distroforge-cli project.streamBuildOutput '{\"project_id\": \"your_project_id\", \"build_id\": \"your_project_id\"}'")
+	fmt.Println("  ./distroforge-cli diff your_project_id build_a build_b")
+	fmt.Println("  ./distroforge-cli project.streamBuildOutput '{\"project_id\": \"your_project_id\", \"build_id\": \"your_build_id\"}'")
 	fmt.Println("\nNote: Parameters must be a valid JSON string enclosed in single quotes.")
 }